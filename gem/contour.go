@@ -0,0 +1,316 @@
+package gem
+
+import (
+	"math"
+	"sort"
+)
+
+//#################################################################
+//                   Integer Geometry
+//#################################################################
+
+func (p Point2D) ToPoint() Point {
+	return Point{float64(p[0]), float64(p[1])}
+}
+
+// NewContour packs `pts` into a contour.
+func NewContour(pts []Point2D) Contour {
+	c := make(Contour, 0, 2*len(pts))
+	for _, p := range pts {
+		c = append(c, p[0], p[1])
+	}
+	return c
+}
+
+// Len returns the number of points of the contour.
+func (c Contour) Len() int {
+	return len(c) / 2
+}
+
+// At returns the point `i` of the contour.
+func (c Contour) At(i int) Point2D {
+	return Point2D(c[2*i : 2*i+2 : 2*i+2])
+}
+
+// ToPolygon converts the contour to a polygon.
+func (c Contour) ToPolygon() Polygon {
+	p := make(Polygon, c.Len())
+	for i := range p {
+		p[i] = c.At(i).ToPoint()
+	}
+	return p
+}
+
+// Rect2D is an integer rectangle of W x H pixels from (X; Y).
+type Rect2D struct {
+	X, Y, W, H int32
+}
+
+// BoundingBox returns the smallest rectangle of pixels holding the
+// contour.
+func (c Contour) BoundingBox() Rect2D {
+	if c.Len() == 0 {
+		return Rect2D{}
+	}
+	x0, y0, x1, y1 := c[0], c[1], c[0], c[1]
+	for i := 0; i < c.Len(); i++ {
+		p := c.At(i)
+		if p[0] < x0 {
+			x0 = p[0]
+		}
+		if p[0] > x1 {
+			x1 = p[0]
+		}
+		if p[1] < y0 {
+			y0 = p[1]
+		}
+		if p[1] > y1 {
+			y1 = p[1]
+		}
+	}
+	return Rect2D{x0, y0, x1 - x0 + 1, y1 - y0 + 1}
+}
+
+// SignedArea is positive for contours counter-clockwise in a y-up
+// frame, that is clockwise in image coordinates.
+func (c Contour) SignedArea() float64 {
+	var sum int64
+	n := c.Len()
+	for i := 0; i < n; i++ {
+		p, q := c.At(i), c.At((i+1)%n)
+		sum += int64(p[0])*int64(q[1]) - int64(q[0])*int64(p[1])
+	}
+	return float64(sum) / 2
+}
+
+func (c Contour) Area() float64 {
+	return math.Abs(c.SignedArea())
+}
+
+// Perimeter returns the length of the contour, including the segment
+// from the last point to the first when `closed` is set.
+func (c Contour) Perimeter(closed bool) float64 {
+	sum := 0.0
+	for _, s := range c.ToSegments() {
+		sum += s.Length()
+	}
+	if n := c.Len(); closed && n > 1 {
+		sum += (&Segment2D{c.At(n - 1), c.At(0)}).Length()
+	}
+	return sum
+}
+
+// Approx simplifies the contour by Douglas-Peucker with the tolerance
+// `eps`, keeping a subset of its points.
+func (c Contour) Approx(eps float64, closed bool) Contour {
+	p := c.ToPolygon()
+	var s []Point
+	if closed {
+		s = p.Simplify(eps)
+	} else {
+		s = SimplifyLine(p, eps)
+	}
+	ret := make(Contour, 0, 2*len(s))
+	for _, v := range s {
+		ret = append(ret, int32(v[0]), int32(v[1]))
+	}
+	return ret
+}
+
+// IsConvex tests whether the closed contour turns always in the same
+// direction, collinear points allowed.
+func (c Contour) IsConvex() bool {
+	n := c.Len()
+	if n < 3 {
+		return false
+	}
+	sign := 0
+	for i := 0; i < n; i++ {
+		o := orient2D(c.At(i), c.At((i+1)%n), c.At((i+2)%n))
+		if o == 0 {
+			continue
+		}
+		if sign != 0 && o != sign {
+			return false
+		}
+		sign = o
+	}
+	return sign != 0
+}
+
+// Hull returns the indices of the points of the convex hull of the
+// contour, in the order of the contour.
+func (c Contour) Hull() []int {
+	idx := hullIndices(c.ToPolygon())
+	// hull vertices keep their cyclic order along a simple contour
+	sort.Ints(idx)
+	return idx
+}
+
+// Defect is a convexity defect, the part of the contour from Start to
+// End, both on the hull, whose point Far is the deepest at Depth from
+// the hull edge.
+type Defect struct {
+	Start, End, Far int
+	Depth           float64
+}
+
+// ConvexityDefects returns the defects of the closed contour deeper
+// than zero.
+func (c Contour) ConvexityDefects() []Defect {
+	hull, n := c.Hull(), c.Len()
+	if len(hull) < 3 {
+		return nil
+	}
+	var ret []Defect
+	for k := range hull {
+		start, end := hull[k], hull[(k+1)%len(hull)]
+		a, b := c.At(start).ToPoint(), c.At(end).ToPoint()
+		d := Defect{Start: start, End: end, Far: -1}
+		for i := (start + 1) % n; i != end; i = (i + 1) % n {
+			p := c.At(i).ToPoint()
+			if depth := PerpDistToLine(p[0], p[1], a[0], a[1], b[0], b[1]); depth > d.Depth {
+				d.Far, d.Depth = i, depth
+			}
+		}
+		if d.Far >= 0 {
+			ret = append(ret, d)
+		}
+	}
+	return ret
+}
+
+// Moments are the spatial moments up to the third order of the region
+// bounded by a contour.
+type Moments struct {
+	M00, M10, M01, M20, M11, M02, M30, M21, M12, M03 float64
+}
+
+// Moments computes the moments of the region bounded by the closed
+// contour by Green's theorem, independent of its orientation.
+func (c Contour) Moments() Moments {
+	var m Moments
+	n := c.Len()
+	for i := 0; i < n; i++ {
+		p, q := c.At(i).ToPoint(), c.At((i+1)%n).ToPoint()
+		x0, y0, x1, y1 := p[0], p[1], q[0], q[1]
+		a := x0*y1 - x1*y0
+		m.M00 += a
+		m.M10 += a * (x0 + x1)
+		m.M01 += a * (y0 + y1)
+		m.M20 += a * (x0*x0 + x0*x1 + x1*x1)
+		m.M11 += a * (2*x0*y0 + x0*y1 + x1*y0 + 2*x1*y1)
+		m.M02 += a * (y0*y0 + y0*y1 + y1*y1)
+		m.M30 += a * (x0 + x1) * (x0*x0 + x1*x1)
+		m.M21 += a * (x1*x1*(3*y1+y0) + 2*x0*x1*(y0+y1) + x0*x0*(3*y0+y1))
+		m.M12 += a * (y1*y1*(3*x1+x0) + 2*y0*y1*(x0+x1) + y0*y0*(3*x0+x1))
+		m.M03 += a * (y0 + y1) * (y0*y0 + y1*y1)
+	}
+	s := 1.0
+	if m.M00 < 0 {
+		s = -1
+	}
+	m.M00 *= s / 2
+	m.M10 *= s / 6
+	m.M01 *= s / 6
+	m.M20 *= s / 12
+	m.M11 *= s / 24
+	m.M02 *= s / 12
+	m.M30 *= s / 20
+	m.M21 *= s / 60
+	m.M12 *= s / 60
+	m.M03 *= s / 20
+	return m
+}
+
+// Centroid returns the center of mass (M10/M00; M01/M00).
+func (m Moments) Centroid() Point {
+	return Point{m.M10 / m.M00, m.M01 / m.M00}
+}
+
+// Central returns the second order central moments.
+func (m Moments) Central() (mu20, mu11, mu02 float64) {
+	c := m.Centroid()
+	return m.M20 - c[0]*m.M10, m.M11 - c[0]*m.M01, m.M02 - c[1]*m.M01
+}
+
+//#################################################################
+//                   Segments
+//#################################################################
+
+func (s *Segment2D) Length() float64 {
+	return s.P1.ToPoint().Dist(s.P2.ToPoint())
+}
+
+// orient2D returns 1 if (a, b, c) turn counter-clockwise, -1 if they
+// turn clockwise and 0 if they are collinear, exactly.
+func orient2D(a, b, c Point2D) int {
+	d := (int64(b[0])-int64(a[0]))*(int64(c[1])-int64(a[1])) -
+		(int64(b[1])-int64(a[1]))*(int64(c[0])-int64(a[0]))
+	switch {
+	case d > 0:
+		return 1
+	case d < 0:
+		return -1
+	}
+	return 0
+}
+
+// within tests whether the point `c` collinear with [a, b] lies on it.
+func within(a, b, c Point2D) bool {
+	return (c[0] >= a[0] && c[0] <= b[0] || c[0] <= a[0] && c[0] >= b[0]) &&
+		(c[1] >= a[1] && c[1] <= b[1] || c[1] <= a[1] && c[1] >= b[1])
+}
+
+// Intersects tests exactly whether the segments share a point,
+// endpoints and collinear overlaps included.
+func (s *Segment2D) Intersects(o *Segment2D) bool {
+	d1, d2 := orient2D(o.P1, o.P2, s.P1), orient2D(o.P1, o.P2, s.P2)
+	d3, d4 := orient2D(s.P1, s.P2, o.P1), orient2D(s.P1, s.P2, o.P2)
+	if d1*d2 < 0 && d3*d4 < 0 {
+		return true
+	}
+	return d1 == 0 && within(o.P1, o.P2, s.P1) || d2 == 0 && within(o.P1, o.P2, s.P2) ||
+		d3 == 0 && within(s.P1, s.P2, o.P1) || d4 == 0 && within(s.P1, s.P2, o.P2)
+}
+
+// Intersection returns the point shared by the segments, ok false when
+// they are disjoint or overlap along a line.
+func (s *Segment2D) Intersection(o *Segment2D) (p Point, ok bool) {
+	if !s.Intersects(o) {
+		return nil, false
+	}
+	t, _, ok := segmentParams(s.P1.ToPoint(), s.P2.ToPoint(), o.P1.ToPoint(), o.P2.ToPoint())
+	if !ok {
+		// collinear, a single shared endpoint is still a point
+		for _, a := range []Point2D{s.P1, s.P2} {
+			for _, b := range []Point2D{o.P1, o.P2} {
+				if a[0] == b[0] && a[1] == b[1] && s.overlapLength(o) == 0 {
+					return a.ToPoint(), true
+				}
+			}
+		}
+		return nil, false
+	}
+	a, b := s.P1.ToPoint(), s.P2.ToPoint()
+	return Point{a[0] + t*(b[0]-a[0]), a[1] + t*(b[1]-a[1])}, true
+}
+
+// overlapLength returns the length shared by collinear segments.
+func (s *Segment2D) overlapLength(o *Segment2D) float64 {
+	a, b := s.P1.ToPoint(), s.P2.ToPoint()
+	d := b.Clone()
+	d.Sub(a)
+	l := d.Norm()
+	if l == 0 {
+		return 0
+	}
+	proj := func(p Point2D) float64 {
+		q := p.ToPoint()
+		q.Sub(a)
+		return q.Dot(d) / l
+	}
+	t0, t1 := proj(o.P1), proj(o.P2)
+	lo, hi := math.Max(0, math.Min(t0, t1)), math.Min(l, math.Max(t0, t1))
+	return math.Max(0, hi-lo)
+}
@@ -0,0 +1,145 @@
+package gem
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestContourConversions(t *testing.T) {
+	tests := []struct {
+		c    Contour
+		pts  []Point2D
+		segs int
+	}{
+		{Contour{}, []Point2D{}, 0},
+		{Contour{1, 2}, []Point2D{{1, 2}}, 0},
+		{Contour{1, 2, 3, 4}, []Point2D{{1, 2}, {3, 4}}, 1},
+		{Contour{0, 0, 4, 0, 4, 3, 0, 3}, []Point2D{{0, 0}, {4, 0}, {4, 3}, {0, 3}}, 3},
+		{Contour{0, 0, 4, 0, 7}, []Point2D{{0, 0}, {4, 0}}, 1}, // odd length
+	}
+	for _, tt := range tests {
+		pts := tt.c.ToPoint2D()
+		if !reflect.DeepEqual(pts, tt.pts) {
+			t.Errorf("%v: points %v, expected %v", tt.c, pts, tt.pts)
+		}
+		segs := tt.c.ToSegments()
+		if len(segs) != tt.segs {
+			t.Fatalf("%v: %d segments, expected %d", tt.c, len(segs), tt.segs)
+		}
+		for i, s := range segs {
+			if !reflect.DeepEqual(s.P1, tt.pts[i]) || !reflect.DeepEqual(s.P2, tt.pts[i+1]) {
+				t.Errorf("%v: segment %d is %v-%v", tt.c, i, s.P1, s.P2)
+			}
+		}
+		if len(pts) > 0 && !reflect.DeepEqual(NewContour(pts), tt.c[:2*len(pts)]) {
+			t.Errorf("%v: NewContour gives %v", tt.c, NewContour(pts))
+		}
+	}
+}
+
+func TestContourMeasures(t *testing.T) {
+	rect := Contour{0, 0, 4, 0, 4, 3, 0, 3}
+	l := Contour{0, 0, 4, 0, 4, 2, 2, 2, 2, 4, 0, 4}
+	tests := []struct {
+		name             string
+		c                Contour
+		bb               Rect2D
+		area             float64
+		open, closed     float64
+		convex           bool
+		centroid         Point
+		mu20, mu11, mu02 float64
+	}{
+		{"rect", rect, Rect2D{0, 0, 5, 4}, 12, 11, 14, true, Point{2, 1.5}, 16, 0, 9},
+		{"rect cw", NewContour(reverse2D(rect.ToPoint2D())), Rect2D{0, 0, 5, 4}, 12, 11, 14, true, Point{2, 1.5}, 16, 0, 9},
+		{"l", l, Rect2D{0, 0, 5, 5}, 12, 12, 16, false, Point{5.0 / 3, 5.0 / 3}, 44.0 / 3, -16.0 / 3, 44.0 / 3},
+		{"triangle", Contour{0, 0, 6, 0, 0, 6}, Rect2D{0, 0, 7, 7}, 18, 6 + math.Sqrt(72), 12 + math.Sqrt(72), true, Point{2, 2}, 36, -18, 36},
+	}
+	for _, tt := range tests {
+		m := tt.c.Moments()
+		mu20, mu11, mu02 := m.Central()
+		c := m.Centroid()
+		if tt.c.BoundingBox() != tt.bb || !near(tt.c.Area(), tt.area) || !near(m.M00, tt.area) ||
+			!near(tt.c.Perimeter(false), tt.open) || !near(tt.c.Perimeter(true), tt.closed) ||
+			tt.c.IsConvex() != tt.convex || !near(c[0], tt.centroid[0]) || !near(c[1], tt.centroid[1]) ||
+			!near(mu20, tt.mu20) || !near(mu11, tt.mu11) || !near(mu02, tt.mu02) {
+			t.Errorf("%s: bb %v area %v perimeters %v %v convex %v centroid %v mu %v %v %v", tt.name,
+				tt.c.BoundingBox(), tt.c.Area(), tt.c.Perimeter(false), tt.c.Perimeter(true),
+				tt.c.IsConvex(), c, mu20, mu11, mu02)
+		}
+		if p := tt.c.ToPolygon(); !near(p.Area(), tt.area) {
+			t.Errorf("%s: polygon area %v", tt.name, p.Area())
+		}
+	}
+}
+
+func reverse2D(pts []Point2D) []Point2D {
+	r := make([]Point2D, len(pts))
+	for i, p := range pts {
+		r[len(pts)-1-i] = p
+	}
+	return r
+}
+
+func TestContourApproxDefects(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       Contour
+		eps     float64
+		approx  Contour
+		defects []Defect
+	}{
+		{"noisy square", Contour{0, 0, 5, 1, 10, 0, 10, 10, 5, 9, 0, 10}, 2,
+			Contour{0, 0, 10, 0, 10, 10, 0, 10},
+			[]Defect{{0, 2, 1, 1}, {3, 5, 4, 1}}},
+		{"notch", Contour{0, 0, 10, 0, 10, 10, 5, 4, 0, 10}, 1,
+			Contour{0, 0, 10, 0, 10, 10, 5, 4, 0, 10},
+			[]Defect{{2, 4, 3, 6}}},
+		{"convex", Contour{0, 0, 10, 0, 10, 10, 0, 10}, 1,
+			Contour{0, 0, 10, 0, 10, 10, 0, 10}, nil},
+	}
+	for _, tt := range tests {
+		if a := tt.c.Approx(tt.eps, true); !reflect.DeepEqual(a, tt.approx) {
+			t.Errorf("%s: approximated to %v, expected %v", tt.name, a, tt.approx)
+		}
+		if d := tt.c.ConvexityDefects(); !reflect.DeepEqual(d, tt.defects) {
+			t.Errorf("%s: defects %v, expected %v", tt.name, d, tt.defects)
+		}
+	}
+	if a := (Contour{0, 0, 5, 1, 10, 0}).Approx(2, false); !reflect.DeepEqual(a, Contour{0, 0, 10, 0}) {
+		t.Errorf("open contour approximated to %v", a)
+	}
+}
+
+func TestSegment2DIntersection(t *testing.T) {
+	seg := func(x0, y0, x1, y1 int32) *Segment2D { return &Segment2D{Point2D{x0, y0}, Point2D{x1, y1}} }
+	tests := []struct {
+		name       string
+		s, o       *Segment2D
+		intersects bool
+		p          Point
+	}{
+		{"cross", seg(0, 0, 10, 10), seg(0, 10, 10, 0), true, Point{5, 5}},
+		{"disjoint", seg(0, 0, 10, 0), seg(0, 1, 10, 1), false, nil},
+		{"touch endpoint", seg(0, 0, 10, 0), seg(10, 0, 10, 5), true, Point{10, 0}},
+		{"touch interior", seg(0, 0, 10, 0), seg(5, 0, 5, 5), true, Point{5, 0}},
+		{"collinear overlap", seg(0, 0, 10, 0), seg(5, 0, 15, 0), true, nil},
+		{"collinear endpoint", seg(0, 0, 10, 0), seg(10, 0, 15, 0), true, Point{10, 0}},
+		{"collinear apart", seg(0, 0, 10, 0), seg(11, 0, 15, 0), false, nil},
+		{"lines cross outside", seg(0, 0, 1, 1), seg(0, 10, 10, 0), false, nil},
+		{"large coordinates", seg(-2e9, -2e9, 2e9, 2e9), seg(-2e9, 2e9, 2e9, -2e9), true, Point{0, 0}},
+	}
+	for _, tt := range tests {
+		if got := tt.s.Intersects(tt.o); got != tt.intersects {
+			t.Errorf("%s: intersects %v", tt.name, got)
+		}
+		if got := tt.o.Intersects(tt.s); got != tt.intersects {
+			t.Errorf("%s: reversed intersects %v", tt.name, got)
+		}
+		p, ok := tt.s.Intersection(tt.o)
+		if ok != (tt.p != nil) || ok && (!near(p[0], tt.p[0]) || !near(p[1], tt.p[1])) {
+			t.Errorf("%s: intersection %v %v, expected %v", tt.name, p, ok, tt.p)
+		}
+	}
+}
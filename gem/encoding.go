@@ -0,0 +1,586 @@
+package gem
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+//#################################################################
+//                   Geometry Encoding
+//#################################################################
+
+// shape is a geometry as written in WKT or GeoJSON, with the
+// coordinates of its kind: "Point", "LineString" or "Polygon".
+type shape struct {
+	kind  string
+	point []float64
+	line  [][]float64
+	rings [][][]float64
+}
+
+func points(ps []Point) [][]float64 {
+	line := make([][]float64, len(ps))
+	for i, p := range ps {
+		line[i] = p
+	}
+	return line
+}
+
+// ring closes the vertices of a polygon.
+func ring(ps []Point) [][]float64 {
+	if len(ps) == 0 {
+		return nil
+	}
+	return append(points(ps), ps[0])
+}
+
+// toShape converts a Point, Point2D, *Segment, *Rect, Contour or
+// Polygon.
+func toShape(g interface{}) (*shape, error) {
+	switch t := g.(type) {
+	case Point:
+		return &shape{kind: "Point", point: t}, nil
+	case Point2D:
+		return &shape{kind: "Point", point: t.ToPoint()}, nil
+	case *Segment:
+		return &shape{kind: "LineString", line: points([]Point{t.P1, t.P2})}, nil
+	case Segment:
+		return toShape(&t)
+	case Rect:
+		return toShape(&t)
+	case *Rect:
+		if len(t.C) != 2 {
+			return nil, DimError{2, len(t.C)}
+		}
+		x0, y0, x1, y1 := t.C[0]-t.P[0], t.C[1]-t.P[1], t.C[0]+t.P[0], t.C[1]+t.P[1]
+		return &shape{kind: "Polygon", rings: [][][]float64{ring([]Point{{x0, y0}, {x1, y0}, {x1, y1}, {x0, y1}})}}, nil
+	case Contour:
+		return &shape{kind: "LineString", line: points(t.ToPolygon())}, nil
+	case Polygon:
+		s := &shape{kind: "Polygon"}
+		if len(t) > 0 {
+			s.rings = [][][]float64{ring(t)}
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("gem: cannot encode %T", g)
+}
+
+// coords returns the positions of the shape.
+func (s *shape) coords() [][]float64 {
+	switch s.kind {
+	case "Point":
+		return [][]float64{s.point}
+	case "LineString":
+		return s.line
+	}
+	var all [][]float64
+	for _, r := range s.rings {
+		all = append(all, r...)
+	}
+	return all
+}
+
+func (s *shape) empty() bool {
+	if s.kind == "Point" {
+		return s.point == nil
+	}
+	return len(s.coords()) == 0
+}
+
+// dim checks that all the positions have the same dimension, at least
+// two, and returns it, zero for an empty shape.
+func (s *shape) dim() (int, error) {
+	if s.empty() {
+		return 0, nil
+	}
+	dim := 0
+	for _, p := range s.coords() {
+		if dim == 0 {
+			dim = len(p)
+		}
+		if len(p) != dim || dim < 2 {
+			return 0, DimError{dim, len(p)}
+		}
+		for _, x := range p {
+			if math.IsNaN(x) || math.IsInf(x, 0) {
+				return 0, fmt.Errorf("gem: cannot encode coordinate %v", x)
+			}
+		}
+	}
+	return dim, nil
+}
+
+// decode converts the shape to `g`, a *Point, *Point2D, *Segment,
+// *Rect, *Contour or *Polygon.
+func (s *shape) decode(g interface{}) error {
+	if _, err := s.dim(); err != nil {
+		return err
+	}
+	want := "Point"
+	switch g.(type) {
+	case *Segment, *Contour:
+		want = "LineString"
+	case *Rect, *Polygon:
+		want = "Polygon"
+	}
+	if s.kind != want {
+		return fmt.Errorf("gem: cannot decode %s into %T", s.kind, g)
+	}
+	switch t := g.(type) {
+	case *Point:
+		if s.point == nil {
+			return fmt.Errorf("gem: cannot decode an empty point")
+		}
+		*t = Point(s.point)
+	case *Point2D:
+		c, err := toContour([][]float64{s.point})
+		if err != nil {
+			return err
+		}
+		*t = c.At(0)
+	case *Segment:
+		if len(s.line) != 2 {
+			return fmt.Errorf("gem: cannot decode %d points into a segment", len(s.line))
+		}
+		*t = Segment{s.line[0], s.line[1]}
+	case *Contour:
+		c, err := toContour(s.line)
+		if err != nil {
+			return err
+		}
+		*t = c
+	case *Polygon:
+		p, err := s.polygon()
+		if err != nil {
+			return err
+		}
+		*t = p
+	case *Rect:
+		p, err := s.polygon()
+		if err != nil {
+			return err
+		}
+		r, err := toRect(p)
+		if err != nil {
+			return err
+		}
+		*t = *r
+	default:
+		return fmt.Errorf("gem: cannot decode into %T", g)
+	}
+	return nil
+}
+
+// polygon returns the outer ring without its closing vertex.
+func (s *shape) polygon() (Polygon, error) {
+	if len(s.rings) == 0 {
+		return Polygon{}, nil
+	}
+	if len(s.rings) > 1 {
+		return nil, fmt.Errorf("gem: cannot decode a polygon with holes")
+	}
+	r := s.rings[0]
+	if n := len(r); n > 1 && Point(r[0]).Dist(r[n-1]) == 0 {
+		r = r[:n-1]
+	}
+	p := make(Polygon, len(r))
+	for i, v := range r {
+		p[i] = v
+	}
+	return p, nil
+}
+
+func toContour(line [][]float64) (Contour, error) {
+	c := make(Contour, 0, 2*len(line))
+	for _, p := range line {
+		if len(p) != 2 {
+			return nil, DimError{2, len(p)}
+		}
+		for _, x := range p {
+			if x != math.Trunc(x) || x < math.MinInt32 || x > math.MaxInt32 {
+				return nil, fmt.Errorf("gem: cannot decode coordinate %v as an integer", x)
+			}
+			c = append(c, int32(x))
+		}
+	}
+	return c, nil
+}
+
+// toRect accepts the polygons whose vertices are the four corners of
+// their bounding box.
+func toRect(p Polygon) (*Rect, error) {
+	if len(p) != 4 || len(p[0]) != 2 {
+		return nil, fmt.Errorf("gem: cannot decode a polygon of %d vertices into a rectangle", len(p))
+	}
+	b := p.Bounds()
+	corners := make(map[[2]float64]bool)
+	for _, v := range p {
+		if math.Abs(v[0]-b.C[0]) != b.P[0] || math.Abs(v[1]-b.C[1]) != b.P[1] {
+			return nil, fmt.Errorf("gem: cannot decode a polygon that is not an axis-aligned rectangle")
+		}
+		corners[[2]float64{v[0], v[1]}] = true
+	}
+	if len(corners) != 4 {
+		return nil, fmt.Errorf("gem: cannot decode a degenerate rectangle")
+	}
+	return b, nil
+}
+
+//#################################################################
+//                   WKT
+//#################################################################
+
+func formatPosition(b *strings.Builder, p []float64) {
+	for i, x := range p {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(strconv.FormatFloat(x, 'g', -1, 64))
+	}
+}
+
+func formatLine(b *strings.Builder, line [][]float64) {
+	b.WriteByte('(')
+	for i, p := range line {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		formatPosition(b, p)
+	}
+	b.WriteByte(')')
+}
+
+func (s *shape) wkt() (string, error) {
+	dim, err := s.dim()
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	b.WriteString(strings.ToUpper(s.kind))
+	switch dim {
+	case 0:
+		b.WriteString(" EMPTY")
+		return b.String(), nil
+	case 3:
+		b.WriteString(" Z")
+	case 4:
+		b.WriteString(" ZM")
+	case 2:
+	default:
+		return "", DimError{4, dim}
+	}
+	b.WriteByte(' ')
+	switch s.kind {
+	case "Point":
+		b.WriteByte('(')
+		formatPosition(&b, s.point)
+		b.WriteByte(')')
+	case "LineString":
+		formatLine(&b, s.line)
+	default:
+		b.WriteByte('(')
+		for i, r := range s.rings {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			formatLine(&b, r)
+		}
+		b.WriteByte(')')
+	}
+	return b.String(), nil
+}
+
+// MarshalWKT writes a Point, Point2D, Segment, 2D *Rect, Contour or
+// Polygon as Well-Known Text. Segments and contours are line strings,
+// rectangles and polygons have their ring closed, and points of three
+// and four dimensions are written as Z and ZM.
+func MarshalWKT(g interface{}) (string, error) {
+	s, err := toShape(g)
+	if err != nil {
+		return "", err
+	}
+	return s.wkt()
+}
+
+// UnmarshalWKT parses the Well-Known Text `text` into `g`, a *Point,
+// *Point2D, *Segment, *Rect, *Contour or *Polygon, the reverse of
+// MarshalWKT. Polygons with holes are not supported.
+func UnmarshalWKT(text string, g interface{}) error {
+	s, err := parseWKT(text)
+	if err != nil {
+		return err
+	}
+	return s.decode(g)
+}
+
+// wktList is a parenthesized WKT list, of positions or of lists.
+type wktList struct {
+	pos   []float64
+	items []*wktList
+}
+
+type wktParser struct {
+	text string
+	i    int
+}
+
+func (p *wktParser) skip() {
+	for p.i < len(p.text) && unicode.IsSpace(rune(p.text[p.i])) {
+		p.i++
+	}
+}
+
+func (p *wktParser) word() string {
+	p.skip()
+	j := p.i
+	for p.i < len(p.text) && unicode.IsLetter(rune(p.text[p.i])) {
+		p.i++
+	}
+	return strings.ToUpper(p.text[j:p.i])
+}
+
+func (p *wktParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("gem: WKT at %d: %s", p.i, fmt.Sprintf(format, args...))
+}
+
+func (p *wktParser) list() (*wktList, error) {
+	p.skip()
+	if p.i >= len(p.text) || p.text[p.i] != '(' {
+		return nil, p.errorf("expected '('")
+	}
+	p.i++
+	l := &wktList{}
+	for {
+		p.skip()
+		if p.i < len(p.text) && p.text[p.i] == '(' {
+			item, err := p.list()
+			if err != nil {
+				return nil, err
+			}
+			l.items = append(l.items, item)
+		} else {
+			pos, err := p.position()
+			if err != nil {
+				return nil, err
+			}
+			l.items = append(l.items, &wktList{pos: pos})
+		}
+		p.skip()
+		if p.i >= len(p.text) {
+			return nil, p.errorf("expected ')'")
+		}
+		if p.text[p.i] == ')' {
+			p.i++
+			return l, nil
+		}
+		if p.text[p.i] != ',' {
+			return nil, p.errorf("expected ',' or ')'")
+		}
+		p.i++
+	}
+}
+
+func (p *wktParser) position() ([]float64, error) {
+	var pos []float64
+	for {
+		p.skip()
+		j := p.i
+		for p.i < len(p.text) && strings.IndexByte("+-.0123456789eE", p.text[p.i]) >= 0 {
+			p.i++
+		}
+		if j == p.i {
+			break
+		}
+		x, err := strconv.ParseFloat(p.text[j:p.i], 64)
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		pos = append(pos, x)
+	}
+	if len(pos) == 0 {
+		return nil, p.errorf("expected a coordinate")
+	}
+	return pos, nil
+}
+
+// line returns the positions of a list of positions.
+func (l *wktList) line() ([][]float64, error) {
+	line := make([][]float64, len(l.items))
+	for i, item := range l.items {
+		if item.pos == nil {
+			return nil, fmt.Errorf("gem: WKT expected a position")
+		}
+		line[i] = item.pos
+	}
+	return line, nil
+}
+
+func parseWKT(text string) (*shape, error) {
+	p := &wktParser{text: text}
+	s := &shape{}
+	switch p.word() {
+	case "POINT":
+		s.kind = "Point"
+	case "LINESTRING":
+		s.kind = "LineString"
+	case "POLYGON":
+		s.kind = "Polygon"
+	default:
+		return nil, p.errorf("unsupported geometry")
+	}
+	// the dimension tag is implied by the positions
+	tag := p.word()
+	if tag == "Z" || tag == "M" || tag == "ZM" {
+		tag = p.word()
+	}
+	if tag == "EMPTY" {
+		if p.skip(); p.i != len(text) {
+			return nil, p.errorf("unexpected text")
+		}
+		return s, nil
+	}
+	if tag != "" {
+		return nil, p.errorf("unexpected %q", tag)
+	}
+	l, err := p.list()
+	if err != nil {
+		return nil, err
+	}
+	if p.skip(); p.i != len(text) {
+		return nil, p.errorf("unexpected text")
+	}
+	switch s.kind {
+	case "Point":
+		line, err := l.line()
+		if err != nil || len(line) != 1 {
+			return nil, fmt.Errorf("gem: WKT point expects one position")
+		}
+		s.point = line[0]
+	case "LineString":
+		if s.line, err = l.line(); err != nil {
+			return nil, err
+		}
+	default:
+		for _, item := range l.items {
+			if item.pos != nil {
+				return nil, fmt.Errorf("gem: WKT polygon expects rings")
+			}
+			r, err := item.line()
+			if err != nil {
+				return nil, err
+			}
+			s.rings = append(s.rings, r)
+		}
+	}
+	return s, nil
+}
+
+//#################################################################
+//                   GeoJSON
+//#################################################################
+
+type geoJSON struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+func (s *shape) geoJSON() ([]byte, error) {
+	if _, err := s.dim(); err != nil {
+		return nil, err
+	}
+	var coords interface{}
+	switch s.kind {
+	case "Point":
+		coords = s.point
+	case "LineString":
+		coords = s.line
+	default:
+		coords = s.rings
+	}
+	if s.empty() {
+		// empty geometries have an empty array of coordinates
+		coords = []int{}
+	}
+	data, err := json.Marshal(coords)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(geoJSON{s.kind, data})
+}
+
+func parseGeoJSON(data []byte) (*shape, error) {
+	var g geoJSON
+	if err := json.Unmarshal(data, &g); err != nil {
+		return nil, err
+	}
+	s := &shape{kind: g.Type}
+	var dst interface{}
+	switch g.Type {
+	case "Point":
+		dst = &s.point
+	case "LineString":
+		dst = &s.line
+	case "Polygon":
+		dst = &s.rings
+	default:
+		return nil, fmt.Errorf("gem: unsupported GeoJSON type %q", g.Type)
+	}
+	if len(g.Coordinates) > 0 {
+		if err := json.Unmarshal(g.Coordinates, dst); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// MarshalGeoJSON writes a Point, Point2D, Segment, Rect, Contour or
+// Polygon as a GeoJSON geometry object. Points are written as Point,
+// segments and contours as LineString, and rectangles and polygons as
+// Polygon.
+func MarshalGeoJSON(g interface{}) ([]byte, error) {
+	s, err := toShape(g)
+	if err != nil {
+		return nil, err
+	}
+	return s.geoJSON()
+}
+
+// UnmarshalGeoJSON decodes the GeoJSON geometry object `data` into `g`,
+// a *Point, *Point2D, *Segment, *Rect, *Contour or *Polygon, the
+// reverse of MarshalGeoJSON. `g` is left unchanged for a JSON null.
+func UnmarshalGeoJSON(data []byte, g interface{}) error {
+	if string(data) == "null" {
+		return nil
+	}
+	s, err := parseGeoJSON(data)
+	if err != nil {
+		return err
+	}
+	return s.decode(g)
+}
+
+// GeoJSON wraps a geometry so that encoding/json writes it as a GeoJSON
+// geometry object, while the geometry types keep their default encoding.
+// To decode, Geometry has to hold the destination pointer beforehand.
+type GeoJSON struct {
+	Geometry interface{}
+}
+
+func (g GeoJSON) MarshalJSON() ([]byte, error) {
+	if g.Geometry == nil {
+		return []byte("null"), nil
+	}
+	return MarshalGeoJSON(g.Geometry)
+}
+
+func (g *GeoJSON) UnmarshalJSON(data []byte) error {
+	if g.Geometry == nil {
+		return fmt.Errorf("gem: no geometry to decode GeoJSON into")
+	}
+	return UnmarshalGeoJSON(data, g.Geometry)
+}
@@ -0,0 +1,149 @@
+package gem
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestWKT(t *testing.T) {
+	tests := []struct {
+		g   interface{}
+		wkt string
+		dst interface{} // a pointer to decode into
+	}{
+		{Point{1, 2.5}, "POINT (1 2.5)", new(Point)},
+		{Point{1, 2, 3}, "POINT Z (1 2 3)", new(Point)},
+		{Point2D{3, -4}, "POINT (3 -4)", new(Point2D)},
+		{&Segment{Point{0, 0}, Point{1e-7, 2}}, "LINESTRING (0 0, 1e-07 2)", new(Segment)},
+		{&Rect{Point{1, 1}, Point{1, 0.5}}, "POLYGON ((0 0.5, 2 0.5, 2 1.5, 0 1.5, 0 0.5))", new(Rect)},
+		{Contour{0, 0, 4, 0, 4, 3}, "LINESTRING (0 0, 4 0, 4 3)", new(Contour)},
+		{Contour{}, "LINESTRING EMPTY", new(Contour)},
+		{square(0, 0, 1), "POLYGON ((0 0, 1 0, 1 1, 0 1, 0 0))", new(Polygon)},
+		{Polygon{}, "POLYGON EMPTY", new(Polygon)},
+	}
+	for _, tt := range tests {
+		s, err := MarshalWKT(tt.g)
+		if err != nil || s != tt.wkt {
+			t.Errorf("%v: WKT %q %v, expected %q", tt.g, s, err, tt.wkt)
+			continue
+		}
+		if err := UnmarshalWKT(s, tt.dst); err != nil {
+			t.Errorf("%q: %v", s, err)
+			continue
+		}
+		got := reflect.ValueOf(tt.dst).Elem().Interface()
+		if reflect.ValueOf(tt.g).Kind() == reflect.Ptr {
+			got = tt.dst
+		}
+		if !reflect.DeepEqual(got, tt.g) {
+			t.Errorf("%q decoded to %v, expected %v", s, got, tt.g)
+		}
+	}
+}
+
+func TestParseWKT(t *testing.T) {
+	var p Point
+	if err := UnmarshalWKT(" point z(1 2 3) ", &p); err != nil || !reflect.DeepEqual(p, Point{1, 2, 3}) {
+		t.Errorf("lower case point %v %v", p, err)
+	}
+	var poly Polygon
+	if err := UnmarshalWKT("POLYGON((0 0,2 0,0 2))", &poly); err != nil || !reflect.DeepEqual(poly, Polygon{{0, 0}, {2, 0}, {0, 2}}) {
+		t.Errorf("unclosed ring %v %v", poly, err)
+	}
+	for _, bad := range []struct {
+		wkt string
+		dst interface{}
+	}{
+		{"POINT (1)", new(Point)},
+		{"POINT (1 2", new(Point)},
+		{"POINT (1 2, 3 4)", new(Point)},
+		{"POINT (1 2) x", new(Point)},
+		{"CIRCLE (1 2)", new(Point)},
+		{"LINESTRING (1 2, 3)", new(Contour)},
+		{"LINESTRING (1 2, 3 4, 5 6)", new(Segment)},
+		{"LINESTRING (1.5 2, 3 4)", new(Contour)},
+		{"LINESTRING (1 2, 3 4)", new(Polygon)},
+		{"POLYGON ((0 0, 1 0, 1 1, 0 0), (0 0, 1 0, 1 1, 0 0))", new(Polygon)},
+		{"POLYGON ((0 0, 2 0, 1 1, 0 1, 0 0))", new(Rect)},
+		{"POLYGON (1 2)", new(Polygon)},
+	} {
+		if err := UnmarshalWKT(bad.wkt, bad.dst); err == nil {
+			t.Errorf("%q decoded into %T", bad.wkt, bad.dst)
+		}
+	}
+	if _, err := MarshalWKT(Point{1}); err == nil {
+		t.Errorf("encoded a point of one dimension")
+	}
+	if _, err := MarshalWKT(&Rect{Point{0, 0, 0}, Point{1, 1, 1}}); err == nil {
+		t.Errorf("encoded a 3D rectangle")
+	}
+}
+
+func TestGeoJSON(t *testing.T) {
+	tests := []struct {
+		g        interface{}
+		dst      interface{}
+		expected string
+	}{
+		{Point{1, 2}, new(Point), `{"type":"Point","coordinates":[1,2]}`},
+		{Point2D{3, 4}, new(Point2D), `{"type":"Point","coordinates":[3,4]}`},
+		{&Segment{Point{0, 0}, Point{1, 1}}, new(Segment), `{"type":"LineString","coordinates":[[0,0],[1,1]]}`},
+		{&Rect{Point{1, 1}, Point{1, 1}}, new(Rect), `{"type":"Polygon","coordinates":[[[0,0],[2,0],[2,2],[0,2],[0,0]]]}`},
+		{square(0, 0, 2), new(Polygon), `{"type":"Polygon","coordinates":[[[0,0],[2,0],[2,2],[0,2],[0,0]]]}`},
+		{Contour{1, 2, 3, 4}, new(Contour), `{"type":"LineString","coordinates":[[1,2],[3,4]]}`},
+		{Polygon{}, new(Polygon), `{"type":"Polygon","coordinates":[]}`},
+	}
+	for _, tt := range tests {
+		data, err := MarshalGeoJSON(tt.g)
+		if err != nil || string(data) != tt.expected {
+			t.Errorf("%v encoded to %s %v", tt.g, data, err)
+			continue
+		}
+		if err := UnmarshalGeoJSON(data, tt.dst); err != nil {
+			t.Errorf("%s: %v", data, err)
+			continue
+		}
+		if !reflect.DeepEqual(reflect.ValueOf(tt.dst).Elem().Interface(), reflect.Indirect(reflect.ValueOf(tt.g)).Interface()) {
+			t.Errorf("%s decoded to %v", data, tt.dst)
+		}
+	}
+	var p Point
+	if err := UnmarshalGeoJSON([]byte(`{"type":"LineString","coordinates":[[0,0],[1,1]]}`), &p); err == nil {
+		t.Errorf("decoded a line string into a point")
+	}
+}
+
+func TestGeoJSONWrapper(t *testing.T) {
+	// the geometry types keep the default encoding of their fields,
+	// GeoJSON is chosen with the wrapper
+	type feature struct {
+		Center Point
+		Box    Rect
+		Shape  GeoJSON
+		None   GeoJSON
+	}
+	f := feature{Point{1, 2}, Rect{Point{1, 1}, Point{1, 1}}, GeoJSON{square(0, 0, 2)}, GeoJSON{}}
+	data, err := json.Marshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"Center":[1,2],"Box":{"C":[1,1],"P":[1,1]},` +
+		`"Shape":{"type":"Polygon","coordinates":[[[0,0],[2,0],[2,2],[0,2],[0,0]]]},"None":null}`
+	if string(data) != expected {
+		t.Errorf("encoded to %s", data)
+	}
+	var shape Polygon
+	g := feature{Shape: GeoJSON{&shape}, None: GeoJSON{new(Point)}}
+	if err := json.Unmarshal(data, &g); err != nil || !reflect.DeepEqual(g.Center, f.Center) ||
+		!reflect.DeepEqual(g.Box, f.Box) || !reflect.DeepEqual(shape, f.Shape.Geometry) {
+		t.Errorf("decoded to %+v %v", g, err)
+	}
+	var q Point
+	if err := json.Unmarshal([]byte("[1,2]"), &q); err != nil || !reflect.DeepEqual(q, Point{1, 2}) {
+		t.Errorf("decoded an array to %v %v", q, err)
+	}
+	if err := json.Unmarshal(data, &feature{}); err == nil {
+		t.Errorf("decoded GeoJSON without a geometry")
+	}
+}
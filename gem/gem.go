@@ -333,6 +333,11 @@ func (r *Rect) containsPoint(p Point) bool {
 	return true
 }
 
+// ContainsPoint tests whether p is located inside or on the boundary of r.
+func (r *Rect) ContainsPoint(p Point) bool {
+	return r.containsPoint(p)
+}
+
 func (r *Rect) Bounds() *Rect {
 	return r
 }
@@ -485,20 +490,26 @@ func (p Point2D) Y() int32 {
 	return p[1]
 }
 
+// ToPoint2D returns the points of the contour, which share its storage.
 func (c Contour) ToPoint2D() []Point2D {
 	s := len(c) / 2
 	pts := make([]Point2D, s)
-	for i := 0; i < s; i += 2 {
-		pts[i] = ([]int32)(c[i : i+2])
+	for i := range pts {
+		pts[i] = Point2D(c[2*i : 2*i+2 : 2*i+2])
 	}
 	return pts
 }
 
+// ToSegments returns the segments joining the consecutive points of the
+// contour, without the closing one.
 func (c Contour) ToSegments() []*Segment2D {
-	s := len(c) / 2
-	segs := make([]*Segment2D, s-1)
-	for i := 0; i < s; i += 2 {
-		segs[i] = &Segment2D{([]int32)(c[i : i+2]), ([]int32)(c[i+2 : i+4])}
+	pts := c.ToPoint2D()
+	if len(pts) < 2 {
+		return nil
+	}
+	segs := make([]*Segment2D, len(pts)-1)
+	for i := range segs {
+		segs[i] = &Segment2D{pts[i], pts[i+1]}
 	}
 	return segs
 }
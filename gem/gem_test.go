@@ -38,6 +38,9 @@ func TestPointInPoly(t *testing.T) {
 	if !IsPointInPoly(x0, y0, 1, 20) {
 		t.Errorf("(1, 20) inside polygon")
 	}
+	if !IsPointInPoly(x0, y0, 99, 1) {
+		t.Errorf("(99, 1) inside polygon")
+	}
 	if IsPointInPoly(x0, y0, -5, 20) {
 		t.Errorf("(-5, 20) outside polygon")
 	}
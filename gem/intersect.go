@@ -0,0 +1,475 @@
+package gem
+
+import (
+	"container/heap"
+	"math"
+	"math/big"
+	"math/rand"
+	"sort"
+)
+
+//#################################################################
+//                   Orientation Predicates
+//#################################################################
+
+// ccwErrBound bounds the rounding error of the floating point
+// orientation determinant relative to the sum of its terms.
+const ccwErrBound = (3 + 16*machEps) * machEps
+
+const machEps = 1.0 / (1 << 53)
+
+// Orient returns 1 if (a, b, c) turn counter-clockwise, -1 if they turn
+// clockwise and 0 if they are collinear. The sign is exact: the
+// floating point determinant is only trusted beyond its error bound
+// and is recomputed with rationals otherwise.
+func Orient(a, b, c Point) int {
+	l := (a[0] - c[0]) * (b[1] - c[1])
+	r := (a[1] - c[1]) * (b[0] - c[0])
+	det, bound := l-r, ccwErrBound*(math.Abs(l)+math.Abs(r))
+	switch {
+	case det > bound:
+		return 1
+	case -det > bound:
+		return -1
+	}
+	return orientExact(a, b, c)
+}
+
+func orientExact(a, b, c Point) int {
+	rat := func(x float64) *big.Rat { return new(big.Rat).SetFloat64(x) }
+	diff := func(x, y float64) *big.Rat { d := rat(x); return d.Sub(d, rat(y)) }
+	l := diff(a[0], c[0])
+	l.Mul(l, diff(b[1], c[1]))
+	r := diff(a[1], c[1])
+	r.Mul(r, diff(b[0], c[0]))
+	return l.Cmp(r)
+}
+
+// between tests whether the point `c` collinear with [a, b] lies on it.
+func between(a, b, c Point) bool {
+	return (c[0] >= a[0] && c[0] <= b[0] || c[0] <= a[0] && c[0] >= b[0]) &&
+		(c[1] >= a[1] && c[1] <= b[1] || c[1] <= a[1] && c[1] >= b[1])
+}
+
+// Intersects tests exactly whether the segments share a point,
+// endpoints and collinear overlaps included.
+func (s *Segment) Intersects(o *Segment) bool {
+	d1, d2 := Orient(o.P1, o.P2, s.P1), Orient(o.P1, o.P2, s.P2)
+	d3, d4 := Orient(s.P1, s.P2, o.P1), Orient(s.P1, s.P2, o.P2)
+	if d1*d2 < 0 && d3*d4 < 0 {
+		return true
+	}
+	return d1 == 0 && between(o.P1, o.P2, s.P1) || d2 == 0 && between(o.P1, o.P2, s.P2) ||
+		d3 == 0 && between(s.P1, s.P2, o.P1) || d4 == 0 && between(s.P1, s.P2, o.P2)
+}
+
+// Intersection returns the point shared by the segments, ok false when
+// they are disjoint or overlap along a line. An endpoint lying on the
+// other segment is returned exactly, a crossing point is rounded.
+func (s *Segment) Intersection(o *Segment) (p Point, ok bool) {
+	d1, d2 := Orient(o.P1, o.P2, s.P1), Orient(o.P1, o.P2, s.P2)
+	d3, d4 := Orient(s.P1, s.P2, o.P1), Orient(s.P1, s.P2, o.P2)
+	if d1 == 0 && d2 == 0 && d3 == 0 && d4 == 0 {
+		// collinear, a single shared point is still a point
+		var shared Point
+		for _, c := range [][3]Point{{s.P1, o.P1, o.P2}, {s.P2, o.P1, o.P2}, {o.P1, s.P1, s.P2}, {o.P2, s.P1, s.P2}} {
+			if !between(c[1], c[2], c[0]) {
+				continue
+			}
+			if shared != nil && (shared[0] != c[0][0] || shared[1] != c[0][1]) {
+				return nil, false
+			}
+			shared = c[0]
+		}
+		if shared == nil {
+			return nil, false
+		}
+		return shared.Clone(), true
+	}
+	switch {
+	case d1 == 0 && between(o.P1, o.P2, s.P1):
+		return s.P1.Clone(), true
+	case d2 == 0 && between(o.P1, o.P2, s.P2):
+		return s.P2.Clone(), true
+	case d3 == 0 && between(s.P1, s.P2, o.P1):
+		return o.P1.Clone(), true
+	case d4 == 0 && between(s.P1, s.P2, o.P2):
+		return o.P2.Clone(), true
+	case d1*d2 < 0 && d3*d4 < 0:
+		t, _, _ := segmentParams(s.P1, s.P2, o.P1, o.P2)
+		t = math.Max(0, math.Min(1, t))
+		return Point{s.P1[0] + t*(s.P2[0]-s.P1[0]), s.P1[1] + t*(s.P2[1]-s.P1[1])}, true
+	}
+	return nil, false
+}
+
+//#################################################################
+//                   Sweep Line
+//#################################################################
+
+// Crossing is a point shared by the segments of indices Segments.
+type Crossing struct {
+	Point    Point
+	Segments []int
+}
+
+// sweepSeg is a segment from its left endpoint, of least x then y, to
+// its right one.
+type sweepSeg struct {
+	id   int
+	l, r Point
+	node *sweepNode
+}
+
+// slope is infinite for vertical segments, which come last among the
+// segments through a point.
+func (s *sweepSeg) slope() float64 {
+	if s.l[0] == s.r[0] {
+		return math.Inf(1)
+	}
+	return (s.r[1] - s.l[1]) / (s.r[0] - s.l[0])
+}
+
+// sweepNode is a node of the treap holding the segments crossing the
+// sweep line from bottom to top.
+type sweepNode struct {
+	seg                 *sweepSeg
+	prio                uint32
+	left, right, parent *sweepNode
+}
+
+func (n *sweepNode) next() *sweepNode {
+	if n.right != nil {
+		n = n.right
+		for n.left != nil {
+			n = n.left
+		}
+		return n
+	}
+	for n.parent != nil && n.parent.right == n {
+		n = n.parent
+	}
+	return n.parent
+}
+
+func (n *sweepNode) prev() *sweepNode {
+	if n.left != nil {
+		n = n.left
+		for n.right != nil {
+			n = n.right
+		}
+		return n
+	}
+	for n.parent != nil && n.parent.left == n {
+		n = n.parent
+	}
+	return n.parent
+}
+
+type sweepEvent struct {
+	p     Point
+	start []*sweepSeg // segments whose left endpoint is p
+	hint  []*sweepSeg // segments of the sweep line expected through p
+}
+
+type sweepQueue []*sweepEvent
+
+func (q sweepQueue) Len() int      { return len(q) }
+func (q sweepQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q sweepQueue) Less(i, j int) bool {
+	return lexLess(q[i].p, q[j].p)
+}
+func (q *sweepQueue) Push(x interface{}) { *q = append(*q, x.(*sweepEvent)) }
+func (q *sweepQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+func lexLess(p, q Point) bool {
+	return p[0] < q[0] || p[0] == q[0] && p[1] < q[1]
+}
+
+type sweep struct {
+	root   *sweepNode
+	queue  sweepQueue
+	events map[[2]float64]*sweepEvent
+	p      Point // the current event
+	tol    float64
+	rnd    *rand.Rand
+}
+
+func (sw *sweep) event(p Point) *sweepEvent {
+	k := [2]float64{p[0], p[1]}
+	e, ok := sw.events[k]
+	if !ok {
+		e = &sweepEvent{p: p}
+		sw.events[k] = e
+		heap.Push(&sw.queue, e)
+	}
+	return e
+}
+
+// yAt returns the ordinate of `s` on the sweep line, that of the
+// current event for a vertical segment through it.
+func (sw *sweep) yAt(s *sweepSeg) float64 {
+	x := sw.p[0]
+	switch {
+	case s.l[0] == s.r[0]:
+		return math.Max(s.l[1], math.Min(s.r[1], sw.p[1]))
+	case x <= s.l[0]:
+		return s.l[1]
+	case x >= s.r[0]:
+		return s.r[1]
+	}
+	return s.l[1] + (x-s.l[0])*(s.r[1]-s.l[1])/(s.r[0]-s.l[0])
+}
+
+// below orders the segments just right of the current event.
+func (sw *sweep) below(a, b *sweepSeg) bool {
+	if ya, yb := sw.yAt(a), sw.yAt(b); math.Abs(ya-yb) > sw.tol {
+		return ya < yb
+	}
+	if sa, sb := a.slope(), b.slope(); sa != sb {
+		return sa < sb
+	}
+	return a.id < b.id
+}
+
+// through tests whether `s` passes within the tolerance of the current
+// event.
+func (sw *sweep) through(s *sweepSeg) bool {
+	d := s.r.Clone()
+	d.Sub(s.l)
+	q := sw.p.Clone()
+	q.Sub(s.l)
+	t := 0.0
+	if l := d.Dot(d); l > 0 {
+		t = math.Max(0, math.Min(1, q.Dot(d)/l))
+	}
+	return math.Hypot(q[0]-t*d[0], q[1]-t*d[1]) <= sw.tol
+}
+
+func (sw *sweep) rotateUp(n *sweepNode) {
+	p := n.parent
+	g := p.parent
+	if p.left == n {
+		p.left = n.right
+		if n.right != nil {
+			n.right.parent = p
+		}
+		n.right = p
+	} else {
+		p.right = n.left
+		if n.left != nil {
+			n.left.parent = p
+		}
+		n.left = p
+	}
+	p.parent, n.parent = n, g
+	switch {
+	case g == nil:
+		sw.root = n
+	case g.left == p:
+		g.left = n
+	default:
+		g.right = n
+	}
+}
+
+func (sw *sweep) insert(s *sweepSeg) {
+	n := &sweepNode{seg: s, prio: sw.rnd.Uint32()}
+	s.node = n
+	link := &sw.root
+	for *link != nil {
+		n.parent = *link
+		if sw.below(s, (*link).seg) {
+			link = &(*link).left
+		} else {
+			link = &(*link).right
+		}
+	}
+	*link = n
+	for n.parent != nil && n.prio > n.parent.prio {
+		sw.rotateUp(n)
+	}
+}
+
+func (sw *sweep) remove(s *sweepSeg) {
+	n := s.node
+	for n.left != nil && n.right != nil {
+		if n.left.prio > n.right.prio {
+			sw.rotateUp(n.left)
+		} else {
+			sw.rotateUp(n.right)
+		}
+	}
+	c := n.left
+	if c == nil {
+		c = n.right
+	}
+	if c != nil {
+		c.parent = n.parent
+	}
+	switch {
+	case n.parent == nil:
+		sw.root = c
+	case n.parent.left == n:
+		n.parent.left = c
+	default:
+		n.parent.right = c
+	}
+	s.node = nil
+}
+
+// locate returns the lowest node of the sweep line not below the
+// current event.
+func (sw *sweep) locate() *sweepNode {
+	var found *sweepNode
+	for n := sw.root; n != nil; {
+		if sw.yAt(n.seg) >= sw.p[1]-sw.tol {
+			found, n = n, n.left
+		} else {
+			n = n.right
+		}
+	}
+	return found
+}
+
+// check schedules the crossing of `a` and `b` right of the current
+// event.
+func (sw *sweep) check(a, b *sweepNode) {
+	if a == nil || b == nil {
+		return
+	}
+	p, ok := (&Segment{a.seg.l, a.seg.r}).Intersection(&Segment{b.seg.l, b.seg.r})
+	if !ok || !lexLess(sw.p, p) || math.Abs(p[0]-sw.p[0]) <= sw.tol && math.Abs(p[1]-sw.p[1]) <= sw.tol {
+		return
+	}
+	e := sw.event(p)
+	e.hint = append(e.hint, a.seg, b.seg)
+}
+
+// pop returns the next event, merged with the ones within the
+// tolerance of it.
+func (sw *sweep) pop() *sweepEvent {
+	e := heap.Pop(&sw.queue).(*sweepEvent)
+	delete(sw.events, [2]float64{e.p[0], e.p[1]})
+	for len(sw.queue) > 0 {
+		o := sw.queue[0]
+		if math.Abs(o.p[0]-e.p[0]) > sw.tol || math.Abs(o.p[1]-e.p[1]) > sw.tol {
+			break
+		}
+		heap.Pop(&sw.queue)
+		delete(sw.events, [2]float64{o.p[0], o.p[1]})
+		e.start, e.hint = append(e.start, o.start...), append(e.hint, o.hint...)
+	}
+	return e
+}
+
+// handle processes the event `e` and returns the segments through it.
+func (sw *sweep) handle(e *sweepEvent) []int {
+	sw.p = e.p
+	// the segments of the sweep line through p form a run around it
+	in := make(map[*sweepSeg]bool)
+	var through []*sweepSeg
+	add := func(s *sweepSeg) {
+		if s.node != nil && !in[s] && sw.through(s) {
+			in[s] = true
+			through = append(through, s)
+		}
+	}
+	for _, s := range e.hint {
+		add(s)
+	}
+	n0 := sw.locate()
+	for n := n0; n != nil && sw.yAt(n.seg) <= sw.p[1]+sw.tol; n = n.next() {
+		add(n.seg)
+	}
+	if n0 != nil {
+		for n := n0.prev(); n != nil && sw.yAt(n.seg) >= sw.p[1]-sw.tol; n = n.prev() {
+			add(n.seg)
+		}
+	}
+	ids := make([]int, 0, len(through)+len(e.start))
+	for _, s := range through {
+		ids = append(ids, s.id)
+	}
+	for _, s := range e.start {
+		ids = append(ids, s.id)
+	}
+	var lo, hi *sweepNode
+	if len(through) > 0 {
+		lo, hi = through[0].node, through[0].node
+		for lo.prev() != nil && in[lo.prev().seg] {
+			lo = lo.prev()
+		}
+		for hi.next() != nil && in[hi.next().seg] {
+			hi = hi.next()
+		}
+		lo, hi = lo.prev(), hi.next()
+	}
+	for _, s := range through {
+		sw.remove(s)
+	}
+	// reinsert the segments going on right of p, and insert the new ones
+	var inserted []*sweepSeg
+	for _, s := range append(through, e.start...) {
+		if s.r[0]-sw.p[0] > sw.tol || math.Abs(s.r[0]-sw.p[0]) <= sw.tol && s.r[1]-sw.p[1] > sw.tol {
+			sw.insert(s)
+			inserted = append(inserted, s)
+			in[s] = true
+		}
+	}
+	if len(inserted) == 0 {
+		sw.check(lo, hi)
+		return ids
+	}
+	lo, hi = inserted[0].node, inserted[0].node
+	for lo.prev() != nil && in[lo.prev().seg] {
+		lo = lo.prev()
+	}
+	for hi.next() != nil && in[hi.next().seg] {
+		hi = hi.next()
+	}
+	sw.check(lo.prev(), lo)
+	sw.check(hi, hi.next())
+	return ids
+}
+
+// Intersections returns the points shared by at least two of the plane
+// segments `segs` in O((N + K) log N) time for K such points, by the
+// Bentley-Ottmann sweep. The orientation tests are exact, crossing
+// points closer than a relative 1e-9 are merged, and overlapping
+// collinear segments are reported at the ends of their overlap. The
+// crossings come sorted by x then y.
+func Intersections(segs []*Segment) []Crossing {
+	sw := &sweep{events: make(map[[2]float64]*sweepEvent), tol: 1, rnd: rand.New(rand.NewSource(1))}
+	for _, s := range segs {
+		for _, p := range []Point{s.P1, s.P2} {
+			if len(p) != 2 {
+				panic(DimError{2, len(p)})
+			}
+			sw.tol = math.Max(sw.tol, p.AbsMax())
+		}
+	}
+	sw.tol *= 1e-9
+	for i, s := range segs {
+		ss := &sweepSeg{id: i, l: s.P1, r: s.P2}
+		if lexLess(ss.r, ss.l) {
+			ss.l, ss.r = ss.r, ss.l
+		}
+		sw.event(ss.l).start = append(sw.event(ss.l).start, ss)
+		sw.event(ss.r).hint = append(sw.event(ss.r).hint, ss)
+	}
+	var ret []Crossing
+	for len(sw.queue) > 0 {
+		e := sw.pop()
+		if ids := sw.handle(e); len(ids) > 1 {
+			sort.Ints(ids)
+			ret = append(ret, Crossing{e.p.Clone(), ids})
+		}
+	}
+	return ret
+}
@@ -0,0 +1,147 @@
+package gem
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestOrient(t *testing.T) {
+	// nearly collinear points where the plain determinant is wrong
+	a, b := Point{0.5, 0.5}, Point{12, 12}
+	for i := 0; i < 64; i++ {
+		c := Point{24 + float64(i)*math.Pow(2, -48), 24}
+		l, r := (a[0]-c[0])*(b[1]-c[1]), (a[1]-c[1])*(b[0]-c[0])
+		exact := orientExact(a, b, c)
+		if got := Orient(a, b, c); got != exact {
+			t.Errorf("%v: orientation %d, exact %d, determinant %v", c, got, exact, l-r)
+		}
+		if i > 0 && exact != -1 {
+			t.Errorf("%v: exact orientation %d", c, exact)
+		}
+	}
+	if Orient(Point{0, 0}, Point{1, 0}, Point{0, 1}) != 1 || Orient(Point{0, 0}, Point{0, 1}, Point{1, 0}) != -1 {
+		t.Errorf("orientation of a right angle")
+	}
+}
+
+func TestSegmentIntersection(t *testing.T) {
+	seg := func(x0, y0, x1, y1 float64) *Segment { return &Segment{Point{x0, y0}, Point{x1, y1}} }
+	tests := []struct {
+		name       string
+		s, o       *Segment
+		intersects bool
+		p          Point
+	}{
+		{"cross", seg(0, 0, 1, 1), seg(0, 1, 1, 0), true, Point{0.5, 0.5}},
+		{"disjoint", seg(0, 0, 1, 0), seg(0, 1, 1, 1), false, nil},
+		{"touch", seg(0, 0, 0.3, 0.3), seg(0.3, 0.3, 1, 0), true, Point{0.3, 0.3}},
+		{"endpoint inside", seg(0, 0, 0.3, 0.1), seg(0.15, 0.05, 1, 1), true, Point{0.15, 0.05}},
+		{"collinear overlap", seg(0, 0, 2, 2), seg(1, 1, 3, 3), true, nil},
+		{"collinear touch", seg(0, 0, 1, 1), seg(1, 1, 3, 3), true, Point{1, 1}},
+		{"collinear apart", seg(0, 0, 1, 1), seg(2, 2, 3, 3), false, nil},
+		{"point on segment", seg(0, 0, 2, 2), seg(1, 1, 1, 1), true, Point{1, 1}},
+		{"point off segment", seg(0, 0, 2, 2), seg(1, 0, 1, 0), false, nil},
+		{"lines cross outside", seg(0, 0, 1, 1), seg(0, 3, 3, 0), false, nil},
+	}
+	for _, tt := range tests {
+		if got := tt.s.Intersects(tt.o); got != tt.intersects {
+			t.Errorf("%s: intersects %v", tt.name, got)
+		}
+		if got := tt.o.Intersects(tt.s); got != tt.intersects {
+			t.Errorf("%s: reversed intersects %v", tt.name, got)
+		}
+		p, ok := tt.s.Intersection(tt.o)
+		if ok != (tt.p != nil) || ok && (!near(p[0], tt.p[0]) || !near(p[1], tt.p[1])) {
+			t.Errorf("%s: intersection %v %v, expected %v", tt.name, p, ok, tt.p)
+		}
+	}
+	// the diagonals used to look like crossing because of a negative
+	// squared distance
+	if _, s := IntersectLines(0, 0, 1, 1, 0, 3, 3, 0); s != 0 {
+		t.Errorf("lines crossing outside the segments: state %d", s)
+	}
+}
+
+type pair struct{ i, j int }
+
+func crossingPairs(cs []Crossing) []pair {
+	set := make(map[pair]bool)
+	for _, c := range cs {
+		for a := range c.Segments {
+			for b := a + 1; b < len(c.Segments); b++ {
+				set[pair{c.Segments[a], c.Segments[b]}] = true
+			}
+		}
+	}
+	return sortedPairs(set)
+}
+
+func sortedPairs(set map[pair]bool) []pair {
+	ps := make([]pair, 0, len(set))
+	for p := range set {
+		ps = append(ps, p)
+	}
+	sort.Slice(ps, func(a, b int) bool { return ps[a].i < ps[b].i || ps[a].i == ps[b].i && ps[a].j < ps[b].j })
+	return ps
+}
+
+func TestIntersections(t *testing.T) {
+	// a star of four segments through (5; 5), one of them vertical,
+	// a polyline sharing vertices and two collinear overlapping segments
+	segs := []*Segment{
+		{Point{0, 0}, Point{10, 10}}, {Point{0, 10}, Point{10, 0}},
+		{Point{5, 0}, Point{5, 10}}, {Point{0, 5}, Point{10, 5}},
+		{Point{20, 0}, Point{21, 1}}, {Point{21, 1}, Point{22, 0}},
+		{Point{30, 0}, Point{32, 0}}, {Point{31, 0}, Point{33, 0}},
+	}
+	got := Intersections(segs)
+	expected := []Crossing{
+		{Point{5, 5}, []int{0, 1, 2, 3}},
+		{Point{21, 1}, []int{4, 5}},
+		{Point{31, 0}, []int{6, 7}},
+		{Point{32, 0}, []int{6, 7}},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("crossings %v", got)
+	}
+	if len(Intersections(nil)) != 0 {
+		t.Errorf("crossings without segments")
+	}
+}
+
+func TestIntersectionsRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(7))
+	for round := 0; round < 50; round++ {
+		// a coarse grid makes shared endpoints and collinear segments
+		// frequent, the fine one gives general positions
+		grid := 8.0
+		if round%2 == 1 {
+			grid = 1e6
+		}
+		segs := make([]*Segment, 30)
+		for i := range segs {
+			c := func() float64 { return math.Floor(rnd.Float64()*grid) / grid * 100 }
+			segs[i] = &Segment{Point{c(), c()}, Point{c(), c()}}
+		}
+		set := make(map[pair]bool)
+		for i := range segs {
+			for j := i + 1; j < len(segs); j++ {
+				if segs[i].Intersects(segs[j]) {
+					set[pair{i, j}] = true
+				}
+			}
+		}
+		cs := Intersections(segs)
+		if got, expected := crossingPairs(cs), sortedPairs(set); !reflect.DeepEqual(got, expected) {
+			t.Fatalf("round %d: pairs %v, expected %v", round, got, expected)
+		}
+		for i := 1; i < len(cs); i++ {
+			if !lexLess(cs[i-1].Point, cs[i].Point) {
+				t.Fatalf("round %d: crossings out of order %v %v", round, cs[i-1], cs[i])
+			}
+		}
+	}
+}
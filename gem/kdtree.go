@@ -0,0 +1,231 @@
+package gem
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+)
+
+// KDItem is a point indexed by a KDTree with its associated data.
+type KDItem struct {
+	P    Point
+	Data interface{}
+}
+
+// Neighbor is a KDItem found by a query at distance Dist.
+type Neighbor struct {
+	KDItem
+	Dist float64
+}
+
+type kdNode struct {
+	KDItem
+	axis        int
+	size        int     // items in the subtree
+	left, right *kdNode // left holds the coordinates below the split
+}
+
+func (n *kdNode) count() int {
+	if n == nil {
+		return 0
+	}
+	return n.size
+}
+
+// kdBalance is the largest fraction of a subtree allowed in one child
+// when an insertion makes the tree too deep.
+const kdBalance = 0.7
+
+// KDTree is a k-d tree of points of dimension Dim compared with
+// Metric. Items can be inserted one at a time, and the subtree that
+// got unbalanced is rebuilt when an insertion makes the tree too deep,
+// as in a scapegoat tree.
+type KDTree struct {
+	Dim    int
+	Metric Metric
+	root   *kdNode
+	size   int
+}
+
+// NewKDTree constructs a balanced tree holding `items`. A nil metric
+// is Euclidean.
+func NewKDTree(dim int, metric Metric, items ...KDItem) *KDTree {
+	if metric == nil {
+		metric = Euclidean{}
+	}
+	t := &KDTree{Dim: dim, Metric: metric}
+	for _, it := range items {
+		if len(it.P) != dim {
+			panic(DimError{dim, len(it.P)})
+		}
+	}
+	t.root = t.build(append([]KDItem(nil), items...))
+	t.size = len(items)
+	return t
+}
+
+func (t *KDTree) Size() int {
+	return t.size
+}
+
+// build splits `items` at the median along the axis of the largest
+// spread.
+func (t *KDTree) build(items []KDItem) *kdNode {
+	if len(items) == 0 {
+		return nil
+	}
+	axis, spread := 0, -1.0
+	for i := 0; i < t.Dim; i++ {
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for _, it := range items {
+			lo, hi = math.Min(lo, it.P[i]), math.Max(hi, it.P[i])
+		}
+		if hi-lo > spread {
+			axis, spread = i, hi-lo
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].P[axis] < items[j].P[axis] })
+	m := len(items) / 2
+	// equal coordinates go right
+	for m > 0 && items[m-1].P[axis] == items[m].P[axis] {
+		m--
+	}
+	return &kdNode{KDItem: items[m], axis: axis, size: len(items),
+		left: t.build(items[:m]), right: t.build(items[m+1:])}
+}
+
+// Insert adds `p` with `data` to the tree.
+func (t *KDTree) Insert(p Point, data interface{}) {
+	if len(p) != t.Dim {
+		panic(DimError{t.Dim, len(p)})
+	}
+	t.size++
+	n := &kdNode{KDItem: KDItem{p, data}, size: 1}
+	// links from the root to the new node
+	path := []**kdNode{&t.root}
+	for c := t.root; c != nil; {
+		c.size++
+		next := &c.right
+		if p[c.axis] < c.P[c.axis] {
+			next = &c.left
+		}
+		if *next == nil {
+			n.axis = (c.axis + 1) % t.Dim
+		}
+		path = append(path, next)
+		c = *next
+	}
+	*path[len(path)-1] = n
+	if float64(len(path)-1) <= math.Log(float64(t.size))/math.Log(1/kdBalance) {
+		return
+	}
+	for i := len(path) - 2; i >= 0; i-- {
+		c := *path[i]
+		if float64(IntMax(c.left.count(), c.right.count())) > kdBalance*float64(c.size) {
+			*path[i] = t.build(kdCollect(c, nil))
+			return
+		}
+	}
+}
+
+func kdCollect(n *kdNode, items []KDItem) []KDItem {
+	if n != nil {
+		items = append(items, n.KDItem)
+		items = kdCollect(n.left, items)
+		items = kdCollect(n.right, items)
+	}
+	return items
+}
+
+// Items returns all the items of the tree.
+func (t *KDTree) Items() []KDItem {
+	return kdCollect(t.root, make([]KDItem, 0, t.size))
+}
+
+// neighborHeap is a max-heap of the best neighbors found so far.
+type neighborHeap []Neighbor
+
+func (h neighborHeap) Len() int            { return len(h) }
+func (h neighborHeap) Less(i, j int) bool  { return h[i].Dist > h[j].Dist }
+func (h neighborHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *neighborHeap) Push(x interface{}) { *h = append(*h, x.(Neighbor)) }
+func (h *neighborHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// KNN returns the `k` items closest to `p`, closest first.
+func (t *KDTree) KNN(p Point, k int) []Neighbor {
+	return t.ApproxKNN(p, k, 0)
+}
+
+// ApproxKNN returns `k` items such that the distance of the i-th one
+// is at most (1 + eps) times the distance of the true i-th nearest
+// neighbor, closest first. Larger `eps` prune more subtrees.
+func (t *KDTree) ApproxKNN(p Point, k int, eps float64) []Neighbor {
+	if len(p) != t.Dim {
+		panic(DimError{t.Dim, len(p)})
+	}
+	if k <= 0 {
+		return nil
+	}
+	h := make(neighborHeap, 0, k)
+	var visit func(n *kdNode)
+	visit = func(n *kdNode) {
+		if n == nil {
+			return
+		}
+		if d := t.Metric.Dist(p, n.P); len(h) < k {
+			heap.Push(&h, Neighbor{n.KDItem, d})
+		} else if d < h[0].Dist {
+			h[0] = Neighbor{n.KDItem, d}
+			heap.Fix(&h, 0)
+		}
+		diff := p[n.axis] - n.P[n.axis]
+		near, far := n.right, n.left
+		if diff < 0 {
+			near, far = n.left, n.right
+		}
+		visit(near)
+		if len(h) < k || t.Metric.PlaneDist(n.axis, diff)*(1+eps) < h[0].Dist {
+			visit(far)
+		}
+	}
+	visit(t.root)
+	ret := make([]Neighbor, len(h))
+	for i := len(h) - 1; i >= 0; i-- {
+		ret[i] = heap.Pop(&h).(Neighbor)
+	}
+	return ret
+}
+
+// Radius returns the items within distance `r` of `p`, closest first.
+func (t *KDTree) Radius(p Point, r float64) []Neighbor {
+	if len(p) != t.Dim {
+		panic(DimError{t.Dim, len(p)})
+	}
+	var ret []Neighbor
+	var visit func(n *kdNode)
+	visit = func(n *kdNode) {
+		if n == nil {
+			return
+		}
+		if d := t.Metric.Dist(p, n.P); d <= r {
+			ret = append(ret, Neighbor{n.KDItem, d})
+		}
+		diff := p[n.axis] - n.P[n.axis]
+		near, far := n.right, n.left
+		if diff < 0 {
+			near, far = n.left, n.right
+		}
+		visit(near)
+		if t.Metric.PlaneDist(n.axis, diff) <= r {
+			visit(far)
+		}
+	}
+	visit(t.root)
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].Dist < ret[j].Dist })
+	return ret
+}
@@ -0,0 +1,127 @@
+package gem
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func randPoints(n, dim int, seed int64) []Point {
+	r := rand.New(rand.NewSource(seed))
+	points := make([]Point, n)
+	for i := range points {
+		points[i] = make(Point, dim)
+		for j := range points[i] {
+			points[i][j] = r.NormFloat64() * float64(j+1)
+		}
+	}
+	return points
+}
+
+func bruteKNN(points []Point, m Metric, p Point, k int) []float64 {
+	d := make([]float64, len(points))
+	for i, q := range points {
+		d[i] = m.Dist(p, q)
+	}
+	sort.Float64s(d)
+	return d[:k]
+}
+
+func TestKDTreeKNN(t *testing.T) {
+	points := randPoints(2000, 5, 1)
+	queries := randPoints(20, 5, 2)
+	metrics := []Metric{Euclidean{}, Manhattan{}, Cosine{}, Mahalanobis{Point{1, 4, 9, 16, 25}}}
+	for _, m := range metrics {
+		items := make([]KDItem, len(points)/2)
+		for i := range items {
+			items[i] = KDItem{points[i], i}
+		}
+		tr := NewKDTree(5, m, items...)
+		// the second half one at a time, sorted to unbalance the tree
+		rest := append([]Point(nil), points[len(items):]...)
+		sort.Slice(rest, func(i, j int) bool { return rest[i][0] < rest[j][0] })
+		for i, p := range rest {
+			tr.Insert(p, len(items)+i)
+		}
+		if tr.Size() != len(points) || len(tr.Items()) != len(points) {
+			t.Fatalf("%T: size %d", m, tr.Size())
+		}
+		for _, q := range queries {
+			expected := bruteKNN(points, m, q, 7)
+			for i, nb := range tr.KNN(q, 7) {
+				if nb.Dist != expected[i] || m.Dist(q, nb.P) != nb.Dist {
+					t.Errorf("%T: neighbor %d at %v, expected %v", m, i, nb.Dist, expected[i])
+				}
+			}
+			for i, nb := range tr.ApproxKNN(q, 7, 0.5) {
+				if nb.Dist > 1.5*expected[i] {
+					t.Errorf("%T: approximate neighbor %d at %v, expected at most %v", m, i, nb.Dist, 1.5*expected[i])
+				}
+			}
+		}
+	}
+}
+
+func TestKDTreeRadius(t *testing.T) {
+	points := randPoints(1000, 3, 3)
+	tr := NewKDTree(3, nil)
+	for i, p := range points {
+		tr.Insert(p, i)
+	}
+	q := Point{0, 0, 0}
+	found := tr.Radius(q, 1.5)
+	n := 0
+	for _, p := range points {
+		if p.Dist(q) <= 1.5 {
+			n++
+		}
+	}
+	if len(found) != n || n == 0 {
+		t.Fatalf("found %d points, expected %d", len(found), n)
+	}
+	for i := 1; i < len(found); i++ {
+		if found[i].Dist < found[i-1].Dist {
+			t.Errorf("results not sorted at %d", i)
+		}
+	}
+	if len(NewKDTree(3, nil).KNN(q, 3)) != 0 {
+		t.Errorf("neighbors in an empty tree")
+	}
+}
+
+func TestKDTreeDepth(t *testing.T) {
+	tr := NewKDTree(1, nil)
+	for i := 0; i < 4096; i++ {
+		tr.Insert(Point{float64(i)}, i)
+	}
+	var depth func(n *kdNode) int
+	depth = func(n *kdNode) int {
+		if n == nil {
+			return 0
+		}
+		return 1 + IntMax(depth(n.left), depth(n.right))
+	}
+	if d := depth(tr.root); d > 40 {
+		t.Errorf("depth %d after sorted inserts", d)
+	}
+}
+
+func TestMetricDimError(t *testing.T) {
+	m := Mahalanobis{Point{1, 1, 1}}
+	for _, tt := range []struct {
+		p, q Point
+		err  DimError
+	}{
+		{Point{1, 2}, Point{1, 2, 3}, DimError{3, 2}},
+		{Point{1, 2, 3}, Point{1, 2, 3, 4}, DimError{3, 4}},
+	} {
+		func() {
+			defer func() {
+				if err := recover(); err != tt.err {
+					t.Errorf("%v to %v: expected %v, got %v", tt.p, tt.q, tt.err, err)
+				}
+			}()
+			m.Dist(tt.p, tt.q)
+		}()
+	}
+}
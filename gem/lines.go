@@ -0,0 +1,338 @@
+package gem
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// normalLine returns the normal form (rho; theta) of a line, rho being
+// signed and theta in [0, pi). HghPars gives instead the direction of a
+// segment and the unsigned distance of its line to the origin.
+func normalLine(rho, theta float64) (float64, float64) {
+	for theta < 0 {
+		theta, rho = theta+math.Pi, -rho
+	}
+	for theta >= math.Pi {
+		theta, rho = theta-math.Pi, -rho
+	}
+	return rho, theta
+}
+
+// ClipLine returns the part of the line x cos(theta) + y sin(theta) = rho
+// inside the rectangle `r`, nil if they do not meet.
+func ClipLine(rho, theta float64, r *Rect) *Segment {
+	c, s := math.Cos(theta), math.Sin(theta)
+	p0, d := Point{rho * c, rho * s}, Point{-s, c}
+	t0, t1 := math.Inf(-1), math.Inf(1)
+	for i := 0; i < 2; i++ {
+		lo, hi := r.C[i]-r.P[i], r.C[i]+r.P[i]
+		if math.Abs(d[i]) < 1e-12 {
+			if p0[i] < lo || p0[i] > hi {
+				return nil
+			}
+			continue
+		}
+		a, b := (lo-p0[i])/d[i], (hi-p0[i])/d[i]
+		t0, t1 = math.Max(t0, math.Min(a, b)), math.Min(t1, math.Max(a, b))
+	}
+	if t0 > t1 {
+		return nil
+	}
+	return &Segment{Point{p0[0] + t0*d[0], p0[1] + t0*d[1]}, Point{p0[0] + t1*d[0], p0[1] + t1*d[1]}}
+}
+
+//#################################################################
+//                   Hough Transform
+//#################################################################
+
+// Hough is an accumulator over the lines x cos(theta) + y sin(theta) = rho
+// with theta in [0, pi) and rho in [-MaxRho, MaxRho], in cells of
+// RhoRes by ThetaRes.
+type Hough struct {
+	MaxRho, RhoRes, ThetaRes float64
+	NRho, NTheta             int
+	Votes                    []float64 // NTheta rows of NRho cells
+	cos, sin                 []float64
+}
+
+// HoughLine is a line found in a Hough accumulator.
+type HoughLine struct {
+	Rho, Theta, Votes float64
+}
+
+func NewHough(maxRho, rhoRes, thetaRes float64) *Hough {
+	h := &Hough{MaxRho: maxRho, RhoRes: rhoRes, ThetaRes: thetaRes}
+	h.NRho = int(math.Ceil(2*maxRho/rhoRes)) + 1
+	h.NTheta = int(math.Ceil(math.Pi / thetaRes))
+	h.Votes = make([]float64, h.NRho*h.NTheta)
+	h.cos, h.sin = make([]float64, h.NTheta), make([]float64, h.NTheta)
+	for t := range h.cos {
+		h.cos[t], h.sin[t] = math.Cos(float64(t)*thetaRes), math.Sin(float64(t)*thetaRes)
+	}
+	return h
+}
+
+func (h *Hough) rhoIndex(rho float64) int {
+	return int(math.Floor((rho+h.MaxRho)/h.RhoRes + 0.5))
+}
+
+func (h *Hough) vote(t int, rho, w float64) {
+	if r := h.rhoIndex(rho); r >= 0 && r < h.NRho {
+		h.Votes[t*h.NRho+r] += w
+	}
+}
+
+// AddPoint votes with weight `w` for all the lines through (x; y).
+func (h *Hough) AddPoint(x, y, w float64) {
+	for t := range h.cos {
+		h.vote(t, x*h.cos[t]+y*h.sin[t], w)
+	}
+}
+
+func (h *Hough) AddPoints(points []Point) {
+	for _, p := range points {
+		h.AddPoint(p[0], p[1], 1)
+	}
+}
+
+// AddSegment votes for the line of `s` with its length as weight.
+func (h *Hough) AddSegment(s *Segment) {
+	dx, dy := s.P2[0]-s.P1[0], s.P2[1]-s.P1[1]
+	theta := math.Atan2(dy, dx) + math.Pi/2
+	rho, theta := normalLine(s.P1[0]*math.Cos(theta)+s.P1[1]*math.Sin(theta), theta)
+	t := int(math.Floor(theta/h.ThetaRes + 0.5))
+	if t == h.NTheta {
+		t, rho = 0, -rho
+	}
+	h.vote(t, rho, math.Hypot(dx, dy))
+}
+
+func (h *Hough) Reset() {
+	for i := range h.Votes {
+		h.Votes[i] = 0
+	}
+}
+
+// at returns the votes of the cell (t; r), where theta wraps around
+// to theta - pi with the opposite rho.
+func (h *Hough) at(t, r int) float64 {
+	if t < 0 || t >= h.NTheta {
+		t = (t%h.NTheta + h.NTheta) % h.NTheta
+		r = h.NRho - 1 - r
+	}
+	if r < 0 || r >= h.NRho {
+		return 0
+	}
+	return h.Votes[t*h.NRho+r]
+}
+
+// Peaks returns at most `n` lines with at least `threshold` votes,
+// strongest first, that are maximal within `suppress` cells.
+func (h *Hough) Peaks(n int, threshold float64, suppress int) []HoughLine {
+	var peaks []HoughLine
+	for t := 0; t < h.NTheta; t++ {
+		for r := 0; r < h.NRho; r++ {
+			v := h.Votes[t*h.NRho+r]
+			if v < threshold || v == 0 {
+				continue
+			}
+			max := true
+			for dt := -suppress; dt <= suppress && max; dt++ {
+				for dr := -suppress; dr <= suppress; dr++ {
+					// ties go to the first cell
+					if w := h.at(t+dt, r+dr); w > v || w == v && (dt < 0 || dt == 0 && dr < 0) {
+						max = false
+						break
+					}
+				}
+			}
+			if max {
+				peaks = append(peaks, HoughLine{float64(r)*h.RhoRes - h.MaxRho, float64(t) * h.ThetaRes, v})
+			}
+		}
+	}
+	sort.SliceStable(peaks, func(i, j int) bool { return peaks[i].Votes > peaks[j].Votes })
+	if len(peaks) > n {
+		peaks = peaks[:n]
+	}
+	return peaks
+}
+
+//#################################################################
+//                   Line Fitting
+//#################################################################
+
+// LineFit is a line x cos(Theta) + y sin(Theta) = Rho fitted to points.
+// Segment spans the projections of the Inliers, the indices of the
+// points used, and Residual is their RMS distance to the line.
+type LineFit struct {
+	Rho, Theta float64
+	Segment    *Segment
+	Inliers    []int
+	Residual   float64
+}
+
+func (f *LineFit) Dist(p Point) float64 {
+	return math.Abs(p[0]*math.Cos(f.Theta) + p[1]*math.Sin(f.Theta) - f.Rho)
+}
+
+func (f *LineFit) Dispose() {}
+
+// FitLine fits a line to `points` by total least squares, nil for less
+// than two distinct points.
+func FitLine(points []Point) *LineFit {
+	idx := make([]int, len(points))
+	for i := range idx {
+		idx[i] = i
+	}
+	return fitIndices(points, idx)
+}
+
+func fitIndices(points []Point, idx []int) *LineFit {
+	if len(idx) < 2 {
+		return nil
+	}
+	cx, cy := 0.0, 0.0
+	for _, i := range idx {
+		cx, cy = cx+points[i][0], cy+points[i][1]
+	}
+	n := float64(len(idx))
+	cx, cy = cx/n, cy/n
+	sxx, sxy, syy := 0.0, 0.0, 0.0
+	for _, i := range idx {
+		dx, dy := points[i][0]-cx, points[i][1]-cy
+		sxx, sxy, syy = sxx+dx*dx, sxy+dx*dy, syy+dy*dy
+	}
+	if sxx+syy == 0 {
+		return nil
+	}
+	// the direction is the principal axis of the scatter
+	phi := math.Atan2(2*sxy, sxx-syy) / 2
+	theta := phi + math.Pi/2
+	rho, theta := normalLine(cx*math.Cos(theta)+cy*math.Sin(theta), theta)
+	f := &LineFit{Rho: rho, Theta: theta, Inliers: idx}
+	f.span(points)
+	return f
+}
+
+// NewLineFit returns the line (rho; theta) with the points within
+// `threshold` of it as inliers, for instance a Hough peak.
+func NewLineFit(rho, theta float64, points []Point, threshold float64) *LineFit {
+	rho, theta = normalLine(rho, theta)
+	f := &LineFit{Rho: rho, Theta: theta}
+	for i, p := range points {
+		if f.Dist(p) <= threshold {
+			f.Inliers = append(f.Inliers, i)
+		}
+	}
+	if len(f.Inliers) > 0 {
+		f.span(points)
+	}
+	return f
+}
+
+// Refine refits the line to its inliers among `points` by least
+// squares.
+func (f *LineFit) Refine(points []Point) {
+	if r := fitIndices(points, f.Inliers); r != nil {
+		*f = *r
+	}
+}
+
+// span computes the segment and the residual of the inliers.
+func (f *LineFit) span(points []Point) {
+	c, s := math.Cos(f.Theta), math.Sin(f.Theta)
+	p0, d := Point{f.Rho * c, f.Rho * s}, Point{-s, c}
+	t0, t1, sum := math.Inf(1), math.Inf(-1), 0.0
+	for _, i := range f.Inliers {
+		p := points[i]
+		t := (p[0]-p0[0])*d[0] + (p[1]-p0[1])*d[1]
+		t0, t1 = math.Min(t0, t), math.Max(t1, t)
+		e := f.Dist(p)
+		sum += e * e
+	}
+	f.Segment = &Segment{Point{p0[0] + t0*d[0], p0[1] + t0*d[1]}, Point{p0[0] + t1*d[0], p0[1] + t1*d[1]}}
+	f.Residual = math.Sqrt(sum / float64(len(f.Inliers)))
+}
+
+// RansacLine fits a line to `points` robustly. Each of the `iterations`
+// draws two points with `rnd`, nil for the default source, and counts
+// the points within `threshold` of their line. The largest set, if it
+// has at least `minInliers` points, is refitted by least squares and
+// its inliers recomputed. It returns nil if no line has enough inliers.
+func RansacLine(points []Point, threshold float64, iterations, minInliers int, rnd *rand.Rand) *LineFit {
+	idx := make([]int, len(points))
+	for i := range idx {
+		idx[i] = i
+	}
+	return ransacIndices(points, idx, threshold, iterations, minInliers, rnd)
+}
+
+func ransacIndices(points []Point, idx []int, threshold float64, iterations, minInliers int, rnd *rand.Rand) *LineFit {
+	if len(idx) < 2 {
+		return nil
+	}
+	intn := rand.Intn
+	if rnd != nil {
+		intn = rnd.Intn
+	}
+	inliers := func(f *LineFit) []int {
+		var in []int
+		for _, i := range idx {
+			if f.Dist(points[i]) <= threshold {
+				in = append(in, i)
+			}
+		}
+		return in
+	}
+	var best []int
+	for it := 0; it < iterations; it++ {
+		a, b := idx[intn(len(idx))], idx[intn(len(idx))]
+		f := fitIndices(points, []int{a, b})
+		if f == nil {
+			continue
+		}
+		if in := inliers(f); len(in) > len(best) {
+			best = in
+		}
+	}
+	if len(best) < IntMax(minInliers, 2) {
+		return nil
+	}
+	f := fitIndices(points, best)
+	if in := inliers(f); len(in) >= 2 {
+		f.Inliers = in
+		f.span(points)
+	}
+	return f
+}
+
+// RansacLines finds up to `n` lines by sequential RANSAC, removing the
+// inliers of every line found before searching the next one.
+func RansacLines(points []Point, n int, threshold float64, iterations, minInliers int, rnd *rand.Rand) []*LineFit {
+	left := make([]int, len(points))
+	for i := range left {
+		left[i] = i
+	}
+	var ret []*LineFit
+	for len(ret) < n {
+		f := ransacIndices(points, left, threshold, iterations, minInliers, rnd)
+		if f == nil {
+			break
+		}
+		ret = append(ret, f)
+		used := make(map[int]bool, len(f.Inliers))
+		for _, i := range f.Inliers {
+			used[i] = true
+		}
+		rest := left[:0]
+		for _, i := range left {
+			if !used[i] {
+				rest = append(rest, i)
+			}
+		}
+		left = rest
+	}
+	return ret
+}
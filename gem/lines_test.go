@@ -0,0 +1,121 @@
+package gem
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestHoughPeaks(t *testing.T) {
+	h := NewHough(20, 0.1, math.Pi/180)
+	var points []Point
+	for i := 0; i <= 10; i++ {
+		points = append(points, Point{float64(i), float64(i)}, Point{5, float64(i) - 5})
+	}
+	points = append(points, Point{1, 7}, Point{8, 2}, Point{-3, 4})
+	h.AddPoints(points)
+	peaks := h.Peaks(2, 5, 2)
+	if len(peaks) != 2 {
+		t.Fatalf("%d peaks", len(peaks))
+	}
+	for _, p := range peaks {
+		vertical := near(p.Theta, 0) && near(p.Rho, 5)
+		diagonal := near(p.Theta, 3*math.Pi/4) && near(p.Rho, 0)
+		// (5; 5) is on both lines
+		if p.Votes != 12 || !vertical && !diagonal {
+			t.Errorf("unexpected peak %+v", p)
+		}
+	}
+
+	h.Reset()
+	h.AddSegment(&Segment{Point{5, 10}, Point{5, 0}})
+	h.AddSegment(&Segment{Point{-4, 2}, Point{4, 2}})
+	peaks = h.Peaks(5, 0, 1)
+	if len(peaks) != 2 || peaks[0].Votes != 10 || !near(peaks[0].Rho, 5) || !near(peaks[0].Theta, 0) ||
+		!near(peaks[1].Rho, 2) || !near(peaks[1].Theta, math.Pi/2) {
+		t.Errorf("segment peaks %+v", peaks)
+	}
+}
+
+func TestClipLine(t *testing.T) {
+	r := &Rect{Point{5, 5}, Point{5, 5}}
+	tests := []struct {
+		rho, theta float64
+		seg        *Segment
+	}{
+		{5, 0, &Segment{Point{5, 0}, Point{5, 10}}},
+		{2, math.Pi / 2, &Segment{Point{10, 2}, Point{0, 2}}},
+		{0, 3 * math.Pi / 4, &Segment{Point{0, 0}, Point{10, 10}}},
+		{20, 0, nil},
+	}
+	for _, tt := range tests {
+		s := ClipLine(tt.rho, tt.theta, r)
+		if (s == nil) != (tt.seg == nil) {
+			t.Errorf("(%v; %v) clipped to %v", tt.rho, tt.theta, s)
+			continue
+		}
+		if s != nil {
+			a, b := s.P1.Dist(tt.seg.P1)+s.P2.Dist(tt.seg.P2), s.P1.Dist(tt.seg.P2)+s.P2.Dist(tt.seg.P1)
+			if math.Min(a, b) > 1e-9 {
+				t.Errorf("(%v; %v) clipped to %v, expected %v", tt.rho, tt.theta, s, tt.seg)
+			}
+		}
+	}
+}
+
+func TestFitLine(t *testing.T) {
+	var points []Point
+	for i := 0; i < 10; i++ {
+		x := float64(i)
+		points = append(points, Point{x, 2*x + 1})
+	}
+	f := FitLine(points)
+	if f.Residual > 1e-9 || len(f.Inliers) != 10 || f.Dist(Point{20, 41}) > 1e-9 {
+		t.Errorf("fit %+v", f)
+	}
+	if l := f.Segment.P1.Dist(f.Segment.P2); !near(l, 9*math.Sqrt(5)) {
+		t.Errorf("segment length %v", l)
+	}
+	if FitLine(points[:1]) != nil || FitLine([]Point{{1, 1}, {1, 1}}) != nil {
+		t.Errorf("fit of degenerate points")
+	}
+}
+
+func TestRansacLines(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	var points []Point
+	for i := 0; i < 40; i++ {
+		x := float64(i)
+		points = append(points, Point{x, 0.5*x + 3 + (rnd.Float64()-0.5)*0.2})
+	}
+	for i := 0; i < 30; i++ {
+		y := float64(i)
+		points = append(points, Point{30 + (rnd.Float64()-0.5)*0.2, y})
+	}
+	for i := 0; i < 15; i++ {
+		points = append(points, Point{rnd.Float64() * 40, rnd.Float64()*40 + 30})
+	}
+	f := RansacLine(points, 0.3, 200, 10, rnd)
+	if f == nil || len(f.Inliers) < 40 || len(f.Inliers) > 42 || f.Residual > 0.1 {
+		t.Fatalf("fit %+v", f)
+	}
+	if d := f.Dist(Point{100, 53}); d > 0.5 {
+		t.Errorf("line off by %v", d)
+	}
+	fits := RansacLines(points, 3, 0.3, 200, 10, rnd)
+	if len(fits) != 2 || len(fits[1].Inliers) < 29 || fits[1].Dist(Point{30, 100}) > 0.5 {
+		t.Errorf("%d lines", len(fits))
+	}
+}
+
+func TestNewLineFit(t *testing.T) {
+	points := []Point{{5, 0}, {5.1, 4}, {4.95, 8}, {7, 3}}
+	f := NewLineFit(-5, math.Pi, points, 0.2)
+	if len(f.Inliers) != 3 || !near(f.Rho, 5) || !near(f.Theta, 0) || f.Segment.P1.Dist(Point{5, 0}) > 1e-9 {
+		t.Errorf("fit %+v %v", f, f.Segment)
+	}
+	f.Refine(points)
+	if len(f.Inliers) != 3 || f.Residual > 0.1 || f.Residual == 0 {
+		t.Errorf("refined fit %+v", f)
+	}
+}
@@ -0,0 +1,89 @@
+package gem
+
+import "math"
+
+// Metric is a distance between points. PlaneDist returns a lower bound
+// of the distance between two points whose coordinates along `axis`
+// differ by `d`, zero when there is none, and lets a KDTree prune the
+// subtrees across a splitting plane.
+type Metric interface {
+	Dist(p, q Point) float64
+	PlaneDist(axis int, d float64) float64
+}
+
+// Euclidean is the L2 distance.
+type Euclidean struct{}
+
+func (Euclidean) Dist(p, q Point) float64 {
+	return p.Dist(q)
+}
+
+func (Euclidean) PlaneDist(axis int, d float64) float64 {
+	return math.Abs(d)
+}
+
+// Manhattan is the L1 distance.
+type Manhattan struct{}
+
+func (Manhattan) Dist(p, q Point) float64 {
+	if len(p) != len(q) {
+		panic(DimError{len(p), len(q)})
+	}
+	sum := 0.0
+	for i := range p {
+		sum += math.Abs(p[i] - q[i])
+	}
+	return sum
+}
+
+func (Manhattan) PlaneDist(axis int, d float64) float64 {
+	return math.Abs(d)
+}
+
+// Cosine is one minus the cosine of the angle between two vectors, and
+// one if any of them is null. It gives no plane bound.
+type Cosine struct{}
+
+func (Cosine) Dist(p, q Point) float64 {
+	if len(p) != len(q) {
+		panic(DimError{len(p), len(q)})
+	}
+	n := p.Norm() * q.Norm()
+	if n == 0 {
+		return 1
+	}
+	return 1 - p.Dot(q)/n
+}
+
+func (Cosine) PlaneDist(axis int, d float64) float64 {
+	return 0
+}
+
+// Mahalanobis is the Mahalanobis distance for a diagonal covariance,
+// the variance vector Var, for instance the one returned by SStats.MV.
+type Mahalanobis struct {
+	Var Point
+}
+
+func (m Mahalanobis) variance(i int) float64 {
+	return math.Max(m.Var[i], 0.00000001)
+}
+
+func (m Mahalanobis) Dist(p, q Point) float64 {
+	if len(p) != len(m.Var) {
+		panic(DimError{len(m.Var), len(p)})
+	}
+	if len(q) != len(m.Var) {
+		panic(DimError{len(m.Var), len(q)})
+	}
+	sum := 0.0
+	for i := range p {
+		d := p[i] - q[i]
+		sum += d * d / m.variance(i)
+	}
+	return math.Sqrt(sum)
+}
+
+func (m Mahalanobis) PlaneDist(axis int, d float64) float64 {
+	return math.Abs(d) / math.Sqrt(m.variance(axis))
+}
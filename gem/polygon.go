@@ -0,0 +1,572 @@
+package gem
+
+import (
+	"math"
+	"sort"
+)
+
+// Polygon is a simple planar polygon given by its vertices, the last
+// one being implicitly connected to the first.
+type Polygon []Point
+
+// Locations of a point with respect to a polygon.
+const (
+	POLY_OUTSIDE int = iota
+	POLY_INSIDE
+	POLY_BOUNDARY
+)
+
+// NewPolygon constructs a polygon from the parallel coordinate slices
+// used by IsPointInPoly and PolyArea.
+func NewPolygon(x, y []float64) Polygon {
+	p := make(Polygon, len(x))
+	for i := range x {
+		p[i] = Point{x[i], y[i]}
+	}
+	return p
+}
+
+// XY returns the coordinates of the vertices as parallel slices.
+func (p Polygon) XY() (x, y []float64) {
+	x, y = make([]float64, len(p)), make([]float64, len(p))
+	for i, v := range p {
+		x[i], y[i] = v[0], v[1]
+	}
+	return
+}
+
+func (p Polygon) Clone() Polygon {
+	c := make(Polygon, len(p))
+	for i, v := range p {
+		c[i] = v.Clone()
+	}
+	return c
+}
+
+// SignedArea is positive for counter-clockwise polygons.
+func (p Polygon) SignedArea() float64 {
+	x, y := p.XY()
+	return -PolyArea(x, y)
+}
+
+func (p Polygon) Area() float64 {
+	return math.Abs(p.SignedArea())
+}
+
+// Orientation returns 1 for counter-clockwise polygons, -1 for
+// clockwise ones and 0 for degenerate ones.
+func (p Polygon) Orientation() int {
+	a := p.SignedArea()
+	switch {
+	case a > 0:
+		return 1
+	case a < 0:
+		return -1
+	}
+	return 0
+}
+
+// Reverse returns the polygon with the opposite orientation.
+func (p Polygon) Reverse() Polygon {
+	r := make(Polygon, len(p))
+	for i, v := range p {
+		r[len(p)-1-i] = v
+	}
+	return r
+}
+
+func (p Polygon) Perimeter() float64 {
+	sum := 0.0
+	for i := range p {
+		sum += p[i].Dist(p[(i+1)%len(p)])
+	}
+	return sum
+}
+
+// Centroid returns the center of mass of the polygon, the mean of the
+// vertices for degenerate polygons.
+func (p Polygon) Centroid() Point {
+	c, a := Point{0, 0}, 0.0
+	for i := range p {
+		v, w := p[i], p[(i+1)%len(p)]
+		cross := v[0]*w[1] - w[0]*v[1]
+		a += cross
+		c[0] += (v[0] + w[0]) * cross
+		c[1] += (v[1] + w[1]) * cross
+	}
+	if a == 0 {
+		c[0], c[1] = 0, 0
+		for _, v := range p {
+			c.Add(v)
+		}
+		return c.DivC(float64(len(p)))
+	}
+	return c.DivC(3 * a)
+}
+
+// Bounds returns the bounding rectangle of the polygon, nil for an
+// empty polygon.
+func (p Polygon) Bounds() *Rect {
+	if len(p) == 0 {
+		return nil
+	}
+	x, y := p.XY()
+	lo, hi := Point{x[0], y[0]}, Point{x[0], y[0]}
+	for i := range x {
+		lo[0], hi[0] = math.Min(lo[0], x[i]), math.Max(hi[0], x[i])
+		lo[1], hi[1] = math.Min(lo[1], y[i]), math.Max(hi[1], y[i])
+	}
+	return &Rect{Point{(lo[0] + hi[0]) / 2, (lo[1] + hi[1]) / 2}, Point{(hi[0] - lo[0]) / 2, (hi[1] - lo[1]) / 2}}
+}
+
+// scale is the largest coordinate magnitude, used for tolerances.
+func (p Polygon) scale() float64 {
+	s := 1.0
+	for _, v := range p {
+		s = math.Max(s, v.AbsMax())
+	}
+	return s
+}
+
+// onSegment tests whether `q` lies on the segment [a, b] within `eps`.
+func onSegment(q, a, b Point, eps float64) bool {
+	if q[0] < math.Min(a[0], b[0])-eps || q[0] > math.Max(a[0], b[0])+eps ||
+		q[1] < math.Min(a[1], b[1])-eps || q[1] > math.Max(a[1], b[1])+eps {
+		return false
+	}
+	if a[0] == b[0] && a[1] == b[1] {
+		return q.Dist(a) <= eps
+	}
+	return PerpDistToLine(q[0], q[1], a[0], a[1], b[0], b[1]) <= eps
+}
+
+// Locate returns POLY_BOUNDARY if `q` lies on an edge of the polygon,
+// within a tolerance relative to its coordinates, and POLY_INSIDE or
+// POLY_OUTSIDE otherwise.
+func (p Polygon) Locate(q Point) int {
+	eps := 1e-9 * p.scale()
+	for i := range p {
+		if onSegment(q, p[i], p[(i+1)%len(p)], eps) {
+			return POLY_BOUNDARY
+		}
+	}
+	x, y := p.XY()
+	if IsPointInPoly(x, y, q[0], q[1]) {
+		return POLY_INSIDE
+	}
+	return POLY_OUTSIDE
+}
+
+// Contains tests whether `q` is inside the polygon, or on its boundary
+// when `boundary` is set.
+func (p Polygon) Contains(q Point, boundary bool) bool {
+	switch p.Locate(q) {
+	case POLY_INSIDE:
+		return true
+	case POLY_BOUNDARY:
+		return boundary
+	}
+	return false
+}
+
+//#################################################################
+//                   Convex Hull and Simplification
+//#################################################################
+
+func cross(o, a, b Point) float64 {
+	return (a[0]-o[0])*(b[1]-o[1]) - (a[1]-o[1])*(b[0]-o[0])
+}
+
+// ConvexHull returns the counter-clockwise convex hull of `points`,
+// without collinear vertices, by Andrew's monotone chain.
+func ConvexHull(points []Point) Polygon {
+	idx := hullIndices(points)
+	hull := make(Polygon, len(idx))
+	for i, j := range idx {
+		hull[i] = points[j]
+	}
+	return hull
+}
+
+// hullIndices returns the indices of the counter-clockwise convex hull
+// of `points`.
+func hullIndices(points []Point) []int {
+	ps := make([]int, len(points))
+	for i := range ps {
+		ps[i] = i
+	}
+	sort.Slice(ps, func(i, j int) bool {
+		a, b := points[ps[i]], points[ps[j]]
+		return a[0] < b[0] || a[0] == b[0] && a[1] < b[1]
+	})
+	if len(ps) < 3 {
+		return ps
+	}
+	turn := func(hull []int, k int) float64 {
+		return cross(points[hull[len(hull)-2]], points[hull[len(hull)-1]], points[k])
+	}
+	hull := make([]int, 0, 2*len(ps))
+	for _, k := range ps {
+		for len(hull) >= 2 && turn(hull, k) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, k)
+	}
+	for i, lower := len(ps)-2, len(hull)+1; i >= 0; i-- {
+		for len(hull) >= lower && turn(hull, ps[i]) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, ps[i])
+	}
+	return hull[:len(hull)-1]
+}
+
+// SimplifyLine simplifies the polyline `points` by Douglas-Peucker,
+// keeping the vertices farther than `eps` from the simplified line.
+func SimplifyLine(points []Point, eps float64) []Point {
+	if len(points) < 3 {
+		return append([]Point(nil), points...)
+	}
+	a, b := points[0], points[len(points)-1]
+	imax, dmax := 0, -1.0
+	for i := 1; i < len(points)-1; i++ {
+		var d float64
+		if a[0] == b[0] && a[1] == b[1] {
+			d = points[i].Dist(a)
+		} else {
+			d = PerpDistToLine(points[i][0], points[i][1], a[0], a[1], b[0], b[1])
+		}
+		if d > dmax {
+			imax, dmax = i, d
+		}
+	}
+	if dmax <= eps {
+		return []Point{a, b}
+	}
+	left := SimplifyLine(points[:imax+1], eps)
+	return append(left[:len(left)-1], SimplifyLine(points[imax:], eps)...)
+}
+
+// Simplify simplifies the polygon by Douglas-Peucker. The outline is
+// split at the first vertex and the vertex farthest from it.
+func (p Polygon) Simplify(eps float64) Polygon {
+	if len(p) < 4 {
+		return p.Clone()
+	}
+	far, dmax := 0, -1.0
+	for i, v := range p {
+		if d := v.Dist(p[0]); d > dmax {
+			far, dmax = i, d
+		}
+	}
+	ring := append(append([]Point(nil), p...), p[0])
+	first := SimplifyLine(ring[:far+1], eps)
+	second := SimplifyLine(ring[far:], eps)
+	s := append(first[:len(first)-1], second[:len(second)-1]...)
+	ret := make(Polygon, len(s))
+	for i, v := range s {
+		ret[i] = v.Clone()
+	}
+	return ret
+}
+
+//#################################################################
+//                   Clipping
+//#################################################################
+
+// clipVertex is a vertex of the circular lists of the Greiner-Hormann
+// algorithm.
+type clipVertex struct {
+	p          Point
+	next, prev *clipVertex
+	neighbor   *clipVertex // the same intersection on the other polygon
+	alpha      float64     // position of an inserted intersection along its edge
+	inserted   bool        // an intersection inserted into an edge
+	in         bool        // the edge to the next vertex is inside the other polygon
+	crossing   bool        // an intersection where the boundaries cross
+	visited    bool
+}
+
+func clipList(p Polygon) *clipVertex {
+	var first, last *clipVertex
+	for _, v := range p {
+		c := &clipVertex{p: v}
+		if first == nil {
+			first = c
+		} else {
+			last.next, c.prev = c, last
+		}
+		last = c
+	}
+	last.next, first.prev = first, last
+	return first
+}
+
+// nextOriginal returns the next vertex of the polygon that was not
+// inserted.
+func (v *clipVertex) nextOriginal() *clipVertex {
+	for v = v.next; v.inserted; v = v.next {
+	}
+	return v
+}
+
+// insertBetween inserts the intersection `c` between the original
+// vertices `a` and `b`, ordered by alpha.
+func insertBetween(c, a, b *clipVertex) {
+	v := a
+	for v.next != b && v.next.alpha < c.alpha {
+		v = v.next
+	}
+	c.next, c.prev = v.next, v
+	v.next.prev = c
+	v.next = c
+}
+
+// link makes the vertices `a` and `b` the same intersection.
+func link(a, b *clipVertex) {
+	a.neighbor, b.neighbor = b, a
+}
+
+// linkOnEdge inserts a copy of the vertex `v` into the edge [a, b] of
+// the other polygon, as the same intersection.
+func linkOnEdge(v, a, b *clipVertex) {
+	dx, dy := b.p[0]-a.p[0], b.p[1]-a.p[1]
+	t := ((v.p[0]-a.p[0])*dx + (v.p[1]-a.p[1])*dy) / (dx*dx + dy*dy)
+	c := &clipVertex{p: v.p, alpha: t, inserted: true}
+	insertBetween(c, a, b)
+	link(v, c)
+}
+
+// segmentParams returns the positions along [a, b] and [c, d] of their
+// intersection, ok false for parallel segments.
+func segmentParams(a, b, c, d Point) (t, u float64, ok bool) {
+	den := (b[0]-a[0])*(d[1]-c[1]) - (b[1]-a[1])*(d[0]-c[0])
+	if den == 0 {
+		return 0, 0, false
+	}
+	t = ((c[0]-a[0])*(d[1]-c[1]) - (c[1]-a[1])*(d[0]-c[0])) / den
+	u = ((c[0]-a[0])*(b[1]-a[1]) - (c[1]-a[1])*(b[0]-a[0])) / den
+	return t, u, true
+}
+
+// intersect links the intersections of the edges [a, an] and [b, bn].
+// A vertex of one polygon on the other is an intersection itself, the
+// end vertices being left to the next edges, so that the ends of
+// overlapping edges are found too.
+func intersect(a, an, b, bn *clipVertex, eps float64) {
+	inside := func(v, w, wn *clipVertex) bool {
+		return v.neighbor == nil && v.p.Dist(w.p) > eps && v.p.Dist(wn.p) > eps && onSegment(v.p, w.p, wn.p, eps)
+	}
+	if a.neighbor == nil && b.neighbor == nil && a.p.Dist(b.p) <= eps {
+		link(a, b)
+	}
+	if inside(a, b, bn) {
+		linkOnEdge(a, b, bn)
+	}
+	if inside(b, a, an) {
+		linkOnEdge(b, a, an)
+	}
+	// segments touching at a vertex meet nowhere else
+	if onSegment(a.p, b.p, bn.p, eps) || onSegment(an.p, b.p, bn.p, eps) ||
+		onSegment(b.p, a.p, an.p, eps) || onSegment(bn.p, a.p, an.p, eps) {
+		return
+	}
+	if t, u, ok := segmentParams(a.p, an.p, b.p, bn.p); ok && t > 0 && t < 1 && u > 0 && u < 1 {
+		p := Point{a.p[0] + t*(an.p[0]-a.p[0]), a.p[1] + t*(an.p[1]-a.p[1])}
+		i := &clipVertex{p: p, alpha: t, inserted: true}
+		j := &clipVertex{p: p, alpha: u, inserted: true}
+		link(i, j)
+		insertBetween(i, a, an)
+		insertBetween(j, b, bn)
+	}
+}
+
+// label sets whether the edges of the list `l` are inside `other`. An
+// edge overlapping an edge of `other` is inside when its interior is
+// on the same side for `same`, and on the opposite side for `opposite`,
+// as if the polygons were moved apart or together by an infinitesimal
+// amount.
+func label(l *clipVertex, other Polygon, same, opposite bool) {
+	x, y := other.XY()
+	for v := l; ; {
+		w := v.next
+		switch {
+		case v.neighbor != nil && w.neighbor != nil && v.neighbor.next == w.neighbor:
+			v.in = same
+		case v.neighbor != nil && w.neighbor != nil && v.neighbor.prev == w.neighbor:
+			v.in = opposite
+		default:
+			v.in = IsPointInPoly(x, y, (v.p[0]+w.p[0])/2, (v.p[1]+w.p[1])/2)
+		}
+		if v = w; v == l {
+			break
+		}
+	}
+}
+
+// Boolean operations of Clip.
+const (
+	CLIP_INTERSECTION int = iota
+	CLIP_UNION
+	CLIP_DIFFERENCE
+)
+
+func (p Polygon) Intersection(q Polygon) []Polygon {
+	return Clip(p, q, CLIP_INTERSECTION)
+}
+
+func (p Polygon) Union(q Polygon) []Polygon {
+	return Clip(p, q, CLIP_UNION)
+}
+
+func (p Polygon) Difference(q Polygon) []Polygon {
+	return Clip(p, q, CLIP_DIFFERENCE)
+}
+
+// Clip computes the intersection, union or difference of the simple
+// polygons `s` and `c` by the Greiner-Hormann algorithm. The result is
+// a list of polygons, outlines counter-clockwise and holes clockwise.
+// A vertex of one polygon on the boundary of the other is an
+// intersection too, labelled crossing or bouncing from the edges around
+// it, and overlapping edges are kept once when the result follows them.
+//
+// Implemented per "Efficient Clipping of Arbitrary Polygons" by
+// G. Greiner and K. Hormann, ACM Transactions on Graphics, 17(2),
+// pages 71-83, 1998, with the degenerate cases of "Clipping simple
+// polygons with degenerate intersections" by E. L. Foster, K. Hormann
+// and R. T. Popa, Computers & Graphics: X, 2, 2019.
+func Clip(s, c Polygon, op int) []Polygon {
+	if len(s) < 3 || len(c) < 3 {
+		switch op {
+		case CLIP_UNION:
+			return nonEmpty(s, c)
+		case CLIP_DIFFERENCE:
+			return nonEmpty(s)
+		}
+		return nil
+	}
+	if s.Orientation() < 0 {
+		s = s.Reverse()
+	}
+	if c.Orientation() < 0 {
+		c = c.Reverse()
+	}
+
+	eps := 1e-12 * math.Max(s.scale(), c.scale())
+	sl, cl := clipList(s), clipList(c)
+	for a := sl; ; {
+		an := a.nextOriginal()
+		for b := cl; ; {
+			bn := b.nextOriginal()
+			intersect(a, an, b, bn, eps)
+			if b = bn; b == cl {
+				break
+			}
+		}
+		if a = an; a == sl {
+			break
+		}
+	}
+
+	// the edges kept are inside the other polygon for the intersection,
+	// the subject edges outside for union and difference, and the clip
+	// edges outside for union and inside for difference
+	label(sl, c, op != CLIP_UNION, op == CLIP_UNION)
+	label(cl, s, op == CLIP_UNION, op == CLIP_UNION)
+	keepS, keepC := op == CLIP_INTERSECTION, op != CLIP_UNION
+
+	found := false
+	for v := sl; ; {
+		if n := v.neighbor; n != nil && v.prev.in != v.in && n.prev.in != n.in {
+			v.crossing, n.crossing = true, true
+			found = true
+		}
+		if v = v.next; v == sl {
+			break
+		}
+	}
+
+	var ret []Polygon
+	if !found {
+		if sl.in == keepS {
+			ret = append(ret, s)
+		}
+		if cl.in == keepC {
+			if op == CLIP_DIFFERENCE {
+				c = c.Reverse()
+			}
+			ret = append(ret, c)
+		}
+		return ret
+	}
+
+	for v := sl; ; {
+		if v.crossing && !v.visited {
+			ret = append(ret, traverse(v, keepS, keepC))
+		}
+		if v = v.next; v == sl {
+			break
+		}
+	}
+	return orientResults(ret)
+}
+
+// traverse walks from the crossing `start` along the kept edges of both
+// polygons, switching polygon at every crossing, until it returns.
+func traverse(start *clipVertex, keepS, keepC bool) Polygon {
+	var poly Polygon
+	v, onS := start, true
+	poly = append(poly, v.p.Clone())
+	for {
+		v.visited, v.neighbor.visited = true, true
+		keep := keepC
+		if onS {
+			keep = keepS
+		}
+		forward := v.in == keep
+		for {
+			if forward {
+				v = v.next
+			} else {
+				v = v.prev
+			}
+			if v.crossing {
+				break
+			}
+			poly = append(poly, v.p.Clone())
+		}
+		v.visited, v.neighbor.visited = true, true
+		if v == start || v.neighbor == start {
+			return poly
+		}
+		poly = append(poly, v.p.Clone())
+		v, onS = v.neighbor, !onS
+	}
+}
+
+// orientResults orients the polygons nested in an odd number of others
+// clockwise, as holes, and the others counter-clockwise.
+func orientResults(polys []Polygon) []Polygon {
+	for i, p := range polys {
+		depth := 0
+		for j, q := range polys {
+			if i != j && q.Locate(p[0]) == POLY_INSIDE {
+				depth++
+			}
+		}
+		if (depth%2 == 1) != (p.Orientation() < 0) {
+			polys[i] = p.Reverse()
+		}
+	}
+	return polys
+}
+
+func nonEmpty(polys ...Polygon) []Polygon {
+	var ret []Polygon
+	for _, p := range polys {
+		if len(p) > 0 {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
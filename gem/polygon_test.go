@@ -0,0 +1,196 @@
+package gem
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func square(x, y, side float64) Polygon {
+	return Polygon{{x, y}, {x + side, y}, {x + side, y + side}, {x, y + side}}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func TestPolygonMeasures(t *testing.T) {
+	l := Polygon{{0, 0}, {2, 0}, {2, 1}, {1, 1}, {1, 2}, {0, 2}}
+	tests := []struct {
+		p           Polygon
+		area, perim float64
+		orientation int
+		centroid    Point
+	}{
+		{square(0, 0, 1), 1, 4, 1, Point{0.5, 0.5}},
+		{square(0, 0, 1).Reverse(), -1, 4, -1, Point{0.5, 0.5}},
+		{l, 3, 8, 1, Point{5.0 / 6, 5.0 / 6}},
+		{Polygon{{0, 0}, {1, 1}, {2, 2}}, 0, 2 * math.Sqrt(8), 0, Point{1, 1}},
+	}
+	for i, tt := range tests {
+		c := tt.p.Centroid()
+		if !near(tt.p.SignedArea(), tt.area) || !near(tt.p.Perimeter(), tt.perim) ||
+			tt.p.Orientation() != tt.orientation || !near(c[0], tt.centroid[0]) || !near(c[1], tt.centroid[1]) {
+			t.Errorf("%d: area %v perimeter %v orientation %d centroid %v", i,
+				tt.p.SignedArea(), tt.p.Perimeter(), tt.p.Orientation(), c)
+		}
+	}
+}
+
+func TestPolygonLocate(t *testing.T) {
+	l := Polygon{{0, 0}, {2, 0}, {2, 1}, {1, 1}, {1, 2}, {0, 2}}
+	tests := []struct {
+		q        Point
+		location int
+	}{
+		{Point{0.5, 0.5}, POLY_INSIDE},
+		{Point{1.5, 1.5}, POLY_OUTSIDE},
+		{Point{1.5, 1}, POLY_BOUNDARY},
+		{Point{0, 0}, POLY_BOUNDARY},
+		{Point{0, 1}, POLY_BOUNDARY},
+		{Point{-1, 1}, POLY_OUTSIDE},
+	}
+	for _, tt := range tests {
+		if got := l.Locate(tt.q); got != tt.location {
+			t.Errorf("%v located %d, expected %d", tt.q, got, tt.location)
+		}
+	}
+	if l.Contains(Point{0, 1}, false) || !l.Contains(Point{0, 1}, true) {
+		t.Errorf("boundary handling of Contains")
+	}
+}
+
+func TestConvexHullSimplify(t *testing.T) {
+	var points []Point
+	for x := 0; x <= 4; x++ {
+		for y := 0; y <= 4; y++ {
+			points = append(points, Point{float64(x), float64(y)})
+		}
+	}
+	hull := ConvexHull(points)
+	if len(hull) != 4 || hull.Orientation() != 1 || !near(hull.Area(), 16) {
+		t.Errorf("hull %v", hull)
+	}
+	noisy := Polygon{{0, 0}, {1, 0.01}, {2, -0.01}, {3, 0}, {3, 1}, {3.01, 2}, {3, 3}, {1.5, 3}, {0, 3}, {0.01, 1.5}}
+	if s := noisy.Simplify(0.1); len(s) != 4 || !near(s.Area(), 9) {
+		t.Errorf("simplified to %v", s)
+	}
+	// only the collinear (1.5, 3) goes
+	if s := noisy.Simplify(0.001); len(s) != len(noisy)-1 {
+		t.Errorf("simplified to %d vertices with a small tolerance", len(s))
+	}
+}
+
+func totalArea(polys []Polygon) float64 {
+	a := 0.0
+	for _, p := range polys {
+		a += p.SignedArea()
+	}
+	return a
+}
+
+func TestClip(t *testing.T) {
+	u := Polygon{{0, 0}, {3, 0}, {3, 3}, {2, 3}, {2, 1}, {1, 1}, {1, 3}, {0, 3}}
+	bar := Polygon{{-1, 2}, {4, 2}, {4, 2.5}, {-1, 2.5}}
+	diamond := Polygon{{1, 0}, {2, -1}, {3, 0}, {2, 1}}
+	tests := []struct {
+		name string
+		s, c Polygon
+		op   int
+		n    int
+		area float64
+	}{
+		{"overlap", square(0, 0, 2), square(1, 1, 2), CLIP_INTERSECTION, 1, 1},
+		{"overlap", square(0, 0, 2), square(1, 1, 2), CLIP_UNION, 1, 7},
+		{"overlap", square(0, 0, 2), square(1, 1, 2), CLIP_DIFFERENCE, 1, 3},
+		{"clockwise", square(0, 0, 2).Reverse(), square(1, 1, 2), CLIP_DIFFERENCE, 1, 3},
+		{"disjoint", square(0, 0, 1), square(5, 5, 1), CLIP_INTERSECTION, 0, 0},
+		{"disjoint", square(0, 0, 1), square(5, 5, 1), CLIP_UNION, 2, 2},
+		{"contained", square(0, 0, 4), square(1, 1, 1), CLIP_INTERSECTION, 1, 1},
+		{"contained", square(0, 0, 4), square(1, 1, 1), CLIP_DIFFERENCE, 2, 15},
+		{"contained", square(1, 1, 1), square(0, 0, 4), CLIP_DIFFERENCE, 0, 0},
+		{"concave", u, bar, CLIP_INTERSECTION, 2, 1},
+		{"concave", u, bar, CLIP_DIFFERENCE, 3, 7 - 1},
+		{"shared edge", square(0, 0, 1), square(1, 0, 1), CLIP_UNION, 1, 2},
+		{"shared edge", square(0, 0, 1), square(1, 0, 1), CLIP_INTERSECTION, 0, 0},
+		{"shared edge", square(0, 0, 1), square(1, 0, 1), CLIP_DIFFERENCE, 1, 1},
+		{"shared edges", square(0, 0, 2), square(1, 0, 1), CLIP_INTERSECTION, 1, 1},
+		{"shared edges", square(0, 0, 2), square(1, 0, 1), CLIP_UNION, 1, 4},
+		{"shared edges", square(0, 0, 2), square(1, 0, 1), CLIP_DIFFERENCE, 1, 3},
+		{"partly shared edge", square(0, 0, 2), square(1, 1, 2), CLIP_UNION, 1, 7},
+		{"partly shared edge", square(0, 0, 2), Polygon{{1, 2}, {3, 2}, {3, 3}, {1, 3}}, CLIP_UNION, 1, 6},
+		{"partly shared edge", square(0, 0, 2), Polygon{{1, 2}, {3, 2}, {3, 3}, {1, 3}}, CLIP_INTERSECTION, 0, 0},
+		{"identical", square(0, 0, 2), square(0, 0, 2), CLIP_INTERSECTION, 1, 4},
+		{"identical", square(0, 0, 2), square(0, 0, 2).Reverse(), CLIP_UNION, 1, 4},
+		{"identical", square(0, 0, 2), square(0, 0, 2), CLIP_DIFFERENCE, 0, 0},
+		{"vertices on edges", square(0, 0, 2), diamond, CLIP_INTERSECTION, 1, 0.5},
+		{"vertices on edges", square(0, 0, 2), diamond, CLIP_UNION, 1, 5.5},
+		{"vertices on edges", square(0, 0, 2), diamond, CLIP_DIFFERENCE, 1, 3.5},
+		{"corner", square(0, 0, 1), square(1, 1, 1), CLIP_UNION, 2, 2},
+		{"corner", square(0, 0, 1), square(1, 1, 1), CLIP_INTERSECTION, 0, 0},
+		{"inner corner", square(0, 0, 2), square(0, 0, 1), CLIP_DIFFERENCE, 1, 3},
+		{"inner vertex", square(0, 0, 2), Polygon{{0, 1}, {1, 0.5}, {1, 1.5}}, CLIP_DIFFERENCE, 2, 3.5},
+		{"inner vertex", square(0, 0, 2), Polygon{{0, 1}, {1, 0.5}, {1, 1.5}}, CLIP_INTERSECTION, 1, 0.5},
+	}
+	for _, tt := range tests {
+		got := Clip(tt.s, tt.c, tt.op)
+		if len(got) != tt.n || !near(totalArea(got), tt.area) {
+			t.Errorf("%s op %d: %d polygons of area %v, expected %d of area %v: %v",
+				tt.name, tt.op, len(got), totalArea(got), tt.n, tt.area, got)
+		}
+	}
+}
+
+// convexClip is the intersection of convex polygons by the
+// Sutherland-Hodgman algorithm.
+func convexClip(s, c Polygon) Polygon {
+	out := s
+	for i := range c {
+		a, b := c[i], c[(i+1)%len(c)]
+		in, side := out, func(p Point) float64 { return cross(a, b, p) }
+		out = nil
+		for j := range in {
+			p, q := in[j], in[(j+1)%len(in)]
+			if side(p) >= 0 {
+				out = append(out, p)
+			}
+			if (side(p) < 0) != (side(q) < 0) && side(p) != 0 && side(q) != 0 {
+				t := side(p) / (side(p) - side(q))
+				out = append(out, Point{p[0] + t*(q[0]-p[0]), p[1] + t*(q[1]-p[1])})
+			}
+		}
+	}
+	return out
+}
+
+func TestClipRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(3))
+	// convex polygons on a coarse grid share vertices and edges often
+	hull := func() Polygon {
+		var ps []Point
+		for len(ps) < 8 {
+			ps = append(ps, Point{float64(rnd.Intn(5)), float64(rnd.Intn(5))})
+		}
+		return ConvexHull(ps)
+	}
+	for round := 0; round < 2000; round++ {
+		s, c := hull(), hull()
+		if s.Area() == 0 || c.Area() == 0 {
+			continue
+		}
+		inter := convexClip(s, c).Area()
+		tests := []struct {
+			op   int
+			area float64
+		}{
+			{CLIP_INTERSECTION, inter},
+			{CLIP_UNION, s.Area() + c.Area() - inter},
+			{CLIP_DIFFERENCE, s.Area() - inter},
+		}
+		for _, tt := range tests {
+			if got := Clip(s, c, tt.op); !near(totalArea(got), tt.area) {
+				t.Fatalf("%v op %d %v: area %v, expected %v: %v", s, tt.op, c, totalArea(got), tt.area, got)
+			}
+		}
+	}
+}
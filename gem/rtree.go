@@ -0,0 +1,532 @@
+package gem
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+)
+
+// Spatial is an object that can be indexed by an Rtree.
+type Spatial interface {
+	Bounds() *Rect
+}
+
+type rtreeEntry struct {
+	bb    *Rect
+	child *rtreeNode // nil in leaves
+	obj   Spatial    // nil in inner nodes
+}
+
+type rtreeNode struct {
+	parent  *rtreeNode
+	level   int // zero for leaves
+	entries []rtreeEntry
+}
+
+func (n *rtreeNode) bounds() *Rect {
+	rects := make([]*Rect, len(n.entries))
+	for i, e := range n.entries {
+		rects[i] = e.bb
+	}
+	return BoundingBoxN(rects...)
+}
+
+// Rtree is an R*-tree over Spatial objects of dimension Dim. Every
+// node but the root holds between MinChildren and MaxChildren entries.
+//
+// Implemented per "The R*-tree: An Efficient and Robust Access Method
+// for Points and Rectangles" by N. Beckmann, H.-P. Kriegel, R. Schneider
+// and B. Seeger, ACM SIGMOD, pages 322-331, 1990.
+type Rtree struct {
+	Dim         int
+	MinChildren int
+	MaxChildren int
+	root        *rtreeNode
+	size        int
+}
+
+// NewRtree constructs an empty tree and bulk-loads `objs` into it. A
+// MinChildren outside [1, MaxChildren/2] is set to 40% of MaxChildren.
+func NewRtree(dim, min, max int, objs ...Spatial) *Rtree {
+	max = IntMax(max, 2)
+	if min < 1 || min > max/2 {
+		min = IntMax(max*2/5, 1)
+	}
+	t := &Rtree{Dim: dim, MinChildren: min, MaxChildren: max, root: &rtreeNode{}}
+	if len(objs) > 0 {
+		t.BulkLoad(objs)
+	}
+	return t
+}
+
+// Size returns the number of objects in the tree.
+func (t *Rtree) Size() int {
+	return t.size
+}
+
+// Depth returns the number of levels of the tree.
+func (t *Rtree) Depth() int {
+	return t.root.level + 1
+}
+
+//#################################################################
+//                   Insertion
+//#################################################################
+
+// Insert adds `obj` to the tree.
+func (t *Rtree) Insert(obj Spatial) {
+	bb := obj.Bounds()
+	if len(bb.P) != t.Dim {
+		panic(DimError{t.Dim, len(bb.P)})
+	}
+	t.insert(rtreeEntry{bb: bb, obj: obj}, 0, make(map[int]bool))
+	t.size++
+}
+
+// insert places `e` in a node at `level`. `reinserted` records the
+// levels that already had a forced reinsertion during this insertion.
+func (t *Rtree) insert(e rtreeEntry, level int, reinserted map[int]bool) {
+	n := t.chooseNode(e.bb, level)
+	if e.child != nil {
+		e.child.parent = n
+	}
+	n.entries = append(n.entries, e)
+	t.adjust(n)
+	t.overflow(n, reinserted)
+}
+
+// chooseNode descends from the root to the node at `level` whose
+// bounding box needs the least enlargement to include `bb`. Above the
+// leaves the overlap enlargement is minimized first.
+func (t *Rtree) chooseNode(bb *Rect, level int) *rtreeNode {
+	n := t.root
+	for n.level > level {
+		best, bestOverlap, bestEnl, bestSize := 0, math.Inf(1), math.Inf(1), math.Inf(1)
+		for i, e := range n.entries {
+			enlarged := BoundingBox(e.bb, bb)
+			size := e.bb.Size()
+			enl := enlarged.Size() - size
+			overlap := 0.0
+			if n.level == 1 {
+				for j, o := range n.entries {
+					if j != i {
+						overlap += overlapSize(enlarged, o.bb) - overlapSize(e.bb, o.bb)
+					}
+				}
+			}
+			if overlap < bestOverlap || overlap == bestOverlap && (enl < bestEnl || enl == bestEnl && size < bestSize) {
+				best, bestOverlap, bestEnl, bestSize = i, overlap, enl, size
+			}
+		}
+		n = n.entries[best].child
+	}
+	return n
+}
+
+// adjust recomputes the bounding boxes on the path from `n` to the root.
+func (t *Rtree) adjust(n *rtreeNode) {
+	for ; n != t.root; n = n.parent {
+		n.parent.entries[n.index()].bb = n.bounds()
+	}
+}
+
+func (n *rtreeNode) index() int {
+	for i, e := range n.parent.entries {
+		if e.child == n {
+			return i
+		}
+	}
+	panic("rtree: node not found in its parent")
+}
+
+// overflow treats the overflowing nodes from `n` upwards, first by a
+// forced reinsertion once per level and then by splitting.
+func (t *Rtree) overflow(n *rtreeNode, reinserted map[int]bool) {
+	for len(n.entries) > t.MaxChildren {
+		if n != t.root && !reinserted[n.level] {
+			reinserted[n.level] = true
+			t.reinsert(n, reinserted)
+			return
+		}
+		nn := t.split(n)
+		if n == t.root {
+			t.root = &rtreeNode{level: n.level + 1}
+			for _, c := range []*rtreeNode{n, nn} {
+				c.parent = t.root
+				t.root.entries = append(t.root.entries, rtreeEntry{bb: c.bounds(), child: c})
+			}
+			return
+		}
+		p := n.parent
+		nn.parent = p
+		p.entries[n.index()].bb = n.bounds()
+		p.entries = append(p.entries, rtreeEntry{bb: nn.bounds(), child: nn})
+		n = p
+	}
+}
+
+// reinsert removes the 30% of the entries of `n` farthest from its
+// center and inserts them again, closest first.
+func (t *Rtree) reinsert(n *rtreeNode, reinserted map[int]bool) {
+	c := n.bounds().C
+	sort.SliceStable(n.entries, func(i, j int) bool {
+		return c.Dist(n.entries[i].bb.C) > c.Dist(n.entries[j].bb.C)
+	})
+	k := IntMax(len(n.entries)*3/10, 1)
+	removed := append([]rtreeEntry(nil), n.entries[:k]...)
+	n.entries = append([]rtreeEntry(nil), n.entries[k:]...)
+	t.adjust(n)
+	for i := len(removed) - 1; i >= 0; i-- {
+		t.insert(removed[i], n.level, reinserted)
+	}
+}
+
+// split moves a part of the entries of `n` to a new sibling. The split
+// axis minimizes the sum of the margins of all the distributions, and
+// the distribution along it minimizes the overlap, then the area.
+func (t *Rtree) split(n *rtreeNode) *rtreeNode {
+	m, total := t.MinChildren, len(n.entries)
+	order := func(axis int, upper bool) {
+		sort.SliceStable(n.entries, func(i, j int) bool {
+			a, b := n.entries[i].bb, n.entries[j].bb
+			if upper {
+				return a.C[axis]+a.P[axis] < b.C[axis]+b.P[axis]
+			}
+			return a.C[axis]-a.P[axis] < b.C[axis]-b.P[axis]
+		})
+	}
+	groups := func(k int) (*Rect, *Rect) {
+		return entriesBounds(n.entries[:k]), entriesBounds(n.entries[k:])
+	}
+
+	bestAxis, bestMargin := 0, math.Inf(1)
+	for axis := 0; axis < t.Dim; axis++ {
+		margin := 0.0
+		for _, upper := range []bool{false, true} {
+			order(axis, upper)
+			for k := m; k <= total-m; k++ {
+				a, b := groups(k)
+				margin += a.Margin() + b.Margin()
+			}
+		}
+		if margin < bestMargin {
+			bestAxis, bestMargin = axis, margin
+		}
+	}
+
+	bestK, bestUpper, bestOverlap, bestSize := m, false, math.Inf(1), math.Inf(1)
+	for _, upper := range []bool{false, true} {
+		order(bestAxis, upper)
+		for k := m; k <= total-m; k++ {
+			a, b := groups(k)
+			overlap, size := overlapSize(a, b), a.Size()+b.Size()
+			if overlap < bestOverlap || overlap == bestOverlap && size < bestSize {
+				bestK, bestUpper, bestOverlap, bestSize = k, upper, overlap, size
+			}
+		}
+	}
+	order(bestAxis, bestUpper)
+
+	nn := &rtreeNode{level: n.level, entries: append([]rtreeEntry(nil), n.entries[bestK:]...)}
+	n.entries = append([]rtreeEntry(nil), n.entries[:bestK]...)
+	for _, e := range nn.entries {
+		if e.child != nil {
+			e.child.parent = nn
+		}
+	}
+	return nn
+}
+
+func entriesBounds(entries []rtreeEntry) *Rect {
+	bb := entries[0].bb
+	for _, e := range entries[1:] {
+		bb = BoundingBox(bb, e.bb)
+	}
+	return bb
+}
+
+// overlapSize returns the measure of the intersection of r1 and r2.
+func overlapSize(r1, r2 *Rect) float64 {
+	if r := Intersect(r1, r2); r != nil {
+		return r.Size()
+	}
+	return 0
+}
+
+//#################################################################
+//                   Deletion
+//#################################################################
+
+// Delete removes `obj`, compared with ==, from the tree and reports
+// whether it was found.
+func (t *Rtree) Delete(obj Spatial) bool {
+	return t.DeleteFunc(obj, func(a, b Spatial) bool { return a == b })
+}
+
+// DeleteFunc removes the first object equal to `obj` according to `eq`
+// and reports whether one was found.
+func (t *Rtree) DeleteFunc(obj Spatial, eq func(a, b Spatial) bool) bool {
+	n, i := t.findLeaf(t.root, obj, obj.Bounds(), eq)
+	if n == nil {
+		return false
+	}
+	n.entries = append(n.entries[:i], n.entries[i+1:]...)
+	t.condense(n)
+	t.size--
+	return true
+}
+
+func (t *Rtree) findLeaf(n *rtreeNode, obj Spatial, bb *Rect, eq func(a, b Spatial) bool) (*rtreeNode, int) {
+	for i, e := range n.entries {
+		if n.level == 0 {
+			if eq(e.obj, obj) {
+				return n, i
+			}
+		} else if overlapsTol(e.bb, bb, pruneTol) {
+			if l, j := t.findLeaf(e.child, obj, bb, eq); l != nil {
+				return l, j
+			}
+		}
+	}
+	return nil, 0
+}
+
+// condense removes the underfull nodes on the path from `n` to the root,
+// reinserts their entries and shortens the tree if the root is left
+// with a single child.
+func (t *Rtree) condense(n *rtreeNode) {
+	var orphans []*rtreeNode
+	for ; n != t.root; n = n.parent {
+		p := n.parent
+		if len(n.entries) < t.MinChildren {
+			i := n.index()
+			p.entries = append(p.entries[:i], p.entries[i+1:]...)
+			orphans = append(orphans, n)
+		} else {
+			p.entries[n.index()].bb = n.bounds()
+		}
+	}
+	if len(t.root.entries) == 0 {
+		t.root = &rtreeNode{}
+	}
+	for _, o := range orphans {
+		if o.level <= t.root.level {
+			for _, e := range o.entries {
+				t.insert(e, o.level, make(map[int]bool))
+			}
+		} else {
+			for _, e := range leafEntries(o, nil) {
+				t.insert(e, 0, make(map[int]bool))
+			}
+		}
+	}
+	for t.root.level > 0 && len(t.root.entries) == 1 {
+		t.root = t.root.entries[0].child
+		t.root.parent = nil
+	}
+}
+
+func leafEntries(n *rtreeNode, acc []rtreeEntry) []rtreeEntry {
+	if n.level == 0 {
+		return append(acc, n.entries...)
+	}
+	for _, e := range n.entries {
+		acc = leafEntries(e.child, acc)
+	}
+	return acc
+}
+
+//#################################################################
+//                   Bulk Loading
+//#################################################################
+
+// BulkLoad rebuilds the tree from its objects and `objs` by
+// Sort-Tile-Recursive packing.
+//
+// Implemented per "STR: A Simple and Efficient Algorithm for R-Tree
+// Packing" by S. Leutenegger, M. Lopez and J. Edgington, ICDE, 1997.
+func (t *Rtree) BulkLoad(objs []Spatial) {
+	entries := leafEntries(t.root, nil)
+	for _, obj := range objs {
+		bb := obj.Bounds()
+		if len(bb.P) != t.Dim {
+			panic(DimError{t.Dim, len(bb.P)})
+		}
+		entries = append(entries, rtreeEntry{bb: bb, obj: obj})
+	}
+	t.size = len(entries)
+	if len(entries) <= t.MaxChildren {
+		t.root = &rtreeNode{entries: entries}
+		return
+	}
+	for level := 0; ; level++ {
+		nodes := t.pack(entries, level)
+		if len(nodes) == 1 {
+			t.root = nodes[0]
+			return
+		}
+		entries = make([]rtreeEntry, len(nodes))
+		for i, c := range nodes {
+			entries[i] = rtreeEntry{bb: c.bounds(), child: c}
+		}
+	}
+}
+
+// pack orders `entries` into tiles and groups them into nodes at
+// `level`, filled as evenly as possible.
+func (t *Rtree) pack(entries []rtreeEntry, level int) []*rtreeNode {
+	M := t.MaxChildren
+	P := (len(entries) + M - 1) / M
+	t.tile(entries, 0, P)
+	nodes := make([]*rtreeNode, P)
+	for i := range nodes {
+		lo, hi := i*len(entries)/P, (i+1)*len(entries)/P
+		nodes[i] = &rtreeNode{level: level, entries: append([]rtreeEntry(nil), entries[lo:hi]...)}
+		for _, e := range nodes[i].entries {
+			if e.child != nil {
+				e.child.parent = nodes[i]
+			}
+		}
+	}
+	return nodes
+}
+
+// tile sorts `entries`, to be packed into `P` nodes, by the center
+// along `axis` and recursively tiles the slabs along the next axes.
+func (t *Rtree) tile(entries []rtreeEntry, axis, P int) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].bb.C[axis] < entries[j].bb.C[axis]
+	})
+	if axis == t.Dim-1 || P <= 1 {
+		return
+	}
+	S := int(math.Ceil(math.Pow(float64(P), 1/float64(t.Dim-axis))))
+	per := (P + S - 1) / S // nodes per slab
+	size := per * t.MaxChildren
+	for lo := 0; lo < len(entries); lo += size {
+		hi := lo + size
+		if hi > len(entries) {
+			hi = len(entries)
+		}
+		t.tile(entries[lo:hi], axis+1, per)
+	}
+}
+
+//#################################################################
+//                   Queries
+//#################################################################
+
+// overlaps tests whether r1 and r2 share at least a boundary point.
+func overlaps(r1, r2 *Rect) bool {
+	return overlapsTol(r1, r2, 0)
+}
+
+// overlapsTol is overlaps with the boxes widened by `tol` relative to
+// their coordinates. As the boxes are stored by center and half-lengths,
+// a bounding box may miss its contents by a rounding error, so subtrees
+// are pruned with a small tolerance.
+func overlapsTol(r1, r2 *Rect, tol float64) bool {
+	for i := range r1.C {
+		eps := tol * (math.Abs(r1.C[i]) + r1.P[i] + math.Abs(r2.C[i]) + r2.P[i])
+		if r1.C[i]-r1.P[i] > r2.C[i]+r2.P[i]+eps || r2.C[i]-r2.P[i] > r1.C[i]+r1.P[i]+eps {
+			return false
+		}
+	}
+	return true
+}
+
+// pruneTol is the relative tolerance of the subtree pruning.
+const pruneTol = 1e-12
+
+// SearchIntersect returns the objects whose bounds intersect `bb`,
+// touching boundaries included.
+func (t *Rtree) SearchIntersect(bb *Rect) []Spatial {
+	return t.search(t.root, bb, overlaps, nil)
+}
+
+// SearchContained returns the objects whose bounds lie inside `bb`.
+func (t *Rtree) SearchContained(bb *Rect) []Spatial {
+	return t.search(t.root, bb, func(r, bb *Rect) bool { return bb.ContainsRect(r) }, nil)
+}
+
+// SearchPoint returns the objects whose bounds contain `p`.
+func (t *Rtree) SearchPoint(p Point) []Spatial {
+	return t.SearchIntersect(p.ToRect(0))
+}
+
+// search collects the objects of the subtree at `n` accepted by `match`.
+// Subtrees are pruned by intersection, which every match implies.
+func (t *Rtree) search(n *rtreeNode, bb *Rect, match func(r, bb *Rect) bool, acc []Spatial) []Spatial {
+	for _, e := range n.entries {
+		if n.level == 0 {
+			if overlaps(e.bb, bb) && match(e.bb, bb) {
+				acc = append(acc, e.obj)
+			}
+		} else if overlapsTol(e.bb, bb, pruneTol) {
+			acc = t.search(e.child, bb, match, acc)
+		}
+	}
+	return acc
+}
+
+type knnItem struct {
+	dist float64
+	e    rtreeEntry
+}
+
+type knnQueue []knnItem
+
+func (q knnQueue) Len() int            { return len(q) }
+func (q knnQueue) Less(i, j int) bool  { return q[i].dist < q[j].dist }
+func (q knnQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *knnQueue) Push(x interface{}) { *q = append(*q, x.(knnItem)) }
+func (q *knnQueue) Pop() interface{} {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
+
+// NearestNeighbors returns the `k` objects whose bounds are closest to
+// `p`, closest first. The search is best-first by MinDist, and for a
+// single neighbor subtrees farther than the smallest MinMaxDist seen
+// are pruned.
+func (t *Rtree) NearestNeighbors(k int, p Point) []Spatial {
+	if len(p) != t.Dim {
+		panic(DimError{t.Dim, len(p)})
+	}
+	ret := make([]Spatial, 0, k)
+	bound := math.Inf(1)
+	q := &knnQueue{}
+	push := func(n *rtreeNode) {
+		for _, e := range n.entries {
+			d := p.MinDist(e.bb)
+			if d > bound {
+				continue
+			}
+			if k == 1 && e.child != nil {
+				bound = math.Min(bound, p.MinMaxDist(e.bb))
+			}
+			heap.Push(q, knnItem{d, e})
+		}
+	}
+	push(t.root)
+	for q.Len() > 0 && len(ret) < k {
+		it := heap.Pop(q).(knnItem)
+		if it.e.child != nil {
+			push(it.e.child)
+		} else {
+			ret = append(ret, it.e.obj)
+		}
+	}
+	return ret
+}
+
+// NearestNeighbor returns the object closest to `p`, nil if the tree
+// is empty.
+func (t *Rtree) NearestNeighbor(p Point) Spatial {
+	if ret := t.NearestNeighbors(1, p); len(ret) > 0 {
+		return ret[0]
+	}
+	return nil
+}
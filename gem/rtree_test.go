@@ -0,0 +1,138 @@
+package gem
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func randRects(n int, seed int64) []Spatial {
+	r := rand.New(rand.NewSource(seed))
+	objs := make([]Spatial, n)
+	for i := range objs {
+		objs[i] = &Rect{Point{r.Float64() * 100, r.Float64() * 100}, Point{r.Float64() * 2, r.Float64() * 2}}
+	}
+	return objs
+}
+
+// checkTree verifies the parent links, levels, fill and tightness of
+// the bounding boxes and returns the number of objects.
+func checkTree(t *testing.T, tr *Rtree, n *rtreeNode) int {
+	if n != tr.root && (len(n.entries) < tr.MinChildren || len(n.entries) > tr.MaxChildren) {
+		t.Errorf("node at level %d with %d entries", n.level, len(n.entries))
+	}
+	if n.level == 0 {
+		return len(n.entries)
+	}
+	count := 0
+	for _, e := range n.entries {
+		if e.child.parent != n || e.child.level != n.level-1 {
+			t.Fatalf("broken link at level %d", n.level)
+		}
+		if bb := e.child.bounds(); e.bb.C.Dist(bb.C) > 1e-9 || e.bb.P.Dist(bb.P) > 1e-9 {
+			t.Errorf("bounds %v, expected %v", e.bb, bb)
+		}
+		count += checkTree(t, tr, e.child)
+	}
+	return count
+}
+
+func sameObjects(a, b []Spatial) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	key := func(s Spatial) float64 { return s.Bounds().C[0]*1e6 + s.Bounds().C[1] }
+	sort.Slice(a, func(i, j int) bool { return key(a[i]) < key(a[j]) })
+	sort.Slice(b, func(i, j int) bool { return key(b[i]) < key(b[j]) })
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func bruteSearch(objs []Spatial, match func(r *Rect) bool) []Spatial {
+	var ret []Spatial
+	for _, o := range objs {
+		if match(o.Bounds()) {
+			ret = append(ret, o)
+		}
+	}
+	return ret
+}
+
+func TestRtreeInsertSearch(t *testing.T) {
+	objs := randRects(1000, 1)
+	inserted := NewRtree(2, 3, 8)
+	for _, o := range objs {
+		inserted.Insert(o)
+	}
+	loaded := NewRtree(2, 3, 8, objs...)
+	q := &Rect{Point{40, 60}, Point{10, 5}}
+	for _, tr := range []*Rtree{inserted, loaded} {
+		if n := checkTree(t, tr, tr.root); n != len(objs) || tr.Size() != len(objs) {
+			t.Fatalf("%d objects, size %d", n, tr.Size())
+		}
+		if !sameObjects(tr.SearchIntersect(q), bruteSearch(objs, func(r *Rect) bool { return overlaps(r, q) })) {
+			t.Errorf("SearchIntersect differs from brute force")
+		}
+		if !sameObjects(tr.SearchContained(q), bruteSearch(objs, func(r *Rect) bool { return q.ContainsRect(r) })) {
+			t.Errorf("SearchContained differs from brute force")
+		}
+	}
+}
+
+func TestRtreeNearestNeighbors(t *testing.T) {
+	objs := randRects(500, 2)
+	tr := NewRtree(2, 0, 16, objs...)
+	p := Point{50, 50}
+	dist := func(s Spatial) float64 { return p.MinDist(s.Bounds()) }
+	sorted := append([]Spatial(nil), objs...)
+	sort.Slice(sorted, func(i, j int) bool { return dist(sorted[i]) < dist(sorted[j]) })
+	knn := tr.NearestNeighbors(10, p)
+	for i, o := range knn {
+		if dist(o) != dist(sorted[i]) {
+			t.Errorf("neighbor %d at %v, expected %v", i, dist(o), dist(sorted[i]))
+		}
+	}
+	if nn := tr.NearestNeighbor(p); dist(nn) != dist(sorted[0]) {
+		t.Errorf("nearest at %v, expected %v", dist(nn), dist(sorted[0]))
+	}
+	if NewRtree(2, 2, 4).NearestNeighbor(p) != nil {
+		t.Errorf("nearest neighbor in an empty tree")
+	}
+}
+
+func TestRtreeDelete(t *testing.T) {
+	objs := randRects(600, 3)
+	tr := NewRtree(2, 2, 6, objs[:300]...)
+	for _, o := range objs[300:] {
+		tr.Insert(o)
+	}
+	for i, o := range objs {
+		if i%3 != 0 && !tr.Delete(o) {
+			t.Fatalf("object %d not found", i)
+		}
+	}
+	if tr.Delete(objs[1]) {
+		t.Errorf("deleted object found again")
+	}
+	var kept []Spatial
+	for i := 0; i < len(objs); i += 3 {
+		kept = append(kept, objs[i])
+	}
+	if n := checkTree(t, tr, tr.root); n != len(kept) || tr.Size() != len(kept) {
+		t.Fatalf("%d objects, size %d", n, tr.Size())
+	}
+	all := &Rect{Point{50, 50}, Point{60, 60}}
+	if !sameObjects(tr.SearchIntersect(all), kept) {
+		t.Errorf("remaining objects differ")
+	}
+	for _, o := range kept {
+		tr.Delete(o)
+	}
+	if tr.Size() != 0 || tr.Depth() != 1 || len(tr.root.entries) != 0 {
+		t.Errorf("size %d depth %d after deleting all", tr.Size(), tr.Depth())
+	}
+}
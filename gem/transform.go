@@ -0,0 +1,462 @@
+package gem
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// ErrSingular is returned when a transform cannot be inverted or
+// estimated from degenerate points.
+var ErrSingular = errors.New("gem: singular transform")
+
+//#################################################################
+//                   Transforms
+//#################################################################
+
+// Transform is a projective transform of the points of dimension Dim,
+// the row-major (Dim+1) x (Dim+1) matrix M acting on homogeneous
+// coordinates. It is affine when its last row is (0, ..., 0, 1).
+type Transform struct {
+	Dim int
+	M   []float64
+}
+
+// NewTransform wraps the homogeneous matrix `m`.
+func NewTransform(dim int, m []float64) *Transform {
+	if len(m) != (dim+1)*(dim+1) {
+		panic(DimError{(dim + 1) * (dim + 1), len(m)})
+	}
+	return &Transform{Dim: dim, M: m}
+}
+
+func Identity(dim int) *Transform {
+	return &Transform{Dim: dim, M: identity(dim + 1)}
+}
+
+func Translation(v Point) *Transform {
+	t := Identity(len(v))
+	for i, x := range v {
+		t.M[i*t.n()+t.Dim] = x
+	}
+	return t
+}
+
+// Scaling scales each axis `i` by s[i] about the origin.
+func Scaling(s Point) *Transform {
+	t := Identity(len(s))
+	for i, x := range s {
+		t.M[i*t.n()+i] = x
+	}
+	return t
+}
+
+// Rotation turns the plane counter-clockwise by `theta` about the
+// origin.
+func Rotation(theta float64) *Transform {
+	c, s := math.Cos(theta), math.Sin(theta)
+	return NewTransform(2, []float64{c, -s, 0, s, c, 0, 0, 0, 1})
+}
+
+// Rotation3D turns the space by `theta` about `axis`, counter-clockwise
+// when the axis points to the viewer.
+func Rotation3D(axis Point, theta float64) *Transform {
+	if len(axis) != 3 {
+		panic(DimError{3, len(axis)})
+	}
+	k := axis.Clone()
+	k.Normalize()
+	c, s := math.Cos(theta), math.Sin(theta)
+	t := Identity(3)
+	// Rodrigues' formula, c I + s [k]x + (1 - c) k kT
+	cross := [3][3]float64{{0, -k[2], k[1]}, {k[2], 0, -k[0]}, {-k[1], k[0], 0}}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			t.M[i*4+j] = s*cross[i][j] + (1-c)*k[i]*k[j]
+		}
+		t.M[i*4+i] += c
+	}
+	return t
+}
+
+func (t *Transform) n() int {
+	return t.Dim + 1
+}
+
+func (t *Transform) Clone() *Transform {
+	return &Transform{Dim: t.Dim, M: append([]float64(nil), t.M...)}
+}
+
+// IsAffine tests whether the transform keeps parallel lines parallel.
+func (t *Transform) IsAffine() bool {
+	n := t.n()
+	for j := 0; j < t.Dim; j++ {
+		if t.M[t.Dim*n+j] != 0 {
+			return false
+		}
+	}
+	return t.M[n*n-1] != 0
+}
+
+// Mul returns the product t o, the transform applying `o` then `t`.
+func (t *Transform) Mul(o *Transform) *Transform {
+	if t.Dim != o.Dim {
+		panic(DimError{t.Dim, o.Dim})
+	}
+	return &Transform{Dim: t.Dim, M: matMul(t.M, o.M, t.n())}
+}
+
+// Then returns the transform applying `t` then `o`.
+func (t *Transform) Then(o *Transform) *Transform {
+	return o.Mul(t)
+}
+
+func (t *Transform) Inverse() (*Transform, error) {
+	m, err := matInverse(t.M, t.n())
+	if err != nil {
+		return nil, err
+	}
+	return &Transform{Dim: t.Dim, M: m}, nil
+}
+
+// Apply maps `p`. Points sent to infinity by a projective transform
+// get infinite or NaN coordinates.
+func (t *Transform) Apply(p Point) Point {
+	if len(p) != t.Dim {
+		panic(DimError{t.Dim, len(p)})
+	}
+	n := t.n()
+	h := make([]float64, n)
+	for i := range h {
+		row := t.M[i*n : i*n+n]
+		h[i] = row[t.Dim]
+		for j, x := range p {
+			h[i] += row[j] * x
+		}
+	}
+	q := make(Point, t.Dim)
+	for i := range q {
+		q[i] = h[i] / h[t.Dim]
+	}
+	return q
+}
+
+// ApplyPoint2D maps `p` to the nearest integer point.
+func (t *Transform) ApplyPoint2D(p Point2D) Point2D {
+	q := t.Apply(p.ToPoint())
+	return Point2D{int32(math.Round(q[0])), int32(math.Round(q[1]))}
+}
+
+func (t *Transform) ApplySegment(s *Segment) *Segment {
+	return &Segment{t.Apply(s.P1), t.Apply(s.P2)}
+}
+
+// ApplyPolygon maps the vertices of `p`. Transforms with a negative
+// determinant reverse its orientation.
+func (t *Transform) ApplyPolygon(p Polygon) Polygon {
+	r := make(Polygon, len(p))
+	for i, v := range p {
+		r[i] = t.Apply(v)
+	}
+	return r
+}
+
+// ApplyRect returns the bounding box of the image of `r`, exact for
+// the transforms keeping the whole rectangle on the same side of
+// the points sent to infinity.
+func (t *Transform) ApplyRect(r *Rect) *Rect {
+	if len(r.C) != t.Dim {
+		panic(DimError{t.Dim, len(r.C)})
+	}
+	lo, hi := NewPoint(t.Dim, math.Inf(1)), NewPoint(t.Dim, math.Inf(-1))
+	corner := make(Point, t.Dim)
+	for mask := 0; mask < 1<<uint(t.Dim); mask++ {
+		for i := range corner {
+			if mask&(1<<uint(i)) != 0 {
+				corner[i] = r.C[i] + r.P[i]
+			} else {
+				corner[i] = r.C[i] - r.P[i]
+			}
+		}
+		q := t.Apply(corner)
+		for i := range q {
+			lo[i], hi[i] = math.Min(lo[i], q[i]), math.Max(hi[i], q[i])
+		}
+	}
+	b := &Rect{C: make(Point, t.Dim), P: make(Point, t.Dim)}
+	for i := range lo {
+		b.C[i], b.P[i] = (lo[i]+hi[i])/2, (hi[i]-lo[i])/2
+	}
+	return b
+}
+
+//#################################################################
+//                   Estimation
+//#################################################################
+
+func checkPairs(src, dst []Point, min int) (int, error) {
+	if len(src) != len(dst) {
+		return 0, DimError{len(src), len(dst)}
+	}
+	if len(src) == 0 {
+		return 0, ErrSingular
+	}
+	dim := len(src[0])
+	for i := range src {
+		if len(src[i]) != dim {
+			return 0, DimError{dim, len(src[i])}
+		}
+		if len(dst[i]) != dim {
+			return 0, DimError{dim, len(dst[i])}
+		}
+	}
+	if len(src) < min+dim {
+		return 0, ErrSingular
+	}
+	return dim, nil
+}
+
+func centroid(points []Point) Point {
+	c := NewPoint(len(points[0]), 0)
+	for _, p := range points {
+		c.Add(p)
+	}
+	return c.DivC(float64(len(points)))
+}
+
+// EstimateAffine returns the affine transform mapping `src` to `dst`
+// with the least squared error, from at least Dim+1 points not on a
+// hyperplane.
+func EstimateAffine(src, dst []Point) (*Transform, error) {
+	d, err := checkPairs(src, dst, 1)
+	if err != nil {
+		return nil, err
+	}
+	// the linear part solves A Spp = Sqp on centered coordinates
+	cp, cq := centroid(src), centroid(dst)
+	spp, sqp := make([]float64, d*d), make([]float64, d*d)
+	for k := range src {
+		for i := 0; i < d; i++ {
+			for j := 0; j < d; j++ {
+				pj := src[k][j] - cp[j]
+				spp[i*d+j] += (src[k][i] - cp[i]) * pj
+				sqp[i*d+j] += (dst[k][i] - cq[i]) * pj
+			}
+		}
+	}
+	inv, err := matInverse(spp, d)
+	if err != nil {
+		return nil, err
+	}
+	a := matMul(sqp, inv, d)
+	t := Identity(d)
+	n := t.n()
+	for i := 0; i < d; i++ {
+		t.M[i*n+d] = cq[i]
+		for j := 0; j < d; j++ {
+			t.M[i*n+j] = a[i*d+j]
+			t.M[i*n+d] -= a[i*d+j] * cp[j]
+		}
+	}
+	return t, nil
+}
+
+// normalizing returns the similarity centering `points` at the origin
+// with a mean distance of sqrt(Dim) to it.
+func normalizing(points []Point) *Transform {
+	c := centroid(points)
+	mean := 0.0
+	for _, p := range points {
+		mean += p.Dist(c)
+	}
+	mean /= float64(len(points))
+	s := 1.0
+	if mean > 0 {
+		s = math.Sqrt(float64(len(c))) / mean
+	}
+	return Translation(c.Clone().MulC(-1)).Then(Scaling(NewPoint(len(c), s)))
+}
+
+// EstimateProjective returns the projective transform, a homography in
+// the plane, mapping `src` to `dst` with the least algebraic error by
+// the normalized direct linear transformation. It needs at least Dim+2
+// points, no Dim+1 of them on a hyperplane.
+func EstimateProjective(src, dst []Point) (*Transform, error) {
+	d, err := checkPairs(src, dst, 2)
+	if err != nil {
+		return nil, err
+	}
+	ns, nd := normalizing(src), normalizing(dst)
+	n := d + 1
+	m := n * n
+	// each coordinate i gives the row h_i . p - q_i h_w . p = 0 of A,
+	// and the solution is the eigenvector of AT A of least eigenvalue
+	ata := make([]float64, m*m)
+	row := make([]float64, m)
+	for k := range src {
+		p, q := ns.Apply(src[k]), nd.Apply(dst[k])
+		p = append(p, 1)
+		for i := 0; i < d; i++ {
+			for j := range row {
+				row[j] = 0
+			}
+			for j := 0; j < n; j++ {
+				row[i*n+j] = p[j]
+				row[d*n+j] = -q[i] * p[j]
+			}
+			for a := 0; a < m; a++ {
+				for b := 0; b < m; b++ {
+					ata[a*m+b] += row[a] * row[b]
+				}
+			}
+		}
+	}
+	vals, vecs := symEigen(ata, m)
+	idx := make([]int, m)
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool { return vals[idx[i]] < vals[idx[j]] })
+	// a second null direction leaves the transform undetermined
+	least := idx[0]
+	if vals[idx[1]] <= 1e-12*vals[idx[m-1]] {
+		return nil, ErrSingular
+	}
+	h := &Transform{Dim: d, M: make([]float64, m)}
+	for i := range h.M {
+		h.M[i] = vecs[i*m+least]
+	}
+	inv, err := nd.Inverse()
+	if err != nil {
+		return nil, err
+	}
+	t := inv.Mul(h).Mul(ns)
+	if w := t.M[m-1]; w != 0 {
+		for i := range t.M {
+			t.M[i] /= w
+		}
+	}
+	return t, nil
+}
+
+//#################################################################
+//                   Matrices
+//#################################################################
+
+func identity(n int) []float64 {
+	m := make([]float64, n*n)
+	for i := 0; i < n; i++ {
+		m[i*n+i] = 1
+	}
+	return m
+}
+
+// matMul multiplies the n x n matrices `a` and `b`.
+func matMul(a, b []float64, n int) []float64 {
+	c := make([]float64, n*n)
+	for i := 0; i < n; i++ {
+		for k := 0; k < n; k++ {
+			if a[i*n+k] == 0 {
+				continue
+			}
+			for j := 0; j < n; j++ {
+				c[i*n+j] += a[i*n+k] * b[k*n+j]
+			}
+		}
+	}
+	return c
+}
+
+// matInverse inverts the n x n matrix `m` by Gauss-Jordan elimination
+// with partial pivoting.
+func matInverse(m []float64, n int) ([]float64, error) {
+	a, inv := append([]float64(nil), m...), identity(n)
+	scale := 0.0
+	for _, x := range a {
+		scale = math.Max(scale, math.Abs(x))
+	}
+	for c := 0; c < n; c++ {
+		p := c
+		for r := c + 1; r < n; r++ {
+			if math.Abs(a[r*n+c]) > math.Abs(a[p*n+c]) {
+				p = r
+			}
+		}
+		if math.Abs(a[p*n+c]) <= 1e-12*scale {
+			return nil, ErrSingular
+		}
+		for j := 0; j < n; j++ {
+			a[c*n+j], a[p*n+j] = a[p*n+j], a[c*n+j]
+			inv[c*n+j], inv[p*n+j] = inv[p*n+j], inv[c*n+j]
+		}
+		f := 1 / a[c*n+c]
+		for j := 0; j < n; j++ {
+			a[c*n+j] *= f
+			inv[c*n+j] *= f
+		}
+		for r := 0; r < n; r++ {
+			if f := a[r*n+c]; r != c && f != 0 {
+				for j := 0; j < n; j++ {
+					a[r*n+j] -= f * a[c*n+j]
+					inv[r*n+j] -= f * inv[c*n+j]
+				}
+			}
+		}
+	}
+	return inv, nil
+}
+
+// symEigen diagonalizes the symmetric n x n matrix `m` by cyclic Jacobi
+// rotations. It returns the eigenvalues and the eigenvectors as the
+// columns of a matrix.
+func symEigen(m []float64, n int) ([]float64, []float64) {
+	a, v := append([]float64(nil), m...), identity(n)
+	norm := 0.0
+	for _, x := range a {
+		norm += x * x
+	}
+	for sweep := 0; sweep < 64; sweep++ {
+		off := 0.0
+		for p := 0; p < n; p++ {
+			for q := p + 1; q < n; q++ {
+				off += a[p*n+q] * a[p*n+q]
+			}
+		}
+		if off <= 1e-30*norm {
+			break
+		}
+		for p := 0; p < n; p++ {
+			for q := p + 1; q < n; q++ {
+				apq := a[p*n+q]
+				if apq == 0 {
+					continue
+				}
+				// the rotation angle that zeroes a[p][q]
+				theta := (a[q*n+q] - a[p*n+p]) / (2 * apq)
+				t := 1 / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				if theta < 0 {
+					t = -t
+				}
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+				for k := 0; k < n; k++ {
+					x, y := a[k*n+p], a[k*n+q]
+					a[k*n+p], a[k*n+q] = c*x-s*y, s*x+c*y
+				}
+				for k := 0; k < n; k++ {
+					x, y := a[p*n+k], a[q*n+k]
+					a[p*n+k], a[q*n+k] = c*x-s*y, s*x+c*y
+				}
+				for k := 0; k < n; k++ {
+					x, y := v[k*n+p], v[k*n+q]
+					v[k*n+p], v[k*n+q] = c*x-s*y, s*x+c*y
+				}
+			}
+		}
+	}
+	vals := make([]float64, n)
+	for i := range vals {
+		vals[i] = a[i*n+i]
+	}
+	return vals, v
+}
@@ -0,0 +1,111 @@
+package gem
+
+import (
+	"math"
+	"testing"
+)
+
+func nearPoint(p, q Point) bool {
+	for i := range p {
+		if math.Abs(p[i]-q[i]) > 1e-6 {
+			return false
+		}
+	}
+	return len(p) == len(q)
+}
+
+func TestTransformCompose(t *testing.T) {
+	rot := Rotation(math.Pi / 2)
+	if p := rot.Apply(Point{1, 0}); !nearPoint(p, Point{0, 1}) {
+		t.Errorf("rotated to %v", p)
+	}
+	// translate then rotate
+	tr := Translation(Point{1, 2}).Then(rot)
+	if p := tr.Apply(Point{1, 0}); !nearPoint(p, Point{-2, 2}) || !tr.IsAffine() {
+		t.Errorf("composed to %v", p)
+	}
+	inv, err := tr.Inverse()
+	if err != nil || !nearPoint(inv.Apply(Point{-2, 2}), Point{1, 0}) {
+		t.Errorf("inverse %v %v", inv, err)
+	}
+	if _, err := Scaling(Point{1, 0}).Inverse(); err != ErrSingular {
+		t.Errorf("inverted a singular scaling: %v", err)
+	}
+	r3 := Rotation3D(Point{0, 0, 2}, math.Pi/2)
+	if p := r3.Apply(Point{1, 0, 5}); !nearPoint(p, Point{0, 1, 5}) {
+		t.Errorf("rotated about z to %v", p)
+	}
+	if p := Rotation3D(Point{1, 1, 1}, 2*math.Pi/3).Apply(Point{1, 0, 0}); !nearPoint(p, Point{0, 1, 0}) {
+		t.Errorf("rotated about the diagonal to %v", p)
+	}
+}
+
+func TestTransformShapes(t *testing.T) {
+	rot := Rotation(math.Pi / 4)
+	b := rot.ApplyRect(&Rect{Point{0, 0}, Point{1, 1}})
+	if !nearPoint(b.C, Point{0, 0}) || !nearPoint(b.P, Point{math.Sqrt2, math.Sqrt2}) {
+		t.Errorf("bounding box %v", b)
+	}
+	mirror := Scaling(Point{-2, 1})
+	p := mirror.ApplyPolygon(square(0, 0, 1))
+	if !near(p.SignedArea(), -2) {
+		t.Errorf("mirrored area %v", p.SignedArea())
+	}
+	s := Translation(Point{1, 1}).ApplySegment(&Segment{Point{0, 0}, Point{1, 0}})
+	if !nearPoint(s.P1, Point{1, 1}) || !nearPoint(s.P2, Point{2, 1}) {
+		t.Errorf("segment %v", s)
+	}
+	if q := rot.ApplyPoint2D(Point2D{10, 0}); q[0] != 7 || q[1] != 7 {
+		t.Errorf("integer point %v", q)
+	}
+}
+
+func TestEstimateTransforms(t *testing.T) {
+	src := []Point{{0, 0}, {4, 0}, {4, 3}, {0, 3}, {1, 2}, {3, 1}}
+	mapAll := func(t *Transform, ps []Point) []Point {
+		r := make([]Point, len(ps))
+		for i, p := range ps {
+			r[i] = t.Apply(p)
+		}
+		return r
+	}
+	affine := NewTransform(2, []float64{2, 0.5, 3, -1, 1.5, -4, 0, 0, 1})
+	homography := NewTransform(2, []float64{1, 0.2, 5, 0.1, 0.9, -2, 0.01, 0.02, 1})
+	tests := []struct {
+		name     string
+		estimate func(src, dst []Point) (*Transform, error)
+		t        *Transform
+		src      []Point
+	}{
+		{"affine", EstimateAffine, affine, src[:3]},
+		{"affine overdetermined", EstimateAffine, affine, src},
+		{"homography", EstimateProjective, homography, src[:4]},
+		{"homography overdetermined", EstimateProjective, homography, src},
+		{"homography of affine", EstimateProjective, affine, src},
+		{"3d", EstimateProjective, NewTransform(3, []float64{1, 0, 0.1, 2, 0, 1, 0, -1, 0.2, 0, 1, 0, 0.01, 0, 0.02, 1}),
+			[]Point{{0, 0, 0}, {1, 0, 0}, {0, 1, 0}, {0, 0, 1}, {1, 1, 1}, {2, 1, 3}}},
+	}
+	for _, tt := range tests {
+		got, err := tt.estimate(tt.src, mapAll(tt.t, tt.src))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		for i := range got.M {
+			if math.Abs(got.M[i]-tt.t.M[i]) > 1e-6 {
+				t.Errorf("%s: estimated %v", tt.name, got.M)
+				break
+			}
+		}
+	}
+	line := []Point{{0, 0}, {1, 1}, {2, 2}, {3, 3}, {4, 4}}
+	if _, err := EstimateAffine(line, line); err != ErrSingular {
+		t.Errorf("affine from collinear points: %v", err)
+	}
+	if _, err := EstimateProjective(line, line); err != ErrSingular {
+		t.Errorf("homography from collinear points: %v", err)
+	}
+	if _, err := EstimateProjective(src[:3], src[:3]); err != ErrSingular {
+		t.Errorf("homography from three points: %v", err)
+	}
+}
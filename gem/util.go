@@ -47,6 +47,8 @@ func IntMax(a, b int) int {
 *         -2 if lines are parallel and overlapping (x, y center)
 *          0 if intesrection outside segments (x,y set)
 *         +1 if segments intersect (x,y set)
+*
+* Segment.Intersection decides exactly with orientation predicates.
  */
 func IntersectLines(x0, y0, x1, y1, x2, y2, x3, y3 float64) (p Point, s int) {
 
@@ -113,36 +115,36 @@ func IntersectLines(x0, y0, x1, y1, x2, y2, x3, y3 float64) (p Point, s int) {
 	if math.Abs(x0-x1) < LIMIT {
 		if y0 < y1 {
 			if y < y0 {
-				distanceFrom1 = math.Sqrt(math.Pow(x-x0, 2) - math.Pow(y-y0, 2))
+				distanceFrom1 = math.Hypot(x-x0, y-y0)
 			} else {
 				if y > y1 {
-					distanceFrom1 = math.Sqrt(math.Pow(x-x1, 2) - math.Pow(y-y1, 2))
+					distanceFrom1 = math.Hypot(x-x1, y-y1)
 				}
 			}
 		} else {
 			if y < y1 {
-				distanceFrom1 = math.Sqrt(math.Pow(x-x1, 2) - math.Pow(y-y1, 2))
+				distanceFrom1 = math.Hypot(x-x1, y-y1)
 			} else {
 				if y > y0 {
-					distanceFrom1 = math.Sqrt(math.Pow(x-x0, 2) - math.Pow(y-y0, 2))
+					distanceFrom1 = math.Hypot(x-x0, y-y0)
 				}
 			}
 		}
 	} else {
 		if x0 < x1 {
 			if x < x0 {
-				distanceFrom1 = math.Sqrt(math.Pow(x-x0, 2) - math.Pow(y-y0, 2))
+				distanceFrom1 = math.Hypot(x-x0, y-y0)
 			} else {
 				if x > x1 {
-					distanceFrom1 = math.Sqrt(math.Pow(x-x1, 2) - math.Pow(y-y1, 2))
+					distanceFrom1 = math.Hypot(x-x1, y-y1)
 				}
 			}
 		} else {
 			if x < x1 {
-				distanceFrom1 = math.Sqrt(math.Pow(x-x1, 2) - math.Pow(y-y1, 2))
+				distanceFrom1 = math.Hypot(x-x1, y-y1)
 			} else {
 				if x > x0 {
-					distanceFrom1 = math.Sqrt(math.Pow(x-x0, 2) - math.Pow(y-y0, 2))
+					distanceFrom1 = math.Hypot(x-x0, y-y0)
 				}
 			}
 		}
@@ -151,36 +153,36 @@ func IntersectLines(x0, y0, x1, y1, x2, y2, x3, y3 float64) (p Point, s int) {
 	if math.Abs(x2-x3) < LIMIT {
 		if y2 < y3 {
 			if y < y2 {
-				distanceFrom2 = math.Sqrt(math.Pow(x-x2, 2) - math.Pow(y-y2, 2))
+				distanceFrom2 = math.Hypot(x-x2, y-y2)
 			} else {
 				if y > y3 {
-					distanceFrom2 = math.Sqrt(math.Pow(x-x3, 2) - math.Pow(y-y3, 2))
+					distanceFrom2 = math.Hypot(x-x3, y-y3)
 				}
 			}
 		} else {
 			if y < y3 {
-				distanceFrom2 = math.Sqrt(math.Pow(x-x3, 2) - math.Pow(y-y3, 2))
+				distanceFrom2 = math.Hypot(x-x3, y-y3)
 			} else {
 				if y > y2 {
-					distanceFrom2 = math.Sqrt(math.Pow(x-x2, 2) - math.Pow(y-y2, 2))
+					distanceFrom2 = math.Hypot(x-x2, y-y2)
 				}
 			}
 		}
 	} else {
 		if x2 < x3 {
 			if x < x2 {
-				distanceFrom2 = math.Sqrt(math.Pow(x-x2, 2) - math.Pow(y-y2, 2))
+				distanceFrom2 = math.Hypot(x-x2, y-y2)
 			} else {
 				if x > x3 {
-					distanceFrom2 = math.Sqrt(math.Pow(x-x3, 2) - math.Pow(y-y3, 2))
+					distanceFrom2 = math.Hypot(x-x3, y-y3)
 				}
 			}
 		} else {
 			if x < x3 {
-				distanceFrom2 = math.Sqrt(math.Pow(x-x3, 2) - math.Pow(y-y3, 2))
+				distanceFrom2 = math.Hypot(x-x3, y-y3)
 			} else {
 				if x > x2 {
-					distanceFrom2 = math.Sqrt(math.Pow(x-x2, 2) - math.Pow(y-y2, 2))
+					distanceFrom2 = math.Hypot(x-x2, y-y2)
 				}
 			}
 		}
@@ -195,17 +197,15 @@ func IntersectLines(x0, y0, x1, y1, x2, y2, x3, y3 float64) (p Point, s int) {
 func IsPointInPoly(x, y []float64, x0, y0 float64) bool {
 	var (
 		isInside bool = false
-		npts, j  int  = len(x), 0
+		j        int  = len(x) - 1 // previous vertex to i
 	)
 	for i := range x {
-		if j == npts {
-			j = 0
-		}
 		if y[i] < y0 && y[j] >= y0 || y[j] < y0 && y[i] >= y0 {
 			if x[i]+((y0-y[i])/(y[j]-y[i]))*(x[j]-x[i]) < x0 {
 				isInside = !isInside
 			}
 		}
+		j = i
 	}
 	return isInside
 }
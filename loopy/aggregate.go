@@ -0,0 +1,150 @@
+package loopy
+
+import "hash/fnv"
+
+//#################################################################
+//                   Mergeable Aggregates
+//#################################################################
+
+// Aggregator is a mergeable aggregation. Create returns an empty
+// accumulator, Add adds an element, Merge combines two accumulators
+// and Result computes the value of an accumulator.
+type Aggregator interface {
+	Create() T
+	Add(acc T, x T) T
+	Merge(acc T, other T) T
+	Result(acc T) T
+}
+
+// AggregateSpec configures an Aggregate processor. Expand computes the
+// elements of an incoming message, nil aggregates the message itself.
+// Key groups the elements. The partial aggregates of an input are
+// written every Every messages (zero only at the end of the stream)
+// and always at the end of the stream.
+type AggregateSpec struct {
+	Aggregator
+	Expand func(T) []T
+	Key    func(T) string
+	Every  int
+}
+
+// Partial is the accumulator of one key sent from the partial to
+// the final phase.
+type Partial struct {
+	Key string
+	Acc T
+}
+
+func (p *Partial) Dispose() {}
+
+type partials []T
+
+func (p partials) Dispose() {}
+
+// AggResult is the current aggregate of one key.
+type AggResult struct {
+	Key   string
+	Value T
+}
+
+func (r *AggResult) Dispose() {}
+
+// partialState is the state of the partial phase.
+type partialState struct {
+	spec AggregateSpec
+	accs map[string]T
+	n    int
+}
+
+func (s *partialState) add(x T) T {
+	elems := []T{x}
+	if s.spec.Expand != nil {
+		elems = s.spec.Expand(x)
+	}
+	for _, e := range elems {
+		k := s.spec.Key(e)
+		acc, ok := s.accs[k]
+		if !ok {
+			acc = s.spec.Create()
+		}
+		s.accs[k] = s.spec.Add(acc, e)
+	}
+	s.n++
+	if s.spec.Every > 0 && s.n >= s.spec.Every {
+		return s.Flush()
+	}
+	return nil
+}
+
+// Flush writes the partial aggregates as one message and starts over.
+func (s *partialState) Flush() T {
+	if len(s.accs) == 0 {
+		return nil
+	}
+	v := make(partials, 0, len(s.accs))
+	for k, acc := range s.accs {
+		v = append(v, &Partial{k, acc})
+	}
+	s.accs, s.n = make(map[string]T), 0
+	return NewMessage(v)
+}
+
+// finalState is the state of the final phase.
+type finalState struct {
+	spec AggregateSpec
+	accs map[string]T
+}
+
+func (s *finalState) merge(x T) T {
+	p := MessageV(x).(*Partial)
+	acc, ok := s.accs[p.Key]
+	if ok {
+		acc = s.spec.Merge(acc, p.Acc)
+	} else {
+		acc = p.Acc
+	}
+	s.accs[p.Key] = acc
+	y := NewMessage(&AggResult{p.Key, s.spec.Result(acc)})
+	InheritHeader(x, []T{y})
+	return y
+}
+
+func aggPartition(x T, i int, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(MessageV(x).(*Partial).Key))
+	return int(h.Sum32() % uint32(n))
+}
+
+func aggUnpack(x T) []T {
+	if x == nil {
+		return nil
+	}
+	v := MessageV(x).(partials)
+	ret := make([]T, len(v))
+	for i, p := range v {
+		ret[i] = NewMessage(p)
+	}
+	return ret
+}
+
+//#################################################################
+//                   Aggregators
+//#################################################################
+
+// Counter counts the elements of every key.
+type Counter struct{}
+
+func (Counter) Create() T              { return 0 }
+func (Counter) Add(acc T, x T) T       { return acc.(int) + 1 }
+func (Counter) Merge(acc T, other T) T { return acc.(int) + other.(int) }
+func (Counter) Result(acc T) T         { return acc }
+
+// Sum adds up the values of the elements of every key.
+type Sum struct {
+	Value func(T) float64
+}
+
+func (s Sum) Create() T              { return 0.0 }
+func (s Sum) Add(acc T, x T) T       { return acc.(float64) + s.Value(x) }
+func (s Sum) Merge(acc T, other T) T { return acc.(float64) + other.(float64) }
+func (s Sum) Result(acc T) T         { return acc }
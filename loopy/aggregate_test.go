@@ -0,0 +1,52 @@
+package loopy
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestAggregate(t *testing.T) {
+	g := NewOGraph()
+	spec := AggregateSpec{Aggregator: Counter{},
+		Expand: func(x T) []T {
+			v := int(MessageV(x).(testValue))
+			return []T{v % 3, v % 5}
+		},
+		Key:   func(x T) string { return fmt.Sprint(x) },
+		Every: 7}
+	counts := make(map[string]int)
+	merged := 0
+	mutex := &sync.Mutex{}
+	collect := func(g *OGraph, i int) (*Processor, *Processor) {
+		r := g.Reduce(nil, Functions{&Function{FuncName: "collect", Reducer: func(u, x T, params Params) (T, T) {
+			res := MessageV(x).(*AggResult)
+			mutex.Lock()
+			counts[res.Key] = res.Value.(int)
+			merged++
+			mutex.Unlock()
+			return u, x
+		}}}, OP_ATTRIB_NAME, fmt.Sprint("collect", i))
+		e := r.Ground()
+		return r.Proc, e.Proc
+	}
+	h := func(g *OGraph, i int) (*Processor, *Processor) {
+		a := g.Source(&testSpout{max: 60})
+		return a.Proc, a.Proc
+	}
+	// every key is merged in one of the 3 partitions
+	g.List(2, h).Aggregate(2, 3, spec).List(3, collect)
+	g.Execute()
+	g.Wait()
+	// per source v%3 gives 20 of each residue, v%5 gives 12 of each
+	want := map[string]int{"0": 64, "1": 64, "2": 64, "3": 24, "4": 24}
+	for k, c := range want {
+		if counts[k] != c {
+			t.Errorf("key %s counted %d, expected %d", k, counts[k], c)
+		}
+	}
+	// 2 inputs x (60/7 periodic + 1 final) flushes of at most 5 keys
+	if merged > 2*9*5 {
+		t.Errorf("%d partials crossed the shuffle", merged)
+	}
+}
@@ -1,6 +1,10 @@
 package loopy
 
-import "time"
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
 //#############################################################
 // 1. Data processing operators
@@ -19,6 +23,7 @@ func (g *OGraph) Source(s Spout, attribs ...T) *aGraph {
 		go func() {
 			defer g.group.Done()
 			defer close(proc.Outputs[0])
+			defer proc.Flush()
 			var x T
 			for {
 				t := time.Now()
@@ -28,7 +33,7 @@ func (g *OGraph) Source(s Spout, attribs ...T) *aGraph {
 				}
 				proc.AddTimeInfo1(PROC_ENTER_TIME, t, x)
 				proc.AddTimeInfo(PROC_LEAVE_TIME, x)
-				proc.Outputs[0] <- proc.OutStack.ExecStack(x)
+				proc.Send(0, proc.OutStack.ExecStack(x))
 				if !proc.Wait() {
 					break
 				}
@@ -53,25 +58,35 @@ func (g *OGraph) Ground(attribs ...T) *aGraph {
 		go func() {
 			defer g.group.Done()
 			ct := time.Now()
-			for x := range proc.Inputs[0] {
+			for {
+				// the input changes when a group is merged into the ground
+				x, ok := <-proc.Inputs[0]
+				if !ok {
+					break
+				}
 				// need to implement a disposing function.
 				comm, state := proc.WaitMessage(x, proc.Outputs...)
 				if !state {
 					break
 				}
 				if x != nil && !comm {
-					x = proc.InStack.ExecStack(x)
-					proc.AddTimeInfo(PROC_ENTER_TIME, x)
-					DeepDispose(x)
-					ut := time.Now()
-					proc.AddTimeInfo1(PROC_LEAVE_TIME, ut, x)
-					dt := ut.Sub(ct).Seconds()
-					if dt >= proc.G.DecayInt && proc.G.Active {
-						AccumulateStats(proc.G.GndBranches[proc.Name], proc.G.Alpha, dt, x)
-						ct = ut
-					} else {
-						AccumulateStats(proc.G.GndBranches[proc.Name], 0, 0, x)
-					}
+					forEach(x, func(x T) {
+						x = proc.InStack.ExecStack(x)
+						proc.AddTimeInfo(PROC_ENTER_TIME, x)
+						DeepDispose(x)
+						ut := time.Now()
+						proc.AddTimeInfo1(PROC_LEAVE_TIME, ut, x)
+						dt := ut.Sub(ct).Seconds()
+						if dt >= proc.G.DecayInt && proc.G.Active {
+							AccumulateStats(proc.G.GndBranches[proc.Name], proc.G.Alpha, dt, x)
+							ct = ut
+						} else {
+							AccumulateStats(proc.G.GndBranches[proc.Name], 0, 0, x)
+						}
+						if proc.G.Recycle {
+							Message(x).Release()
+						}
+					})
 				}
 			}
 		}()
@@ -90,10 +105,15 @@ func (g *OGraph) Map(funcs Functions, attribs ...T) *aGraph {
 	g.Register(proc, proc.ParseAttrib(attribs))
 	proc.F = func(inputs ...chan T) []chan T {
 		g.group.Add(1)
+		proc.running.Add(1)
+		// restarted by mergeBackward or started for the first time
+		proc.IsMerged = false
 		proc.Inputs = inputs
 		go func() {
 			defer g.group.Done()
+			defer proc.running.Done()
 			defer close(proc.Outputs[0])
+			defer proc.Flush()
 			for {
 				x, ok := <-proc.Inputs[0]
 				if !ok {
@@ -104,12 +124,14 @@ func (g *OGraph) Map(funcs Functions, attribs ...T) *aGraph {
 					break
 				}
 				if !comm {
-					x = proc.InStack.ExecStack(x)
-					proc.AddTimeInfo(PROC_ENTER_TIME, x)
-					proc.ProcessorInfo.UpdateSettings(x)
-					y := proc.Funcs[proc.FuncIdx].Mapper(x, proc.Funcs[proc.FuncIdx].FuncParams)
-					proc.AddTimeInfo(PROC_LEAVE_TIME, y)
-					proc.Outputs[0] <- proc.OutStack.ExecStack(y)
+					forEach(x, func(x T) {
+						x = proc.InStack.ExecStack(x)
+						proc.AddTimeInfo(PROC_ENTER_TIME, x)
+						proc.ProcessorInfo.UpdateSettings(x)
+						y := proc.Funcs[proc.FuncIdx].Mapper(x, proc.Funcs[proc.FuncIdx].FuncParams)
+						proc.AddTimeInfo(PROC_LEAVE_TIME, y)
+						proc.Send(0, proc.OutStack.ExecStack(y))
+					})
 				}
 			}
 		}()
@@ -122,24 +144,45 @@ func (g *OGraph) Map(funcs Functions, attribs ...T) *aGraph {
 // It maintains an internal state u which is initialized
 // by `u0`. For each reading `x` from the incoming stream,
 // reduce updates the state `u` using the `g` function and
-// generates an output `y` for the outgoing stream.
+// generates an output `y` for the outgoing stream. When the
+// incoming stream ends and `u` is a Flusher, its final output
+// is written before the outgoing stream is closed.
 func (g *OGraph) Reduce(u0 T, funcs Functions, attribs ...T) *aGraph {
 	proc := g.NewProcessor(nil, make([]chan T, 1), OP_REDUCE)
 	proc.Funcs, proc.FuncIdx = funcs, 0
 	g.Register(proc, proc.ParseAttrib(attribs))
 	proc.F = func(inputs ...chan T) []chan T {
 		g.group.Add(1)
+		proc.running.Add(1)
 		u := u0
-		proc.Funcs[proc.FuncIdx].State = u0
+		if proc.IsMerged {
+			// restarted by mergeBackward, resume from the merged state
+			u = proc.Funcs[proc.FuncIdx].State
+			proc.IsMerged = false
+		}
+		proc.Funcs[proc.FuncIdx].State = u
 		proc.Inputs = inputs
 		go func() {
 			defer g.group.Done()
+			defer proc.running.Done()
 			defer close(proc.Outputs[0])
-			defer DeepDispose(u) //(u.(Disposable)).Dispose()
+			defer proc.Flush()
+			defer func() {
+				// a merged reducer hands its state to the group head
+				if !proc.IsMerged {
+					DeepDispose(u) //(u.(Disposable)).Dispose()
+				}
+			}()
 			var y T
 			for {
 				x, ok := <-proc.Inputs[0]
 				if !ok {
+					if f, ok := u.(Flusher); ok && !proc.IsMerged {
+						if y = f.Flush(); y != nil {
+							proc.AddTimeInfo(PROC_LEAVE_TIME, y)
+							proc.Send(0, proc.OutStack.ExecStack(y))
+						}
+					}
 					break
 				}
 				comm, state := proc.WaitMessage(x, proc.Outputs...)
@@ -148,15 +191,17 @@ func (g *OGraph) Reduce(u0 T, funcs Functions, attribs ...T) *aGraph {
 				}
 				if !comm {
 					if x != nil {
-						x = proc.InStack.ExecStack(x)
-						proc.AddTimeInfo(PROC_ENTER_TIME, x)
-						proc.ProcessorInfo.UpdateSettings(x)
-						u, y = proc.Funcs[proc.FuncIdx].Reducer(u, x, proc.Funcs[proc.FuncIdx].FuncParams)
-						proc.Funcs[proc.FuncIdx].State = u
-						proc.AddTimeInfo(PROC_LEAVE_TIME, y)
-						proc.Outputs[0] <- proc.OutStack.ExecStack(y)
+						forEach(x, func(x T) {
+							x = proc.InStack.ExecStack(x)
+							proc.AddTimeInfo(PROC_ENTER_TIME, x)
+							proc.ProcessorInfo.UpdateSettings(x)
+							u, y = proc.Funcs[proc.FuncIdx].Reducer(u, x, proc.Funcs[proc.FuncIdx].FuncParams)
+							proc.Funcs[proc.FuncIdx].State = u
+							proc.AddTimeInfo(PROC_LEAVE_TIME, y)
+							proc.Send(0, proc.OutStack.ExecStack(y))
+						})
 					} else {
-						proc.Outputs[0] <- x
+						proc.Send(0, x)
 					}
 				}
 			}
@@ -182,22 +227,30 @@ func (g *OGraph) Filter(funcs Functions, attribs ...T) *aGraph {
 			defer g.group.Done()
 			defer close(proc.Outputs[0])
 			defer close(proc.Outputs[1])
-			for x := range proc.Inputs[0] {
+			defer proc.Flush()
+			for {
+				x, ok := <-proc.Inputs[0]
+				if !ok {
+					break
+				}
 				comm, state := proc.WaitMessage(x, proc.Outputs...)
 				if !state {
 					break
 				}
 				if !comm {
-					x = proc.InStack.ExecStack(x)
-					proc.AddTimeInfo(PROC_ENTER_TIME, x)
-					proc.ProcessorInfo.UpdateSettings(x)
-					dec := proc.Funcs[proc.FuncIdx].Mapper(x, proc.Funcs[proc.FuncIdx].FuncParams).(bool)
-					proc.AddTimeInfo(PROC_LEAVE_TIME, x)
-					if dec {
-						proc.Outputs[0] <- x
-					} else {
-						proc.Outputs[1] <- x
-					}
+					forEach(x, func(x T) {
+						x = proc.InStack.ExecStack(x)
+						proc.AddTimeInfo(PROC_ENTER_TIME, x)
+						proc.ProcessorInfo.UpdateSettings(x)
+						dec := proc.Funcs[proc.FuncIdx].Mapper(x, proc.Funcs[proc.FuncIdx].FuncParams).(bool)
+						proc.AddTimeInfo(PROC_LEAVE_TIME, x)
+						if dec {
+							proc.Send(0, x)
+						} else {
+							proc.Metrics.filtered()
+							proc.Send(1, x)
+						}
+					})
 				}
 			}
 		}()
@@ -227,7 +280,11 @@ func (g *OGraph) Copy(n int, attribs ...T) *aGraph {
 					close(proc.Outputs[i])
 				}
 			}()
-			for x := range proc.Inputs[0] {
+			for {
+				x, ok := <-proc.Inputs[0]
+				if !ok {
+					break
+				}
 				comm, state := proc.WaitMessage(x, proc.Outputs...)
 				if !state {
 					break
@@ -239,7 +296,7 @@ func (g *OGraph) Copy(n int, attribs ...T) *aGraph {
 						for i := 1; i < n; i++ {
 							y := DeepClone(x)
 							proc.AddTimeInfo(PROC_LEAVE_TIME, y)
-							proc.Outputs[i] <- x
+							proc.Outputs[i] <- y
 						}
 						proc.AddTimeInfo(PROC_LEAVE_TIME, x)
 						proc.Outputs[0] <- x
@@ -271,6 +328,7 @@ func (g *OGraph) Split(n int, attribs ...T) *aGraph {
 		go func() {
 			defer g.group.Done()
 			defer closeall()
+			defer proc.Flush()
 			k := 0
 			for x := range proc.Inputs[0] {
 				comm, state := proc.WaitMessage(x, proc.Outputs...)
@@ -278,9 +336,11 @@ func (g *OGraph) Split(n int, attribs ...T) *aGraph {
 					break
 				}
 				if !comm {
-					proc.AddTimeInfo(PROC_BOTH_TIME, x)
-					proc.Outputs[k] <- x
-					k = (k + 1) % len(proc.Outputs)
+					forEach(x, func(x T) {
+						proc.AddTimeInfo(PROC_BOTH_TIME, x)
+						proc.Send(k, x)
+						k = (k + 1) % len(proc.Outputs)
+					})
 				}
 			}
 		}()
@@ -300,8 +360,8 @@ func (g *OGraph) Latch(attribs ...T) *aGraph {
 	proc.F = func(inputs ...chan T) []chan T {
 		var (
 			u       T
-			proceed bool = true
-			clone   bool = true
+			proceed bool        = true
+			mutex   *sync.Mutex = &sync.Mutex{} // guards u and proceed
 		)
 		proc.Inputs = inputs
 		g.group.Add(1)
@@ -317,31 +377,39 @@ func (g *OGraph) Latch(attribs ...T) *aGraph {
 				if !comm {
 					x = proc.InStack.ExecStack(x)
 					proc.AddTimeInfo(PROC_ENTER_TIME, x)
-					if x != nil && clone {
+					if x != nil {
+						mutex.Lock()
 						if u != nil {
 							DeepDispose(u)
 						}
 						u = DeepClone(x)
+						mutex.Unlock()
 					}
 					proc.AddTimeInfo(PROC_LEAVE_TIME, x)
 					proc.Outputs[1] <- x
 				}
 			}
+			mutex.Lock()
 			proceed = false
+			mutex.Unlock()
 		}()
 		g.group.Add(1)
 		go func() {
 			defer g.group.Done()
 			defer close(proc.Outputs[0])
-			var y T
-			for proceed {
-				y = u
-				clone = false
-				proc.AddTimeInfo(PROC_ENTER_TIME, y)
-				if y != nil {
+			for {
+				var y T
+				mutex.Lock()
+				if !proceed {
+					mutex.Unlock()
+					break
+				}
+				if u != nil {
+					// the time info goes to the copy, u is shared
 					y = DeepClone(u) //(u.(Cloneable)).Clone()
 				}
-				clone = true
+				mutex.Unlock()
+				proc.AddTimeInfo(PROC_ENTER_TIME, y)
 				proc.AddTimeInfo(PROC_LEAVE_TIME, y)
 				proc.Outputs[0] <- y
 			}
@@ -365,8 +433,8 @@ func (g *OGraph) Cut(attribs ...T) *aGraph {
 		g.group.Add(1)
 		var (
 			u       T
-			proceed bool = true
-			clone   bool = true
+			proceed bool        = true
+			mutex   *sync.Mutex = &sync.Mutex{} // guards u and proceed
 		)
 		proc.Inputs = inputs
 		go func() {
@@ -380,29 +448,37 @@ func (g *OGraph) Cut(attribs ...T) *aGraph {
 				if !comm {
 					x = proc.InStack.ExecStack(x)
 					proc.AddTimeInfo(PROC_ENTER_TIME, x)
-					if x != nil && u == nil && clone {
-						u = DeepClone(x)
-						//u = (x.(Cloneable)).Clone()
+					if x != nil {
+						mutex.Lock()
+						if u == nil {
+							u = DeepClone(x)
+							//u = (x.(Cloneable)).Clone()
+						}
+						mutex.Unlock()
 					}
 					proc.AddTimeInfo(PROC_LEAVE_TIME, x)
 					proc.Outputs[1] <- x
 				}
 			}
+			mutex.Lock()
 			proceed = false
+			mutex.Unlock()
 		}()
 		g.group.Add(1)
 		go func() {
 			defer g.group.Done()
 			defer close(proc.Outputs[0])
-			for proceed {
+			for {
+				mutex.Lock()
+				if !proceed {
+					mutex.Unlock()
+					break
+				}
+				// take the reading, it is written once
 				y := u
+				u = nil
+				mutex.Unlock()
 				proc.AddTimeInfo(PROC_ENTER_TIME, y)
-				clone = false
-				if y != nil {
-					y = u
-					u = nil
-				}
-				clone = true
 				proc.AddTimeInfo(PROC_LEAVE_TIME, y)
 				proc.Outputs[0] <- y
 			}
@@ -457,6 +533,9 @@ func (g *OGraph) LeftMultiply(attribs ...T) *aGraph {
 
 				}
 			}
+			// release the latch until the right stream ends
+			for range clatch {
+			}
 		}()
 		return proc.Outputs
 	}
@@ -524,19 +603,22 @@ func (g *OGraph) Add(attribs ...T) *aGraph {
 	proc := g.NewProcessor(nil, make([]chan T, 1), OP_ADD)
 	g.Register(proc, proc.ParseAttrib(attribs))
 	proc.F = func(inputs ...chan T) []chan T {
-		k := len(inputs)
+		// the last reader to finish closes the output
+		k := int32(len(inputs))
 		proc.Inputs = inputs
 		for i, cin := range inputs {
 			g.group.Add(1)
 			go func(i int, cin chan T) {
 				defer g.group.Done()
 				for x := range cin {
-					proc.AddTimeInfo(PROC_ENTER_TIME, x)
-					proc.AddTimeInfo(PROC_LEAVE_TIME, x)
-					proc.Outputs[0] <- proc.OutStack.ExecStack(x)
+					forEach(x, func(x T) {
+						proc.AddTimeInfo(PROC_ENTER_TIME, x)
+						proc.AddTimeInfo(PROC_LEAVE_TIME, x)
+						proc.Send(0, proc.OutStack.ExecStack(x))
+					})
 				}
-				k--
-				if k == 0 {
+				if atomic.AddInt32(&k, -1) == 0 {
+					proc.Flush()
 					close(proc.Outputs[0])
 				}
 			}(i, cin)
@@ -584,6 +666,7 @@ func (g *OGraph) Scatter(n int, f func(T) []T, p func(T, int, int) int, attribs
 					proc.AddTimeInfo(PROC_ENTER_TIME, x)
 					v := f(x)
 					if v != nil {
+						InheritHeader(x, v)
 						proc.AddTimeInfo1(PROC_LEAVE_TIME, time.Now(), v...)
 						for i, y := range v {
 							idx := p(y, i, n)
@@ -615,22 +698,22 @@ func (g *OGraph) Merge(p func([]T) (int, T), attribs ...T) *aGraph {
 	proc := g.NewProcessor(nil, make([]chan T, 1), OP_MERGE)
 	g.Register(proc, proc.ParseAttrib(attribs))
 	proc.F = func(inputs ...chan T) []chan T {
-		k := len(inputs)
+		k := int32(len(inputs))
 		proc.Inputs = inputs
-		buf, ok := make([]T, k), make([]bool, k)
-		for i := 0; i < k; i++ {
+		buf, ok := make([]T, len(inputs)), make([]bool, len(inputs))
+		for i := range ok {
 			ok[i] = true
 		}
 		g.group.Add(1)
 		go func() {
 			defer close(proc.Outputs[0])
 			defer g.group.Done()
-			for k > 0 {
+			for atomic.LoadInt32(&k) > 0 {
 				for i := 0; i < len(proc.Inputs); i++ {
 					if buf[i] == nil && ok[i] {
 						buf[i], ok[i] = <-proc.Inputs[i]
 						if !ok[i] {
-							k--
+							atomic.AddInt32(&k, -1)
 						} else {
 							proc.AddTimeInfo(PROC_ENTER_TIME, buf[i])
 						}
@@ -701,3 +784,428 @@ func (g *OGraph) Group(n_inputs, n_outputs int, f func(T) []T, p func(T, int, in
 
 	return &aGraph{g, addComp}
 }
+
+// Aggregate processor:
+// It is a two-phase aggregation around a Group. Every one of the
+// `n_inputs` incoming streams is pre-aggregated by key into partial
+// accumulators, which a Group shuffles by key to `n_outputs`
+// partitions, where they are merged. For every merged partial the
+// operator writes the current *AggResult of the key, so only the
+// partials, not the elements, cross the shuffle.
+func (g *OGraph) Aggregate(n_inputs, n_outputs int, spec AggregateSpec, attribs ...T) *aGraph {
+	var pproc *Processor = nil
+	for i := 0; i < len(attribs); i += 2 {
+		if attribs[i].(int) == OP_ATTRIB_PREV_PROC {
+			pproc = attribs[i+1].(*Processor)
+		}
+	}
+	// every replica has its own functions, which hold their state
+	h1 := func(g *OGraph, i int) (*Processor, *Processor) {
+		partial := &Function{FuncName: "partial", Reducer: func(u, x T, params Params) (T, T) {
+			s := u.(*partialState)
+			y := s.add(x)
+			InheritHeader(x, []T{y})
+			return s, y
+		}}
+		p := g.Reduce(&partialState{spec, make(map[string]T), 0}, Functions{partial}).Proc
+		return p, p
+	}
+	h2 := func(g *OGraph, i int) (*Processor, *Processor) {
+		final := &Function{FuncName: "final", Reducer: func(u, x T, params Params) (T, T) {
+			s := u.(*finalState)
+			return s, s.merge(x)
+		}}
+		p := g.Reduce(&finalState{spec, make(map[string]T)}, Functions{final}).Proc
+		return p, p
+	}
+	partComp := g.List(n_inputs, h1)
+	if pproc != nil {
+		g.LinkOut(pproc.Name, partComp.Proc.Name)
+	}
+	return partComp.Group(n_inputs, n_outputs, aggUnpack, aggPartition).List(n_outputs, h2)
+}
+
+// Parallel processor:
+// It runs `n` replicas of a stateless sub-pipeline between an
+// internal Split and Add. Every replica applies the Map stages
+// in `stages` in order. While data is flowing, a controller adds
+// or removes replicas within [min, max] based on the queue depth
+// in front of the replicas and the period of the processor branch.
+// Like Split followed by Add, the outgoing order is not preserved.
+func (g *OGraph) Parallel(n, min, max int, stages []Functions, attribs ...T) *aGraph {
+	proc := g.NewProcessor(nil, make([]chan T, 1), OP_PARALLEL)
+	proc.Replicas = NewReplicas(g, n, min, max, stages)
+	g.Register(proc, proc.ParseAttrib(attribs))
+	proc.F = func(inputs ...chan T) []chan T {
+		r := proc.Replicas
+		proc.Inputs = inputs
+		r.Start(proc)
+		g.group.Add(1)
+		go func() {
+			defer g.group.Done()
+			defer r.Close()
+			for {
+				x, ok := <-proc.Inputs[0]
+				if !ok {
+					break
+				}
+				comm, state := proc.WaitMessage(x, proc.Outputs...)
+				if !state {
+					break
+				}
+				if !comm {
+					x = proc.InStack.ExecStack(x)
+					proc.AddTimeInfo(PROC_ENTER_TIME, x)
+					r.work <- x
+				}
+			}
+		}()
+		return proc.Outputs
+	}
+	return &aGraph{g, proc}
+}
+
+// Join processor:
+// It reads a left stream from inputs[0] and a right stream from
+// inputs[1] and writes pairs (x; y) of elements with equal keys
+// whose times lie within `spec.Bound` of each other. Each side is
+// buffered for `spec.Bound`. With arrival times the buffers are
+// also cleaned up periodically, with event times they are cleaned
+// up as the watermark advances. In the outer modes an element
+// that leaves the buffer unmatched is written as (x; nil) or
+// (nil; y). An optional first attribute func([]T) T maps the
+// pairs before they are written.
+func (g *OGraph) Join(spec JoinSpec, attribs ...T) *aGraph {
+	proc := g.NewProcessor(nil, make([]chan T, 1), OP_JOIN)
+	var f func([]T) T = nil
+	if len(attribs) > 0 {
+		switch t := attribs[0].(type) {
+		case func([]T) T:
+			f = t
+			attribs = attribs[1:]
+		}
+	}
+	g.Register(proc, proc.ParseAttrib(attribs))
+	proc.F = func(inputs ...chan T) []chan T {
+		proc.Inputs = inputs
+		g.group.Add(1)
+		go func() {
+			defer g.group.Done()
+			defer close(proc.Outputs[0])
+			s := newJoinState(spec, func(v []T) {
+				proc.AddTimeInfo1(PROC_LEAVE_TIME, time.Now(), v...)
+				if f == nil {
+					proc.Outputs[0] <- proc.OutStack.ExecStack(v)
+				} else {
+					proc.Outputs[0] <- proc.OutStack.ExecStack(f(v))
+				}
+			})
+			period := spec.Bound
+			if period < time.Millisecond {
+				period = time.Millisecond
+			}
+			tick := time.NewTicker(period)
+			defer tick.Stop()
+			in := []chan T{proc.Inputs[0], proc.Inputs[1]}
+			for in[0] != nil || in[1] != nil {
+				var (
+					x    T
+					ok   bool
+					side int
+				)
+				select {
+				case x, ok = <-in[0]:
+					side = 0
+				case x, ok = <-in[1]:
+					side = 1
+				case <-tick.C:
+					if spec.Time == nil {
+						s.expire(time.Now())
+					}
+					continue
+				}
+				if !ok {
+					in[side] = nil
+					continue
+				}
+				comm, state := proc.WaitMessage(x, proc.Outputs...)
+				if !state {
+					break
+				}
+				if !comm && x != nil {
+					proc.AddTimeInfo(PROC_ENTER_TIME, x)
+					s.add(side, x)
+				}
+			}
+			s.flush()
+		}()
+		return proc.Outputs
+	}
+	return &aGraph{g, proc}
+}
+
+// LookupJoin processor:
+// It enriches the main stream read from inputs[0] with the rows of
+// a keyed table and writes pairs (x; row) to the outgoing channel.
+// The table is updated by the optional side stream read from
+// inputs[1] and by the periodic `spec.Loader`. Side rows and main
+// messages are not ordered with respect to each other. Messages
+// whose key is missing are handled by `spec.Miss`. An optional
+// first attribute func([]T) T maps the pairs before they are
+// written.
+func (g *OGraph) LookupJoin(spec LookupSpec, attribs ...T) *aGraph {
+	proc := g.NewProcessor(nil, make([]chan T, 1), OP_LOOKUP_JOIN)
+	var f func([]T) T = nil
+	if len(attribs) > 0 {
+		switch t := attribs[0].(type) {
+		case func([]T) T:
+			f = t
+			attribs = attribs[1:]
+		}
+	}
+	if spec.Table == nil {
+		spec.Table = NewLookupTable()
+	}
+	g.Register(proc, proc.ParseAttrib(attribs))
+	proc.F = func(inputs ...chan T) []chan T {
+		table := spec.Table
+		quit := make(chan bool)
+		proc.Inputs = inputs
+		load := func() {
+			if rows, err := spec.Loader(); err == nil {
+				table.Replace(rows)
+			}
+		}
+		if spec.Loader != nil {
+			load()
+			if spec.Refresh > 0 {
+				g.group.Add(1)
+				go func() {
+					defer g.group.Done()
+					tick := time.NewTicker(spec.Refresh)
+					defer tick.Stop()
+					for {
+						select {
+						case <-tick.C:
+							load()
+						case <-quit:
+							return
+						}
+					}
+				}()
+			}
+		}
+		if len(inputs) > 1 {
+			g.group.Add(1)
+			go func() {
+				defer g.group.Done()
+				for y := range inputs[1] {
+					if _, ok := y.(*cM); ok || y == nil {
+						continue
+					}
+					proc.AddTimeInfo(PROC_BOTH_TIME, y)
+					table.Set(spec.RowKey(y), y)
+				}
+			}()
+		}
+		g.group.Add(1)
+		go func() {
+			defer g.group.Done()
+			defer close(proc.Outputs[0])
+			defer close(quit)
+			for x := range inputs[0] {
+				comm, state := proc.WaitMessage(x, proc.Outputs...)
+				if !state {
+					break
+				}
+				if comm || x == nil {
+					continue
+				}
+				x = proc.InStack.ExecStack(x)
+				proc.AddTimeInfo(PROC_ENTER_TIME, x)
+				row, ok := table.Get(spec.Key(x))
+				if !ok {
+					switch spec.Miss {
+					case MISS_DROP:
+						proc.Metrics.filtered()
+						DeepDispose(x)
+						continue
+					case MISS_DEFAULT:
+						row = DeepClone(spec.Default)
+					}
+				}
+				y := []T{x, row}
+				proc.AddTimeInfo(PROC_LEAVE_TIME, x)
+				if f == nil {
+					proc.Outputs[0] <- proc.OutStack.ExecStack(y)
+				} else {
+					proc.Outputs[0] <- proc.OutStack.ExecStack(f(y))
+				}
+			}
+		}()
+		return proc.Outputs
+	}
+	return &aGraph{g, proc}
+}
+
+// Dedup processor:
+// It drops the messages whose key, computed by `key`, was seen
+// within `ttl`. The seen keys are kept in a *DedupState reducer
+// state, which an optional first attribute can provide, for
+// instance to bound the number of keys or to restore a checkpoint.
+func (g *OGraph) Dedup(key func(T) string, ttl time.Duration, attribs ...T) *aGraph {
+	proc := g.NewProcessor(nil, make([]chan T, 1), OP_DEDUP)
+	s := NewDedupState(ttl, 0)
+	if len(attribs) > 0 {
+		switch t := attribs[0].(type) {
+		case *DedupState:
+			s = t
+			attribs = attribs[1:]
+		}
+	}
+	proc.Funcs = Functions{&Function{FuncName: "dedup", State: s}}
+	proc.FuncIdx = 0
+	g.Register(proc, proc.ParseAttrib(attribs))
+	proc.F = func(inputs ...chan T) []chan T {
+		proc.Inputs = inputs
+		g.group.Add(1)
+		go func() {
+			defer g.group.Done()
+			defer close(proc.Outputs[0])
+			for x := range proc.Inputs[0] {
+				comm, state := proc.WaitMessage(x, proc.Outputs...)
+				if !state {
+					break
+				}
+				if !comm {
+					x = proc.InStack.ExecStack(x)
+					proc.AddTimeInfo(PROC_ENTER_TIME, x)
+					s := proc.Funcs[proc.FuncIdx].State.(*DedupState)
+					if x != nil && s.Seen(key(x), time.Now()) {
+						proc.Metrics.filtered()
+						DeepDispose(x)
+						continue
+					}
+					proc.AddTimeInfo(PROC_LEAVE_TIME, x)
+					proc.Outputs[0] <- proc.OutStack.ExecStack(x)
+				}
+			}
+		}()
+		return proc.Outputs
+	}
+	return &aGraph{g, proc}
+}
+
+// SpatialJoin processor:
+// It matches the gem.Point, gem.Point2D or *gem.Rect shape of the
+// messages read from inputs[0] against the regions of
+// `spec.Regions` and writes pairs (x; []*Region) to the outgoing
+// channel. The regions are updated by the optional *Region stream
+// read from inputs[1]. Messages matching no region are dropped
+// unless `spec.Keep` is set. An optional first attribute
+// func([]T) T maps the pairs before they are written.
+func (g *OGraph) SpatialJoin(spec GeoSpec, attribs ...T) *aGraph {
+	proc := g.NewProcessor(nil, make([]chan T, 1), OP_SPATIAL_JOIN)
+	var f func([]T) T = nil
+	if len(attribs) > 0 {
+		switch t := attribs[0].(type) {
+		case func([]T) T:
+			f = t
+			attribs = attribs[1:]
+		}
+	}
+	if spec.Regions == nil {
+		spec.Regions = NewRegionSet()
+	}
+	g.Register(proc, proc.ParseAttrib(attribs))
+	proc.F = func(inputs ...chan T) []chan T {
+		proc.Inputs = inputs
+		if len(inputs) > 1 {
+			g.readRegions(proc, inputs[1], spec.Regions)
+		}
+		g.group.Add(1)
+		go func() {
+			defer g.group.Done()
+			defer close(proc.Outputs[0])
+			for x := range inputs[0] {
+				comm, state := proc.WaitMessage(x, proc.Outputs...)
+				if !state {
+					break
+				}
+				if comm || x == nil {
+					continue
+				}
+				x = proc.InStack.ExecStack(x)
+				proc.AddTimeInfo(PROC_ENTER_TIME, x)
+				regions := spec.Regions.Match(spec.shape(x))
+				if len(regions) == 0 && !spec.Keep {
+					proc.Metrics.filtered()
+					DeepDispose(x)
+					continue
+				}
+				y := []T{x, regions}
+				proc.AddTimeInfo(PROC_LEAVE_TIME, x)
+				if f == nil {
+					proc.Outputs[0] <- proc.OutStack.ExecStack(y)
+				} else {
+					proc.Outputs[0] <- proc.OutStack.ExecStack(f(y))
+				}
+			}
+		}()
+		return proc.Outputs
+	}
+	return &aGraph{g, proc}
+}
+
+// Geofence processor:
+// It tracks the regions of `spec.Regions` that contain the key
+// `spec.Key(x)` of the messages read from inputs[0], and writes a
+// *GeoEvent message for every region the key enters or exits,
+// exits first. A message that changes nothing writes nothing. The
+// regions are updated by the optional *Region stream read from
+// inputs[1], a key in a removed region exits it with its next
+// message.
+func (g *OGraph) Geofence(spec GeoSpec, attribs ...T) *aGraph {
+	proc := g.NewProcessor(nil, make([]chan T, 1), OP_GEOFENCE)
+	if spec.Regions == nil {
+		spec.Regions = NewRegionSet()
+	}
+	g.Register(proc, proc.ParseAttrib(attribs))
+	proc.F = func(inputs ...chan T) []chan T {
+		proc.Inputs = inputs
+		if len(inputs) > 1 {
+			g.readRegions(proc, inputs[1], spec.Regions)
+		}
+		g.group.Add(1)
+		go func() {
+			defer g.group.Done()
+			defer close(proc.Outputs[0])
+			keys := make(fence)
+			for x := range inputs[0] {
+				comm, state := proc.WaitMessage(x, proc.Outputs...)
+				if !state {
+					break
+				}
+				if comm || x == nil {
+					continue
+				}
+				x = proc.InStack.ExecStack(x)
+				proc.AddTimeInfo(PROC_ENTER_TIME, x)
+				shape := spec.shape(x)
+				events := keys.update(spec.Key(x), spec.Regions.Match(shape), shape)
+				if len(events) == 0 {
+					proc.Metrics.filtered()
+				}
+				proc.AddTimeInfo(PROC_LEAVE_TIME, x)
+				for _, e := range events {
+					y := NewMessage(e)
+					InheritHeader(x, []T{y})
+					proc.Outputs[0] <- proc.OutStack.ExecStack(y)
+				}
+				DeepDispose(x)
+			}
+		}()
+		return proc.Outputs
+	}
+	return &aGraph{g, proc}
+}
@@ -23,6 +23,12 @@ const (
 	OP_SPLIT
 	OP_MISC
 	OP_COMPOSITE
+	OP_PARALLEL
+	OP_JOIN
+	OP_LOOKUP_JOIN
+	OP_DEDUP
+	OP_SPATIAL_JOIN
+	OP_GEOFENCE
 )
 
 const (
@@ -32,6 +38,9 @@ const (
 	OP_ATTRIB_ER_STATUS
 	OP_ATTRIB_PREV_PROC // internal use only
 	OP_ATTRIB_GRAPH_REMOVED
+	OP_ATTRIB_TL    // latency target of the processor branch
+	OP_ATTRIB_TP    // period target of the processor branch
+	OP_ATTRIB_BATCH // batching of the outgoing edges, a *BatchSpec
 )
 
 const (
@@ -71,6 +80,19 @@ type SStats struct {
 	N   float64
 }
 
+func NewSStats(d int) *SStats {
+	return &SStats{gem.NewPoint(d, 0), gem.NewPoint(d, 0), 0}
+}
+
+// AddPoint adds the observation `x` with the weight `w`.
+func (n *SStats) AddPoint(x gem.Point, w float64) {
+	for i, v := range x {
+		n.Xs[i] += w * v
+		n.Xss[i] += w * v * v
+	}
+	n.N += w
+}
+
 func (n *SStats) Add(e *SStats) {
 	n.Xs.Add(e.Xs)
 	n.Xss.Add(e.Xss)
@@ -0,0 +1,163 @@
+package loopy
+
+import (
+	"sync"
+	"time"
+)
+
+//#################################################################
+//                   Micro-batching
+//#################################################################
+
+// DefBatchSize is the number of messages per batch used when
+// a BatchSpec leaves Size at zero.
+const DefBatchSize = 64
+
+// Batch is a slice of messages moved through a channel in one send.
+// Operators unpack it and apply their functions to every element,
+// so user functions never see a Batch.
+type Batch []T
+
+func (b Batch) Clone() T {
+	y := make(Batch, len(b))
+	for i, x := range b {
+		y[i] = DeepClone(x)
+	}
+	return y
+}
+
+func (b Batch) Dispose() {
+	for _, x := range b {
+		DeepDispose(x)
+	}
+}
+
+// BatchSpec bounds a batch by the number of messages and by the time
+// the first message may wait in the buffer. A zero Linger flushes only
+// on Size, at control messages and when the producer terminates.
+type BatchSpec struct {
+	Size   int
+	Linger time.Duration
+}
+
+type batcher struct {
+	proc  *Processor
+	idx   int // output channel of proc
+	spec  BatchSpec
+	buf   Batch
+	timer *time.Timer
+	mutex sync.Mutex
+}
+
+func newBatcher(proc *Processor, idx int, spec BatchSpec) *batcher {
+	if spec.Size <= 0 {
+		spec.Size = DefBatchSize
+	}
+	return &batcher{proc: proc, idx: idx, spec: spec, buf: make(Batch, 0, spec.Size)}
+}
+
+func (b *batcher) send(x T) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if x == nil {
+		// nil messages keep their own send
+		b.flush()
+		b.proc.Outputs[b.idx] <- x
+		return
+	}
+	if y, ok := x.(Batch); ok {
+		b.buf = append(b.buf, y...)
+	} else {
+		b.buf = append(b.buf, x)
+	}
+	if len(b.buf) >= b.spec.Size {
+		b.flush()
+	} else if b.timer == nil && b.spec.Linger > 0 {
+		b.timer = time.AfterFunc(b.spec.Linger, b.Flush)
+	}
+}
+
+func (b *batcher) Flush() {
+	b.mutex.Lock()
+	b.flush()
+	b.mutex.Unlock()
+}
+
+func (b *batcher) flush() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.buf) == 0 {
+		return
+	}
+	y := b.buf
+	b.buf = make(Batch, 0, b.spec.Size)
+	b.proc.Outputs[b.idx] <- y
+}
+
+// forEach applies `f` to every message of a Batch, or to `x` itself.
+func forEach(x T, f func(T)) {
+	if b, ok := x.(Batch); ok {
+		for _, y := range b {
+			f(y)
+		}
+		return
+	}
+	f(x)
+}
+
+// Send writes `x` to the output channel `i`, through the batcher
+// of the channel if the edge is batched.
+func (p *Processor) Send(i int, x T) {
+	if p.batchers != nil && p.batchers[i] != nil {
+		p.batchers[i].send(x)
+		return
+	}
+	p.Outputs[i] <- x
+}
+
+// Flush sends the pending batches of all output channels.
+func (p *Processor) Flush() {
+	for _, b := range p.batchers {
+		if b != nil {
+			b.Flush()
+		}
+	}
+}
+
+// acceptsBatch reports whether the processor `name` unpacks batches.
+func (g *OGraph) acceptsBatch(name string) bool {
+	switch g.Get(name)._type {
+	case OP_MAP, OP_REDUCE, OP_FILTER, OP_GROUND, OP_ADD, OP_SPLIT:
+		return true
+	}
+	return false
+}
+
+// attachBatchers creates a batcher for every outgoing edge of a
+// batching processor whose consumer accepts batches. The processor
+// BatchSpec takes precedence over the graph one.
+func (g *OGraph) attachBatchers() {
+	for name, consumers := range g.outChan_mask {
+		proc := g.Get(name)
+		spec := proc.Batch
+		if spec == nil {
+			spec = g.Batch
+		}
+		if spec == nil || proc.IsComposite {
+			continue
+		}
+		switch proc._type {
+		case OP_SOURCE, OP_MAP, OP_REDUCE, OP_FILTER, OP_ADD, OP_SPLIT:
+		default:
+			continue
+		}
+		proc.batchers = make([]*batcher, len(proc.Outputs))
+		for i, c := range consumers {
+			if i < len(proc.Outputs) && g.acceptsBatch(c) {
+				proc.batchers[i] = newBatcher(proc, i, *spec)
+			}
+		}
+	}
+}
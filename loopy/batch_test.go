@@ -0,0 +1,52 @@
+package loopy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBatchedGraph(t *testing.T) {
+	g := NewOGraph()
+	g.Batch = &BatchSpec{Size: 8, Linger: time.Millisecond}
+	double := &Function{FuncName: "double", Mapper: func(x T, params Params) T {
+		m := x.(*M)
+		m.Value = MessageV(x).(testValue) * 2
+		return m
+	}}
+	odd := &Function{FuncName: "odd", Mapper: func(x T, params Params) T {
+		return MessageV(x).(testValue)%4 == 2
+	}}
+	var seen []testValue
+	missing := 0
+	collect := &Function{FuncName: "collect", Reducer: func(u T, x T, params Params) (T, T) {
+		seen = append(seen, MessageV(x).(testValue))
+		if _, ok := Message(x).TmInfo.Get(g.Get("odd").Id); !ok {
+			missing++
+		}
+		return u, x
+	}}
+	src := g.Source(&testSpout{max: 100}, "src")
+	src.Map(Functions{double}, "double").Filter(Functions{odd}, "odd").Add("add").
+		Reduce(nil, Functions{collect}, "collect").Ground("gnd")
+	g.Execute()
+	g.Wait()
+
+	if len(seen) != 100 {
+		t.Fatalf("expected 100 messages, got %d", len(seen))
+	}
+	if missing > 0 {
+		t.Errorf("%d messages lost their filter time info", missing)
+	}
+	counts := make(map[testValue]int)
+	for _, v := range seen {
+		counts[v]++
+	}
+	for i := 1; i <= 100; i++ {
+		if counts[testValue(2*i)] != 1 {
+			t.Errorf("message %d seen %d times", 2*i, counts[testValue(2*i)])
+		}
+	}
+	if src.Proc.batchers[0] == nil {
+		t.Errorf("source output should be batched")
+	}
+}
@@ -0,0 +1,241 @@
+// Package bench provides synthetic load and canonical topologies
+// for measuring the throughput and latency of loopy graphs.
+package bench
+
+import (
+	"fmt"
+	"loopy"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+//#################################################################
+//                   Recorder
+//#################################################################
+
+// Recorder collects the end-to-end latency of the messages that
+// reach its Map function. The latency is read from TmInfo, from the
+// time the source released a message to the time the recorder
+// received it.
+type Recorder struct {
+	lat   []time.Duration
+	start time.Time
+	end   time.Time
+	mutex *sync.Mutex
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{lat: make([]time.Duration, 0, 1024), mutex: &sync.Mutex{}}
+}
+
+// Function returns the Map function that records the messages.
+func (r *Recorder) Function() *loopy.Function {
+	return &loopy.Function{FuncName: "record", Mapper: func(x loopy.T, params loopy.Params) loopy.T {
+		r.record(x)
+		return x
+	}}
+}
+
+func (r *Recorder) record(x loopy.T) {
+	m := loopy.Message(x)
+	if m == nil || len(m.TmInfo) < 2 {
+		return
+	}
+	// the first entry is the source, the last one the recorder itself
+	d := m.TmInfo[len(m.TmInfo)-1].InTime.Sub(m.TmInfo[0].OutTime)
+	r.mutex.Lock()
+	r.lat = append(r.lat, d)
+	r.mutex.Unlock()
+}
+
+// Count returns the number of recorded messages.
+func (r *Recorder) Count() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.lat)
+}
+
+// Start marks the beginning of the measurement.
+func (r *Recorder) Start() {
+	r.start = time.Now()
+}
+
+// Stop marks the end of the measurement.
+func (r *Recorder) Stop() {
+	r.end = time.Now()
+}
+
+// Result summarizes the recorded messages.
+func (r *Recorder) Result() Result {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	res := Result{Messages: len(r.lat), Elapsed: r.end.Sub(r.start)}
+	if res.Elapsed > 0 {
+		res.Rate = float64(res.Messages) / res.Elapsed.Seconds()
+	}
+	if len(r.lat) == 0 {
+		return res
+	}
+	lat := make([]time.Duration, len(r.lat))
+	copy(lat, r.lat)
+	sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+	res.P50 = percentile(lat, 0.5)
+	res.P90 = percentile(lat, 0.9)
+	res.P99 = percentile(lat, 0.99)
+	res.Max = lat[len(lat)-1]
+	return res
+}
+
+// percentile returns the nearest-rank percentile `q` of the sorted `lat`.
+func percentile(lat []time.Duration, q float64) time.Duration {
+	i := int(math.Ceil(q*float64(len(lat)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(lat) {
+		i = len(lat) - 1
+	}
+	return lat[i]
+}
+
+type Result struct {
+	Messages      int
+	Elapsed       time.Duration
+	Rate          float64 // messages per second
+	P50, P90, P99 time.Duration
+	Max           time.Duration
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("%d msgs in %v: %.0f msgs/s, latency p50 %v p90 %v p99 %v max %v",
+		r.Messages, r.Elapsed, r.Rate, r.P50, r.P90, r.P99, r.Max)
+}
+
+//#################################################################
+//                   Topologies
+//#################################################################
+
+// Scenario describes one benchmark run.
+type Scenario struct {
+	Topology string
+	Spout    SpoutConfig
+	Stages   int              // Map stages of the chain topology
+	Width    int              // fan out of the copy and shuffle topologies
+	Batch    *loopy.BatchSpec // micro-batching of the graph, nil disables it
+}
+
+// Topology builds the graph of a scenario. Every message that leaves
+// the graph passes the function of the recorder `r`.
+type Topology func(g *loopy.OGraph, s Scenario, r *Recorder)
+
+var Topologies = map[string]Topology{
+	"chain":   Chain,
+	"copy":    FanOut,
+	"shuffle": Shuffle,
+	"join":    Join,
+}
+
+func identity() *loopy.Function {
+	return &loopy.Function{FuncName: "identity", Mapper: func(x loopy.T, params loopy.Params) loopy.T {
+		return x
+	}}
+}
+
+// Chain is a linear chain: Source -> `Stages` x Map -> Ground.
+func Chain(g *loopy.OGraph, s Scenario, r *Recorder) {
+	a := g.Source(NewSynthSpout(s.Spout))
+	for i := 0; i < s.Stages; i++ {
+		a = a.Map(loopy.Functions{identity()})
+	}
+	a.Map(loopy.Functions{r.Function()}).Ground()
+}
+
+// FanOut duplicates the stream with Copy into `Width` branches.
+func FanOut(g *loopy.OGraph, s Scenario, r *Recorder) {
+	c := g.Source(NewSynthSpout(s.Spout)).Copy(s.Width)
+	names := make([]string, s.Width)
+	for i := range names {
+		m := g.Map(loopy.Functions{r.Function()})
+		m.Ground()
+		names[i] = m.Proc.Name
+	}
+	g.LinkOut(c.Proc.Name, names...)
+}
+
+// Shuffle partitions the stream of `Width` sources by key with
+// Group into `Width` branches.
+func Shuffle(g *loopy.OGraph, s Scenario, r *Recorder) {
+	n := s.Width
+	// a source with no share of the count would never end
+	k := n
+	if s.Spout.Count < k {
+		k = s.Spout.Count
+	}
+	f := func(x loopy.T) []loopy.T {
+		return []loopy.T{x}
+	}
+	p := func(x loopy.T, i int, n int) int {
+		return loopy.MessageV(x).(*Tuple).Key % n
+	}
+	h1 := func(g *loopy.OGraph, i int) (*loopy.Processor, *loopy.Processor) {
+		c := s.Spout
+		c.Count, c.Seed = c.Count/k, c.Seed+int64(i)
+		if i < s.Spout.Count%k {
+			c.Count++
+		}
+		c.Rate = c.Rate / float64(k)
+		a := g.Source(NewSynthSpout(c))
+		return a.Proc, a.Proc
+	}
+	h2 := func(g *loopy.OGraph, i int) (*loopy.Processor, *loopy.Processor) {
+		m := g.Map(loopy.Functions{r.Function()})
+		e := m.Ground()
+		return m.Proc, e.Proc
+	}
+	g.List(k, h1).Group(k, n, f, p).List(n, h2)
+}
+
+// Join pairs the stream with a side stream of the same load
+// using LeftMultiply.
+func Join(g *loopy.OGraph, s Scenario, r *Recorder) {
+	sp := NewSynthSpout(s.Spout)
+	c := s.Spout
+	c.Count, c.Seed = 0, c.Seed+1
+	side := &sideSpout{NewSynthSpout(c), sp, r}
+	f := func(v []loopy.T) loopy.T {
+		loopy.DeepDispose(v[1])
+		return v[0]
+	}
+	left, right := g.Source(sp), g.Source(side)
+	j := g.LeftMultiply(f)
+	g.LinkIn(j.Proc.Name, left.Proc.Name, right.Proc.Name)
+	m, e := g.Map(loopy.Functions{r.Function()}), g.Ground()
+	g.LinkOut(j.Proc.Name, m.Proc.Name, e.Proc.Name)
+	m.Ground()
+}
+
+// Run builds the scenario graph, runs it to completion and returns
+// the measured result.
+func Run(s Scenario) (Result, error) {
+	top, ok := Topologies[s.Topology]
+	if !ok {
+		return Result{}, fmt.Errorf("unknown topology %q", s.Topology)
+	}
+	if s.Width <= 0 {
+		s.Width = 1
+	}
+	if s.Spout.Count <= 0 {
+		return Result{}, fmt.Errorf("scenario needs a positive message count")
+	}
+	g := loopy.NewOGraph()
+	g.Batch = s.Batch
+	r := NewRecorder()
+	top(g, s, r)
+	r.Start()
+	g.Execute()
+	g.Wait()
+	r.Stop()
+	return r.Result(), nil
+}
@@ -0,0 +1,93 @@
+package bench
+
+import (
+	"loopy"
+	"testing"
+	"time"
+)
+
+func TestTopologies(t *testing.T) {
+	for name := range Topologies {
+		s := Scenario{Topology: name, Spout: SpoutConfig{Count: 200, Payload: 16, Keys: 10, Skew: 1.5},
+			Stages: 3, Width: 3}
+		res, err := Run(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := s.Spout.Count
+		if name == "copy" {
+			want *= s.Width
+		}
+		if res.Messages != want {
+			t.Errorf("%s: recorded %d messages, expected %d", name, res.Messages, want)
+		}
+		if res.P50 > res.P99 || res.P99 > res.Max {
+			t.Errorf("%s: percentiles out of order: %v", name, res)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	lat := make([]time.Duration, 10)
+	for i := range lat {
+		lat[i] = time.Duration(i + 1)
+	}
+	for _, tt := range []struct {
+		q    float64
+		want time.Duration
+	}{{0, 1}, {0.1, 1}, {0.12, 2}, {0.5, 5}, {0.9, 9}, {0.99, 10}, {1, 10}} {
+		if got := percentile(lat, tt.q); got != tt.want {
+			t.Errorf("percentile %v of 1..10 is %v, expected %v", tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestRunUnknown(t *testing.T) {
+	if _, err := Run(Scenario{Topology: "ring", Spout: SpoutConfig{Count: 1}}); err == nil {
+		t.Errorf("expected an error for an unknown topology")
+	}
+}
+
+func TestSpoutRate(t *testing.T) {
+	s := NewSynthSpout(SpoutConfig{Count: 20, Rate: 1000})
+	start := time.Now()
+	for s.Read() != nil {
+	}
+	if d := time.Since(start); d < 15*time.Millisecond {
+		t.Errorf("20 messages at 1000 msgs/s took only %v", d)
+	}
+}
+
+func benchmark(b *testing.B, s Scenario) {
+	s.Spout.Count = b.N
+	s.Spout.Payload, s.Spout.Keys = 64, 1000
+	b.ResetTimer()
+	res, err := Run(s)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportMetric(res.Rate, "msgs/s")
+	b.ReportMetric(float64(res.P50.Nanoseconds()), "p50-ns")
+	b.ReportMetric(float64(res.P99.Nanoseconds()), "p99-ns")
+}
+
+func BenchmarkChain(b *testing.B) {
+	benchmark(b, Scenario{Topology: "chain", Stages: 4})
+}
+
+func BenchmarkChainBatched(b *testing.B) {
+	benchmark(b, Scenario{Topology: "chain", Stages: 4,
+		Batch: &loopy.BatchSpec{Size: 64, Linger: time.Millisecond}})
+}
+
+func BenchmarkCopy(b *testing.B) {
+	benchmark(b, Scenario{Topology: "copy", Width: 4})
+}
+
+func BenchmarkShuffle(b *testing.B) {
+	benchmark(b, Scenario{Topology: "shuffle", Width: 4})
+}
+
+func BenchmarkJoin(b *testing.B) {
+	benchmark(b, Scenario{Topology: "join"})
+}
@@ -0,0 +1,47 @@
+// Command loopybench runs a benchmark scenario and prints the
+// measured throughput and latency percentiles.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"loopy"
+	"loopy/bench"
+	"os"
+	"sort"
+	"time"
+)
+
+func main() {
+	var (
+		s     bench.Scenario
+		batch int
+		lg    time.Duration
+	)
+	names := make([]string, 0, len(bench.Topologies))
+	for k := range bench.Topologies {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	flag.StringVar(&s.Topology, "topology", "chain", fmt.Sprintf("topology to run, one of %v", names))
+	flag.IntVar(&s.Spout.Count, "n", 100000, "number of messages")
+	flag.Float64Var(&s.Spout.Rate, "rate", 0, "messages per second, 0 is unthrottled")
+	flag.IntVar(&s.Spout.Payload, "payload", 64, "payload size in bytes")
+	flag.IntVar(&s.Spout.Keys, "keys", 1000, "size of the key space")
+	flag.Float64Var(&s.Spout.Skew, "skew", 0, "Zipf exponent of the keys, values <= 1 are uniform")
+	flag.Int64Var(&s.Spout.Seed, "seed", 1, "random seed")
+	flag.IntVar(&s.Stages, "stages", 4, "Map stages of the chain topology")
+	flag.IntVar(&s.Width, "width", 4, "fan out of the copy and shuffle topologies")
+	flag.IntVar(&batch, "batch", 0, "messages per batch, 0 disables batching")
+	flag.DurationVar(&lg, "linger", time.Millisecond, "maximum wait of a message in a batch")
+	flag.Parse()
+	if batch > 0 {
+		s.Batch = &loopy.BatchSpec{Size: batch, Linger: lg}
+	}
+	res, err := bench.Run(s)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s: %v\n", s.Topology, res)
+}
@@ -0,0 +1,121 @@
+package bench
+
+import (
+	"loopy"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+//#################################################################
+//                   Synthetic Tuples
+//#################################################################
+
+// Tuple is the value carried by the synthetic messages.
+type Tuple struct {
+	Key     int
+	Payload []byte
+}
+
+func (t *Tuple) Clone() loopy.T {
+	c := &Tuple{Key: t.Key, Payload: make([]byte, len(t.Payload))}
+	copy(c.Payload, t.Payload)
+	return c
+}
+
+func (t *Tuple) Dispose() {}
+
+//#################################################################
+//                   Synthetic Spout
+//#################################################################
+
+// SpoutConfig describes the load of a synthetic spout.
+type SpoutConfig struct {
+	Count   int     // number of messages, zero never ends
+	Rate    float64 // messages per second, zero is unthrottled
+	Payload int     // payload size in bytes
+	Keys    int     // size of the key space
+	Skew    float64 // Zipf exponent of the keys, values <= 1 are uniform
+	Seed    int64
+}
+
+// SynthSpout emits `Count` messages of `Payload` bytes with keys
+// drawn from `Keys` values, paced to `Rate` messages per second.
+type SynthSpout struct {
+	SpoutConfig
+	r       *rand.Rand
+	zipf    *rand.Zipf
+	payload []byte
+	n       int
+	start   time.Time
+	mutex   *sync.Mutex
+}
+
+func NewSynthSpout(c SpoutConfig) *SynthSpout {
+	if c.Keys <= 0 {
+		c.Keys = 1
+	}
+	s := &SynthSpout{SpoutConfig: c, r: rand.New(rand.NewSource(c.Seed)),
+		payload: make([]byte, c.Payload), mutex: &sync.Mutex{}}
+	if c.Skew > 1 {
+		s.zipf = rand.NewZipf(s.r, c.Skew, 1, uint64(c.Keys-1))
+	}
+	s.r.Read(s.payload)
+	return s
+}
+
+func (s *SynthSpout) Read() loopy.T {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.Count > 0 && s.n >= s.Count {
+		return nil
+	}
+	if s.n == 0 {
+		s.start = time.Now()
+	}
+	if s.Rate > 0 {
+		next := s.start.Add(time.Duration(float64(s.n) / s.Rate * float64(time.Second)))
+		if d := time.Until(next); d > 0 {
+			time.Sleep(d)
+		}
+	}
+	s.n++
+	t := &Tuple{Payload: make([]byte, len(s.payload))}
+	copy(t.Payload, s.payload)
+	if s.zipf != nil {
+		t.Key = int(s.zipf.Uint64())
+	} else {
+		t.Key = s.r.Intn(s.Keys)
+	}
+	return loopy.NewMessage(t)
+}
+
+// Emitted returns the number of messages read so far.
+func (s *SynthSpout) Emitted() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.n
+}
+
+// Exhausted reports whether the spout has emitted all its messages.
+func (s *SynthSpout) Exhausted() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.Count > 0 && s.n >= s.Count
+}
+
+// sideSpout feeds the right side of a join. It keeps emitting until
+// every message of the main spout has been recorded, so that none
+// of them misses a latched value.
+type sideSpout struct {
+	*SynthSpout
+	main *SynthSpout
+	rec  *Recorder
+}
+
+func (s *sideSpout) Read() loopy.T {
+	if s.main.Exhausted() && s.rec.Count() >= s.main.Emitted() {
+		return nil
+	}
+	return s.SynthSpout.Read()
+}
@@ -0,0 +1,221 @@
+package loopy
+
+import (
+	"gem"
+	"math"
+	"time"
+)
+
+//#################################################################
+//                   Online Clustering
+//#################################################################
+
+// MicroCluster summarizes the points assigned to it with decayed
+// sufficient statistics.
+type MicroCluster struct {
+	Id    uint64
+	Stats *SStats
+}
+
+func (c *MicroCluster) Center() gem.Point {
+	return c.Stats.Mean()
+}
+
+// Radius returns the root mean square distance of the points from
+// the center.
+func (c *MicroCluster) Radius() float64 {
+	_, v := c.Stats.MV()
+	sum := 0.0
+	for _, vi := range v {
+		sum += vi
+	}
+	return math.Sqrt(sum)
+}
+
+// ClusterSummary describes a cluster in a snapshot.
+type ClusterSummary struct {
+	Id     uint64
+	Center gem.Point
+	Radius float64
+	Weight float64
+}
+
+// ClusterSnapshot lists the micro-clusters at a point in time.
+type ClusterSnapshot struct {
+	Time     time.Time
+	Clusters []ClusterSummary
+}
+
+// Macro groups the micro-clusters of the snapshot into `k` clusters
+// by weighted centroid-linkage agglomeration, the online hierarchy
+// is the sequence of these merges. The Ids of the result are those
+// of the heaviest member.
+func (s *ClusterSnapshot) Macro(k int) []ClusterSummary {
+	type node struct {
+		ClusterSummary
+		heaviest float64
+	}
+	nodes := make([]*node, len(s.Clusters))
+	for i, c := range s.Clusters {
+		nodes[i] = &node{c, c.Weight}
+		nodes[i].Center = c.Center.Clone()
+	}
+	for len(nodes) > k && len(nodes) > 1 {
+		bi, bj, bd := 0, 1, math.Inf(1)
+		for i := range nodes {
+			for j := i + 1; j < len(nodes); j++ {
+				if d := nodes[i].Center.Dist(nodes[j].Center); d < bd {
+					bi, bj, bd = i, j, d
+				}
+			}
+		}
+		a, b := nodes[bi], nodes[bj]
+		w := a.Weight + b.Weight
+		for i := range a.Center {
+			a.Center[i] = (a.Center[i]*a.Weight + b.Center[i]*b.Weight) / w
+		}
+		a.Radius = math.Max(a.Radius, b.Radius) + bd/2
+		a.Weight = w
+		if b.heaviest > a.heaviest {
+			a.Id, a.heaviest = b.Id, b.heaviest
+		}
+		nodes = append(nodes[:bj], nodes[bj+1:]...)
+	}
+	ret := make([]ClusterSummary, len(nodes))
+	for i, n := range nodes {
+		ret[i] = n.ClusterSummary
+	}
+	return ret
+}
+
+func (s *ClusterSnapshot) Dispose() {}
+
+// ClusterResult is written for every incoming point. Snapshot is set
+// every "snapshot" points and nil otherwise.
+type ClusterResult struct {
+	Id       uint64 // cluster of the point
+	Point    gem.Point
+	Distance float64 // distance of the point from the cluster center before the update
+	New      bool    // the point opened a new cluster
+	Snapshot *ClusterSnapshot
+}
+
+func (r *ClusterResult) Dispose() {}
+
+// Clustering maintains at most Max micro-clusters. A point joins the
+// nearest cluster whose center is within Radius, otherwise it opens
+// a new one, and the two closest clusters are merged if there are
+// too many. Clusters decay at the rate Alpha per second and are
+// dropped once their weight falls below MinWeight.
+type Clustering struct {
+	Radius    float64
+	Max       int
+	Alpha     float64
+	MinWeight float64
+	Clusters  []*MicroCluster
+	nextId    uint64
+	n         int
+	last      time.Time
+}
+
+func NewClustering(radius float64, max int, alpha, minWeight float64) *Clustering {
+	return &Clustering{Radius: radius, Max: gem.IntMax(max, 1), Alpha: alpha, MinWeight: minWeight}
+}
+
+// decay fades all clusters to `now` and drops the faded ones.
+func (c *Clustering) decay(now time.Time) {
+	if c.Alpha > 0 && !c.last.IsZero() {
+		dt := now.Sub(c.last).Seconds()
+		kept := c.Clusters[:0]
+		for _, m := range c.Clusters {
+			m.Stats.Decay(c.Alpha, dt)
+			if m.Stats.N >= c.MinWeight {
+				kept = append(kept, m)
+			}
+		}
+		c.Clusters = kept
+	}
+	c.last = now
+}
+
+// Add assigns `x` observed at `now` to a cluster.
+func (c *Clustering) Add(x gem.Point, now time.Time) *ClusterResult {
+	c.decay(now)
+	c.n++
+	r := &ClusterResult{Point: x, Distance: math.Inf(1)}
+	var best *MicroCluster
+	for _, m := range c.Clusters {
+		if d := m.Center().Dist(x); d < r.Distance {
+			best, r.Distance = m, d
+		}
+	}
+	if best == nil || r.Distance > c.Radius {
+		c.nextId++
+		best = &MicroCluster{Id: c.nextId, Stats: NewSStats(len(x))}
+		c.Clusters = append(c.Clusters, best)
+		r.New = true
+	}
+	best.Stats.AddPoint(x, 1)
+	r.Id = best.Id
+	if len(c.Clusters) > c.Max {
+		c.mergeClosest()
+	}
+	return r
+}
+
+// mergeClosest merges the two clusters with the closest centers into
+// the heavier one. A point assigned to the lighter one now belongs to
+// the heavier one.
+func (c *Clustering) mergeClosest() {
+	bi, bj, bd := 0, 1, math.Inf(1)
+	for i := range c.Clusters {
+		ci := c.Clusters[i].Center()
+		for j := i + 1; j < len(c.Clusters); j++ {
+			if d := ci.Dist(c.Clusters[j].Center()); d < bd {
+				bi, bj, bd = i, j, d
+			}
+		}
+	}
+	if c.Clusters[bj].Stats.N > c.Clusters[bi].Stats.N {
+		bi, bj = bj, bi
+	}
+	c.Clusters[bi].Stats.Add(c.Clusters[bj].Stats)
+	c.Clusters = append(c.Clusters[:bj], c.Clusters[bj+1:]...)
+}
+
+// Snapshot summarizes the current clusters.
+func (c *Clustering) Snapshot() *ClusterSnapshot {
+	s := &ClusterSnapshot{Time: c.last, Clusters: make([]ClusterSummary, len(c.Clusters))}
+	for i, m := range c.Clusters {
+		s.Clusters[i] = ClusterSummary{m.Id, m.Center(), m.Radius(), m.Stats.N}
+	}
+	return s
+}
+
+func (c *Clustering) Dispose() {}
+
+// ClusterFunction returns a reducer over gem.Point messages that
+// clusters them online with a Clustering and writes a *ClusterResult
+// for every point. The parameters "radius", "alpha" and "snapshot"
+// (points between snapshots, zero disables them) can be tuned at
+// runtime.
+func ClusterFunction(radius float64, max int, alpha, minWeight float64, snapshot int) *Function {
+	return &Function{FuncName: "cluster",
+		FuncParams: Params{"radius": Parameter{radius, 0, math.MaxFloat64},
+			"alpha":    Parameter{alpha, 0, math.MaxFloat64},
+			"snapshot": Parameter{float64(snapshot), 0, math.MaxInt32}},
+		Reducer: func(u, x T, params Params) (T, T) {
+			c, ok := u.(*Clustering)
+			if !ok {
+				c = NewClustering(radius, max, alpha, minWeight)
+			}
+			c.Radius, c.Alpha = params["radius"].Value, params["alpha"].Value
+			r := c.Add(MessageV(x).(gem.Point), time.Now())
+			if every := int(params["snapshot"].Value); every > 0 && c.n%every == 0 {
+				r.Snapshot = c.Snapshot()
+			}
+			y := NewMessage(r)
+			InheritHeader(x, []T{y})
+			return c, y
+		}}
+}
@@ -0,0 +1,87 @@
+package loopy
+
+import (
+	"gem"
+	"testing"
+	"time"
+)
+
+type pointSpout struct {
+	points []gem.Point
+	n      int
+}
+
+func (s *pointSpout) Read() T {
+	if s.n >= len(s.points) {
+		return nil
+	}
+	s.n++
+	return NewMessage(s.points[s.n-1])
+}
+
+func TestClusterFunction(t *testing.T) {
+	var points []gem.Point
+	for i := 0; i < 20; i++ {
+		d := float64(i%5) * 0.1
+		points = append(points, gem.Point{d, d}, gem.Point{10 + d, 10 - d})
+	}
+	var out []*ClusterResult
+	collect := &Function{FuncName: "collect", Reducer: func(u, x T, params Params) (T, T) {
+		out = append(out, MessageV(x).(*ClusterResult))
+		return u, x
+	}}
+	g := NewOGraph()
+	g.Source(&pointSpout{points: points}).Reduce(nil, Functions{ClusterFunction(2, 8, 0, 0, 10)}).
+		Reduce(nil, Functions{collect}).Ground()
+	g.Execute()
+	g.Wait()
+	if len(out) != len(points) {
+		t.Fatalf("%d results for %d points", len(out), len(points))
+	}
+	ids := map[int]uint64{}
+	snapshots := 0
+	for i, r := range out {
+		if prev, ok := ids[i%2]; ok && prev != r.Id {
+			t.Errorf("point %d assigned to %d, blob assigned to %d", i, r.Id, prev)
+		}
+		ids[i%2] = r.Id
+		if r.New != (i < 2) {
+			t.Errorf("point %d: new %v", i, r.New)
+		}
+		if r.Snapshot != nil {
+			snapshots++
+			if len(r.Snapshot.Clusters) != 2 {
+				t.Errorf("snapshot with %d clusters", len(r.Snapshot.Clusters))
+			}
+		}
+	}
+	if ids[0] == ids[1] || snapshots != 4 {
+		t.Errorf("ids %v, %d snapshots", ids, snapshots)
+	}
+}
+
+func TestClusteringMergeDecay(t *testing.T) {
+	now := time.Now()
+	c := NewClustering(0.5, 2, 0, 0)
+	c.Add(gem.Point{0}, now)
+	c.Add(gem.Point{0}, now)
+	c.Add(gem.Point{10}, now)
+	r := c.Add(gem.Point{1}, now)
+	if !r.New || len(c.Clusters) != 2 {
+		t.Fatalf("new %v, %d clusters", r.New, len(c.Clusters))
+	}
+	// {1} merged into the heavier cluster at 0
+	if s := c.Snapshot(); s.Clusters[0].Weight != 3 || s.Clusters[0].Center[0] != 1.0/3 {
+		t.Errorf("merged cluster %+v", s.Clusters[0])
+	}
+	if m := c.Snapshot().Macro(1); len(m) != 1 || m[0].Weight != 4 || m[0].Id != 1 {
+		t.Errorf("macro %+v", m)
+	}
+
+	c = NewClustering(0.5, 4, 1, 0.5)
+	c.Add(gem.Point{0}, now)
+	c.Add(gem.Point{5}, now.Add(2*time.Second))
+	if len(c.Clusters) != 1 || c.Clusters[0].Id != 2 {
+		t.Errorf("faded cluster kept: %d clusters", len(c.Clusters))
+	}
+}
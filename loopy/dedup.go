@@ -0,0 +1,141 @@
+package loopy
+
+import (
+	"bytes"
+	"encoding/gob"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//#################################################################
+//                   Deduplication State
+//#################################################################
+
+type dedupEntry struct {
+	key string
+	t   time.Time
+}
+
+// DedupState remembers the keys seen within TTL. When Cap is positive
+// at most Cap keys are kept and the oldest ones are forgotten first.
+// Hits counts the duplicates, Misses the keys seen for the first time,
+// both are updated atomically so Counts can read them while it runs.
+// It is the reducer state of a Dedup processor, so it can be saved and
+// restored with MarshalBinary and UnmarshalBinary.
+type DedupState struct {
+	TTL          time.Duration
+	Cap          int
+	Hits, Misses uint64
+	seen         map[string]time.Time
+	order        []dedupEntry // keys by the time they were seen
+	head         int
+	mutex        *sync.Mutex
+}
+
+func NewDedupState(ttl time.Duration, cap int) *DedupState {
+	return &DedupState{TTL: ttl, Cap: cap, seen: make(map[string]time.Time),
+		order: make([]dedupEntry, 0), mutex: &sync.Mutex{}}
+}
+
+// Seen reports whether `key` was seen within TTL before `now` and
+// records it otherwise.
+func (s *DedupState) Seen(key string, now time.Time) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.expire(now)
+	if _, ok := s.seen[key]; ok {
+		atomic.AddUint64(&s.Hits, 1)
+		return true
+	}
+	atomic.AddUint64(&s.Misses, 1)
+	s.seen[key] = now
+	s.order = append(s.order, dedupEntry{key, now})
+	if s.Cap > 0 && len(s.seen) > s.Cap {
+		s.pop()
+	}
+	return false
+}
+
+// Counts returns the number of duplicates and of first seen keys.
+func (s *DedupState) Counts() (hits, misses uint64) {
+	return atomic.LoadUint64(&s.Hits), atomic.LoadUint64(&s.Misses)
+}
+
+// Len returns the number of remembered keys.
+func (s *DedupState) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.seen)
+}
+
+func (s *DedupState) expire(now time.Time) {
+	limit := now.Add(-s.TTL)
+	for s.head < len(s.order) && !s.order[s.head].t.After(limit) {
+		s.pop()
+	}
+}
+
+func (s *DedupState) pop() {
+	delete(s.seen, s.order[s.head].key)
+	s.order[s.head] = dedupEntry{}
+	s.head++
+	// compact once the consumed prefix dominates
+	if s.head > len(s.order)/2 {
+		s.order = append(s.order[:0], s.order[s.head:]...)
+		s.head = 0
+	}
+}
+
+func (s *DedupState) Clone() T {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	c := NewDedupState(s.TTL, s.Cap)
+	c.Hits, c.Misses = s.Hits, s.Misses
+	for _, e := range s.order[s.head:] {
+		c.seen[e.key] = e.t
+		c.order = append(c.order, e)
+	}
+	return c
+}
+
+func (s *DedupState) Dispose() {}
+
+type dedupCheckpoint struct {
+	TTL          time.Duration
+	Cap          int
+	Hits, Misses uint64
+	Keys         []string
+	Times        []time.Time
+}
+
+func (s *DedupState) MarshalBinary() ([]byte, error) {
+	s.mutex.Lock()
+	c := dedupCheckpoint{TTL: s.TTL, Cap: s.Cap}
+	c.Hits, c.Misses = s.Counts()
+	for _, e := range s.order[s.head:] {
+		c.Keys = append(c.Keys, e.key)
+		c.Times = append(c.Times, e.t)
+	}
+	s.mutex.Unlock()
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(c); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *DedupState) UnmarshalBinary(data []byte) error {
+	var c dedupCheckpoint
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&c); err != nil {
+		return err
+	}
+	r := NewDedupState(c.TTL, c.Cap)
+	r.Hits, r.Misses = c.Hits, c.Misses
+	for i, k := range c.Keys {
+		r.seen[k] = c.Times[i]
+		r.order = append(r.order, dedupEntry{k, c.Times[i]})
+	}
+	*s = *r
+	return nil
+}
@@ -0,0 +1,91 @@
+package loopy
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDedupState(t *testing.T) {
+	t0 := time.Now()
+	at := func(s int) time.Time { return t0.Add(time.Duration(s) * time.Second) }
+	tests := []struct {
+		cap  int
+		keys []string
+		ts   []int
+		dup  []bool
+	}{
+		{0, []string{"a", "b", "a", "a"}, []int{0, 1, 2, 20}, []bool{false, false, true, false}},
+		{2, []string{"a", "b", "c", "a", "c"}, []int{0, 1, 2, 3, 4}, []bool{false, false, false, false, true}},
+	}
+	for _, tt := range tests {
+		s := NewDedupState(10*time.Second, tt.cap)
+		for i, k := range tt.keys {
+			if dup := s.Seen(k, at(tt.ts[i])); dup != tt.dup[i] {
+				t.Errorf("cap %d: key %s at %ds reported duplicate %v", tt.cap, k, tt.ts[i], dup)
+			}
+		}
+		if tt.cap > 0 && s.Len() > tt.cap {
+			t.Errorf("cap %d: state holds %d keys", tt.cap, s.Len())
+		}
+	}
+}
+
+func TestDedupCheckpoint(t *testing.T) {
+	t0 := time.Now()
+	s := NewDedupState(time.Minute, 0)
+	for i := 0; i < 100; i++ {
+		s.Seen(fmt.Sprint(i%40), t0)
+	}
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &DedupState{}
+	if err := r.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if r.Len() != 40 || r.Hits != 60 || r.Misses != 40 || r.TTL != time.Minute {
+		t.Errorf("restored state has %d keys, %d hits, %d misses", r.Len(), r.Hits, r.Misses)
+	}
+	if !r.Seen("7", t0.Add(time.Second)) {
+		t.Errorf("restored state forgot key 7")
+	}
+}
+
+func TestDedupGraph(t *testing.T) {
+	g := NewOGraph()
+	n := 0
+	count := &Function{FuncName: "count", Reducer: func(u T, x T, params Params) (T, T) {
+		n++
+		return u, x
+	}}
+	key := func(x T) string { return fmt.Sprint(MessageV(x).(testValue) % 7) }
+	s := NewDedupState(time.Minute, 0)
+	g.Source(&testSpout{max: 50}).Dedup(key, time.Minute, s).Reduce(nil, Functions{count}).Ground()
+	g.Execute()
+	// the counts are read while the graph runs
+	stop, done := make(chan bool), make(chan bool)
+	go func() {
+		defer close(done)
+		var last uint64
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			hits, misses := s.Counts()
+			if hits+misses < last {
+				t.Errorf("counts went back from %d to %d", last, hits+misses)
+			}
+			last = hits + misses
+		}
+	}()
+	g.Wait()
+	close(stop)
+	<-done
+	if hits, misses := s.Counts(); n != 7 || hits != 43 || misses != 7 {
+		t.Errorf("got %d messages, %d hits and %d misses", n, hits, misses)
+	}
+}
@@ -0,0 +1,211 @@
+package loopy
+
+import (
+	"gem"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/satori/go.uuid"
+)
+
+//#################################################################
+//                   Replicas
+//#################################################################
+
+// Replicas holds the runtime state of a Parallel processor. All
+// replicas read from one work queue (the Split side) and write to
+// the processor output (the Add side), so a replica can be added or
+// removed without rewiring any channel.
+type Replicas struct {
+	Min, Max int
+	stages   *ProcessorStack
+	work     chan T
+	wake     chan bool // wakes idle replicas to check if they retire
+	done     chan bool
+	n        int     // target number of replicas
+	live     int     // number of running replicas
+	P        float64 // mean time of a message in a replica (ms)
+	closed   bool
+	mutex    *sync.Mutex
+	wg       *sync.WaitGroup
+	proc     *Processor
+}
+
+func NewReplicas(g *OGraph, n, min, max int, stages []Functions) *Replicas {
+	min = gem.IntMax(min, 1)
+	max = gem.IntMax(max, min)
+	r := &Replicas{Min: min, Max: max,
+		stages: &ProcessorStack{size: 0, mutex: &sync.Mutex{}},
+		work:   make(chan T, max),
+		wake:   make(chan bool, max),
+		done:   make(chan bool),
+		n:      minInt(gem.IntMax(n, min), max),
+		mutex:  &sync.Mutex{},
+		wg:     &sync.WaitGroup{}}
+	// ExecStack runs from the top, so push the last stage first
+	for i := len(stages) - 1; i >= 0; i-- {
+		r.stages.Push(&ProcessorInfo{Name: uuid.NewV4().String(), Id: g.seq.Read(),
+			_type: OP_MAP, Funcs: stages[i], FuncIdx: 0})
+	}
+	return r
+}
+
+// Len returns the number of running replicas.
+func (r *Replicas) Len() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.live
+}
+
+// Queue returns the number of messages waiting for a replica.
+func (r *Replicas) Queue() int {
+	return len(r.work)
+}
+
+// Start launches the initial replicas and the controller of `proc`.
+func (r *Replicas) Start(proc *Processor) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.proc = proc
+	for ; r.live < r.n; r.live++ {
+		r.spawn()
+	}
+	proc.G.group.Add(1)
+	go func() {
+		defer proc.G.group.Done()
+		r.control()
+	}()
+}
+
+// Scale sets the number of replicas to `n` bounded by [Min, Max].
+// Removed replicas finish their current message before exiting, so
+// Scale returns before the replicas are gone.
+func (r *Replicas) Scale(n int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed || r.proc == nil {
+		return
+	}
+	r.n = minInt(gem.IntMax(n, r.Min), r.Max)
+	for ; r.live < r.n; r.live++ {
+		r.spawn()
+	}
+	for i := r.n; i < r.live; i++ {
+		// busy replicas retire after their message
+		select {
+		case r.wake <- true:
+		default:
+		}
+	}
+}
+
+// retire reports whether a replica has to exit to meet the target.
+func (r *Replicas) retire() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.live > r.n {
+		r.live--
+		return true
+	}
+	return false
+}
+
+// observe adds the time `dt` of a message to the mean time P.
+func (r *Replicas) observe(dt time.Duration) {
+	ms := dt.Seconds() * 1000
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.P == 0 {
+		r.P = ms
+		return
+	}
+	r.P += r.proc.G.Alpha * (ms - r.P)
+}
+
+// Close stops the controller and closes the work queue. The output
+// is closed once the last replica drained the queue.
+func (r *Replicas) Close() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return
+	}
+	r.closed = true
+	close(r.done)
+	close(r.work)
+	go func() {
+		r.wg.Wait()
+		close(r.proc.Outputs[0])
+	}()
+}
+
+func (r *Replicas) spawn() {
+	proc := r.proc
+	r.wg.Add(1)
+	proc.G.group.Add(1)
+	go func() {
+		defer proc.G.group.Done()
+		defer r.wg.Done()
+		for {
+			select {
+			case <-r.wake:
+			case x, ok := <-r.work:
+				if !ok {
+					return
+				}
+				t := time.Now()
+				y := r.stages.ExecStack(x)
+				r.observe(time.Since(t))
+				proc.AddTimeInfo(PROC_LEAVE_TIME, y)
+				proc.Outputs[0] <- y
+			}
+			if r.retire() {
+				return
+			}
+		}
+	}()
+}
+
+func (r *Replicas) control() {
+	d := time.Duration(r.proc.G.SchInt * float64(time.Millisecond))
+	ticks := time.NewTicker(d)
+	defer ticks.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticks.C:
+			r.Scale(r.target())
+		}
+	}
+}
+
+// target returns the number of replicas needed to keep up with the
+// incoming stream. A growing queue or a period above the period
+// threshold adds a replica, an empty queue with enough headroom
+// removes one. The period is the time the replicas measure for a
+// message, so it does not depend on the scheduler statistics.
+func (r *Replicas) target() int {
+	r.mutex.Lock()
+	var (
+		g  *OGraph = r.proc.G
+		n  int     = r.n
+		q  int     = r.Queue()
+		P  float64 = r.P // n replicas share the load
+		TP float64 = g.TP
+	)
+	r.mutex.Unlock()
+	if b := g.Edges_info[r.proc.Name].br; b != nil {
+		_, TP = g.branchTargets(b)
+	} else if r.proc.TP > 0 {
+		TP = math.Min(TP, r.proc.TP)
+	}
+	if q > cap(r.work)/2 || P/float64(n) > TP {
+		return n + 1
+	}
+	if q == 0 && n > r.Min && P/float64(n-1) < TP {
+		return n - 1
+	}
+	return n
+}
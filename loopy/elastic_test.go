@@ -0,0 +1,58 @@
+package loopy
+
+import (
+	"gem"
+	"testing"
+	"time"
+)
+
+func TestReplicasScale(t *testing.T) {
+	g := NewOGraph()
+	in := make(chanSpout)
+	slow := &Function{FuncName: "slow", Mapper: func(x T, params Params) T {
+		time.Sleep(100 * time.Microsecond)
+		return x
+	}}
+	var seen []testValue
+	collect := &Function{FuncName: "collect", Mapper: func(x T, params Params) T {
+		seen = append(seen, MessageV(x).(testValue))
+		return x
+	}}
+	par := g.Source(in, "src").Parallel(2, 1, 8, []Functions{{slow}}, "par")
+	par.Map(Functions{collect}, "collect").Ground("gnd")
+	g.Execute()
+	r := par.Proc.Replicas
+
+	n := 0
+	for _, k := range []int{8, 1, 5, 0, 20, 3} {
+		r.Scale(k)
+		for i := 0; i < 200; i++ {
+			n++
+			in <- NewMessage(testValue(n))
+		}
+		want := minInt(gem.IntMax(k, r.Min), r.Max)
+		for start := time.Now(); r.Len() != want; time.Sleep(time.Millisecond) {
+			if time.Since(start) > time.Second {
+				t.Fatalf("scale %d: %d replicas running, expected %d", k, r.Len(), want)
+			}
+		}
+	}
+	close(in)
+	g.Wait()
+
+	if len(seen) != n {
+		t.Fatalf("expected %d messages, got %d", n, len(seen))
+	}
+	counts := make(map[testValue]int)
+	for _, v := range seen {
+		counts[v]++
+	}
+	for i := 1; i <= n; i++ {
+		if counts[testValue(i)] != 1 {
+			t.Errorf("message %d seen %d times", i, counts[testValue(i)])
+		}
+	}
+	if r.P <= 0 {
+		t.Errorf("expected the replicas to measure their time, got %v", r.P)
+	}
+}
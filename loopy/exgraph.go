@@ -44,7 +44,11 @@ type OGraph struct {
 	NumCpu       int        // number of cpus for scheduling
 	monProc      *Processor // Monitor processor
 	TL, TP       float64    // Thresholds for Period and Latency
+	Recycle      bool       // Release messages to the pool at the grounds
+	Batch        *BatchSpec // Micro-batching of the edges, nil disables it
+	Schedule     bool       // Merge and split the branches every SchInt
 	group        *sync.WaitGroup
+	monGroup     *sync.WaitGroup
 	seq          *Sequence
 	// updates    map[string]*ProcInfoList
 	// views      []ParamsViewer
@@ -68,7 +72,7 @@ func NewOGraph() *OGraph {
 		ProcIdToName: make(map[uint64]string),
 		Alpha:        0.2, DecayInt: 5000, SchInt: 10000,
 		Active: true, NumCpu: runtime.NumCPU(), monProc: nil,
-		TL: 100, TP: 60, group: &sync.WaitGroup{},
+		TL: 100, TP: 60, group: &sync.WaitGroup{}, monGroup: &sync.WaitGroup{},
 		seq: NewSequence(0)}
 }
 
@@ -259,6 +263,17 @@ func (g *OGraph) Register(proc *Processor, pproc *Processor) {
 
 func (g *OGraph) Wait() {
 	g.group.Wait()
+	g.monGroup.Wait()
+}
+
+// upstreamIds returns the ids of the processors with an edge into
+// the processor `name`.
+func (g *OGraph) upstreamIds(name string) []uint64 {
+	ids := make([]uint64, 0, len(g.Edges_info[name].Chans))
+	for u := range g.Edges_info[name].Chans {
+		ids = append(ids, g.Get(u).Id)
+	}
+	return ids
 }
 
 func (g *aGraph) Source(s Spout, attribs ...T) *aGraph {
@@ -341,8 +356,46 @@ func (g *aGraph) Group(n_inputs, n_outputs int, f func(T) []T, p func(T, int, in
 	return g.OGraph.Group(n_inputs, n_outputs, f, p, attribs...)
 }
 
+func (g *aGraph) Aggregate(n_inputs, n_outputs int, spec AggregateSpec, attribs ...T) *aGraph {
+	attribs = append(attribs, OP_ATTRIB_PREV_PROC, g.Proc)
+	return g.OGraph.Aggregate(n_inputs, n_outputs, spec, attribs...)
+}
+
+func (g *aGraph) Parallel(n, min, max int, stages []Functions, attribs ...T) *aGraph {
+	attribs = append(attribs, OP_ATTRIB_PREV_PROC, g.Proc)
+	return g.OGraph.Parallel(n, min, max, stages, attribs...)
+}
+
+func (g *aGraph) Join(spec JoinSpec, attribs ...T) *aGraph {
+	attribs = append(attribs, OP_ATTRIB_PREV_PROC, g.Proc)
+	return g.OGraph.Join(spec, attribs...)
+}
+
+func (g *aGraph) LookupJoin(spec LookupSpec, attribs ...T) *aGraph {
+	attribs = append(attribs, OP_ATTRIB_PREV_PROC, g.Proc)
+	return g.OGraph.LookupJoin(spec, attribs...)
+}
+
+func (g *aGraph) Dedup(key func(T) string, ttl time.Duration, attribs ...T) *aGraph {
+	attribs = append(attribs, OP_ATTRIB_PREV_PROC, g.Proc)
+	return g.OGraph.Dedup(key, ttl, attribs...)
+}
+
+func (g *aGraph) SpatialJoin(spec GeoSpec, attribs ...T) *aGraph {
+	attribs = append(attribs, OP_ATTRIB_PREV_PROC, g.Proc)
+	return g.OGraph.SpatialJoin(spec, attribs...)
+}
+
+func (g *aGraph) Geofence(spec GeoSpec, attribs ...T) *aGraph {
+	attribs = append(attribs, OP_ATTRIB_PREV_PROC, g.Proc)
+	return g.OGraph.Geofence(spec, attribs...)
+}
+
 func (g *OGraph) Execute() {
-	//g.scan()
+	if g.Schedule {
+		g.scan()
+	}
+	inputs := make(map[string][]chan T)
 	for name2, e_info := range g.Edges_info {
 		chans := make([]chan T, e_info.NInchans)
 		in_proc := (*g.Nodes_map[name2].Value).(*Processor)
@@ -359,34 +412,49 @@ func (g *OGraph) Execute() {
 				chans[idx] = out_proc.Outputs[chan_info.Out_idxs[i]]
 			}
 		}
-		in_proc.F(chans...)
+		inputs[name2] = chans
+	}
+	g.attachBatchers()
+	for name2, chans := range inputs {
+		g.Get(name2).F(chans...)
+	}
+	if g.Schedule {
+		g.monitor(g.monGroup)
 	}
-	//g.monitor(g.group)
 }
 
+// scan finds the branches of the graph. A branch is a maximal chain
+// of Map and Reduce processors, the only processors that can be merged
+// into a group. When the processor after the chain can run the merged
+// processors as well, it ends the branch.
 func (g *OGraph) scan() {
-	for br_k, _ := range g.split_nodes {
-		nodes := g.Neighbors(g.Nodes_map[br_k])
-		for _, n := range nodes {
-			c_k := (*n.Value).(*Processor).Name
-			if g.Edges_info[c_k].NInchans > 1 {
-				continue
-			}
-			b := &Branch{Br_start: br_k, G: g}
-			if g.Edges_info[br_k].NOutchans == 1 {
-				b.Start = br_k
-				if g.Edges_info[br_k].NInchans <= 1 {
-					g.Edges_info[br_k].br = b
-				}
-			} else {
-				b.Start = c_k
-				g.Edges_info[c_k].br = b
+	for name, _ := range g.Nodes_map {
+		if !g.mergeable(name) || g.mergeable(g.upstream(name)) {
+			continue
+		}
+		b := &Branch{Start: name, Br_start: g.upstream(name),
+			Nodes: []string{name}, G: g, mutex: &sync.Mutex{}}
+		for {
+			b.Br_end = g.downstream(b.Nodes[len(b.Nodes)-1])
+			if !g.mergeable(b.Br_end) {
+				break
 			}
-			b.Nodes = []string{b.Start}
-			b.End = c_k
-			g.traverseBranch(c_k, b)
-			g.Branches = append(g.Branches, b)
+			b.Nodes = append(b.Nodes, b.Br_end)
+		}
+		if g.isHead(b.Br_end) {
+			b.Nodes = append(b.Nodes, b.Br_end)
+		}
+		if len(b.Nodes) < 2 {
+			continue
 		}
+		b.End = b.Nodes[len(b.Nodes)-1]
+		if g.Get(b.End)._type == OP_GROUND {
+			b.Gnd = b.End
+		}
+		for _, n := range b.Nodes {
+			g.Edges_info[n].br = b
+		}
+		g.Branches = append(g.Branches, b)
 	}
 	// assign grounds
 	for k, _ := range g.gnd_nodes {
@@ -395,8 +463,10 @@ func (g *OGraph) scan() {
 	for _, b := range g.Branches {
 		// create stats
 		b.Stats = make([]*SStats, len(b.Nodes))
+		b.Ids = make([]uint64, len(b.Nodes))
 		for i := 0; i < len(b.Nodes); i++ {
 			b.Stats[i] = &SStats{gem.Point{0, 0}, gem.Point{0, 0}, 0}
+			b.Ids[i] = g.Get(b.Nodes[i]).Id
 		}
 		if brs, ok := g.GndBranches[b.Gnd]; ok {
 			g.GndBranches[b.Gnd] = append(brs, b)
@@ -408,41 +478,60 @@ func (g *OGraph) scan() {
 	}
 }
 
-func (g *OGraph) traverseBranch(c string, br *Branch) {
-	nodes := g.Neighbors(g.Nodes_map[c])
-	for _, n := range nodes {
-		n_k := (*n.Value).(*Processor).Name
-		if g.Edges_info[n_k].NOutchans > 1 {
-			br.Br_end = n_k
-			if g.Edges_info[n_k].NInchans <= 1 {
-				br.End = n_k
-			} else {
-				br.End = c
-			}
-			if br.End != br.Start && br.End != br.Nodes[len(br.Nodes)-1] {
-				br.Nodes = append(br.Nodes, br.End)
-			}
-			return
-		} else if g.Edges_info[n_k].NOutchans == 0 {
-			// we identified ground
-			g.Edges_info[n_k].br = br
-			br.End = n_k
-			br.Gnd = n_k
-			if br.End != br.Start {
-				br.Nodes = append(br.Nodes, br.End)
-			}
-			return
-		}
-		g.Edges_info[n_k].br = br
-		br.Nodes = append(br.Nodes, n_k)
-		g.traverseBranch(n_k, br)
+// mergeable reports whether the processor `name` can be pushed onto
+// the InStack of another processor, a Map or a Reduce with a single
+// input and a single output.
+func (g *OGraph) mergeable(name string) bool {
+	if name == "" {
+		return false
+	}
+	proc, e_info := g.Get(name), g.Edges_info[name]
+	return (proc._type == OP_MAP || proc._type == OP_REDUCE) && !proc.IsComposite &&
+		e_info.NInchans == 1 && e_info.NOutchans == 1
+}
+
+// isHead reports whether the processor `name` can be the head of a
+// group. It reads its single input through the processor on every
+// message and runs its InStack.
+func (g *OGraph) isHead(name string) bool {
+	if name == "" {
+		return false
+	}
+	switch proc := g.Get(name); proc._type {
+	case OP_GROUND, OP_FILTER, OP_COPYN, OP_PARALLEL:
+		return !proc.IsComposite && g.Edges_info[name].NInchans == 1
+	}
+	return false
+}
+
+// upstream returns the processor writing to the single input of
+// `name` or an empty name.
+func (g *OGraph) upstream(name string) string {
+	if g.Edges_info[name].NInchans != 1 {
+		return ""
+	}
+	for u, _ := range g.Edges_info[name].Chans {
+		return u
+	}
+	return ""
+}
+
+// downstream returns the processor reading the single output of
+// `name` or an empty name.
+func (g *OGraph) downstream(name string) string {
+	if g.Edges_info[name].NOutchans != 1 {
+		return ""
+	}
+	for _, n := range g.Neighbors(g.Nodes_map[name]) {
+		return (*n.Value).(*Processor).Name
 	}
+	return ""
 }
 
 func (g *OGraph) assignGrnds(c, gnd string) {
 	c_info := g.Edges_info[c]
 	c_proc := (*g.Nodes_map[c].Value).(*Processor)
-	if c_info.NInchans <= 1 && c_info.NOutchans <= 1 {
+	if c_info.NInchans <= 1 && c_info.NOutchans <= 1 && c_info.br != nil {
 		c_info.br.Gnd = gnd
 	}
 	if c_proc._type == OP_SOURCE {
@@ -471,6 +560,8 @@ func (g *OGraph) assignGrnds(c, gnd string) {
 	}
 }
 
+// monitor joins `group` and schedules the branches every SchInt
+// until all processors of the graph finished.
 func (g *OGraph) monitor(group *sync.WaitGroup) {
 	// implement the monitor task
 	d, err := time.ParseDuration(fmt.Sprintf("%fms", g.SchInt))
@@ -480,12 +571,23 @@ func (g *OGraph) monitor(group *sync.WaitGroup) {
 	g.monProc = g.NewProcessor(nil, nil, OP_MISC)
 	g.monProc.Name = "Monitor"
 	g.monProc.F = func(inputs ...chan T) []chan T {
+		done := make(chan bool)
+		go func() {
+			g.group.Wait()
+			close(done)
+		}()
 		group.Add(1)
 		go func() {
 			defer group.Done()
-			ticks := time.Tick(d)
-			for _ = range ticks {
-				g.scheduleBranch()
+			ticks := time.NewTicker(d)
+			defer ticks.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticks.C:
+					g.scheduleGraph()
+				}
 			}
 		}()
 		return g.monProc.Outputs
@@ -493,88 +595,142 @@ func (g *OGraph) monitor(group *sync.WaitGroup) {
 	g.monProc.F()
 }
 
-func (g *OGraph) scheduleBranch() {
+// scheduleGraph divides the NumCpu budget across all branches of the
+// graph instead of giving every branch the whole budget. Each branch
+// starts with one cpu and the remaining cpus are handed out greedily
+// to the branch with the largest reduction of its bottleneck relative
+// to its own period target. A branch that meets its period target gets
+// no extra cpus, so it keeps the fewest groups and the lowest latency.
+func (g *OGraph) scheduleGraph() {
+	n := len(g.Branches)
+	if n == 0 {
+		return
+	}
 	var (
-		L, P float64
-		S    []int
-		// B    float64
-		feas bool
+		W      [][]float64 = make([][]float64, n)
+		K      []int       = make([]int, n)
+		B      []float64   = make([]float64, n)
+		TP     []float64   = make([]float64, n)
+		budget int         = g.NumCpu - n
 	)
-
-	for _, b := range g.Branches {
-		L, P = 0, 0
-		S, feas = nil, false
-		T := make([]float64, len(b.Stats))
-		for i, s := range b.Stats {
-			m := s.Mean()
-			L += m[0] + m[1]
-			P = math.Max(P, m[0])
-			T[i] = m[0]
-		}
-		b.L, b.P = L, P
-		// solve according to the given values
-		if (P < g.TP && L < g.TL) || b.Groups != nil {
-			continue
-		}
-		W := prefixSum(T)
-		for K := g.NumCpu; K > 0; K-- {
-			Bopt := calcBottleNeck(W, K)
-			if Bopt > g.TP {
-				// This K is not feasible solution
-				break
+	P, L := make([]float64, n), make([]float64, n)
+	for i, b := range g.Branches {
+		var Ti []float64
+		Ti, L[i], P[i] = b.times()
+		W[i] = prefixSum(Ti)
+		_, TP[i] = g.branchTargets(b)
+		K[i], B[i] = 1, W[i][len(W[i])-1]
+	}
+	for ; budget > 0; budget-- {
+		best, gain := -1, 0.0
+		for i := range g.Branches {
+			if K[i] >= len(W[i]) || B[i] <= TP[i] {
+				continue
 			}
-			Sopt, ok := probe(W, Bopt, K)
-			if !ok {
-				// This K is not feasible solution
-				break
+			if d := (B[i] - calcBottleNeck(W[i], K[i]+1)) / TP[i]; d > gain {
+				best, gain = i, d
 			}
-			S = Sopt
-			// B = Bopt
-			feas = true
-		}
-		if feas {
-			// make a new schedule
-			nth := 0
-			for i, s := range S {
-				if i > 0 && s == 0 {
-					break
-				}
-				nth++
+		}
+		if best < 0 {
+			break
+		}
+		K[best]++
+		B[best] = calcBottleNeck(W[best], K[best])
+	}
+	for i, b := range g.Branches {
+		TL, _ := g.branchTargets(b)
+		if b.Groups == nil && P[i] < TP[i] && L[i] < TL {
+			continue
+		}
+		S, ok := probe(W[i], B[i], K[i])
+		if !ok {
+			continue
+		}
+		// a branch left with one cpu above its period target would
+		// only get slower in a single goroutine
+		apply := K[i] < len(W[i]) && (K[i] > 1 || B[i] <= TP[i])
+		if b.Groups != nil {
+			if apply && len(b.Groups) == groupsCount(S) {
+				continue
 			}
-			// buid groups
-			b.Wait()
-			b.Groups = make([]*NodesGroup, nth)
-			for i := 0; i < nth; i++ {
-				s, e := S[i], 0
-				if i < nth-1 {
-					e = S[i+1] - 1
-				} else {
-					e = len(T) - 1
-				}
+			g.splitBranch(b)
+		}
+		if apply {
+			g.applySchedule(b, S)
+		}
+	}
+}
 
-				if e == s {
-					// just resume
-					pm := g.Get(b.Nodes[s])
-					pm.Resume(ST_RUN)
-					continue
-				}
-				ng := &NodesGroup{Start: b.Nodes[s],
-					End:   b.Nodes[e],
-					Nodes: make([]string, e-s+1),
-					Head:  b.Nodes[e]}
+// branchTargets returns the latency and period targets of branch `b`.
+// A node in the branch can tighten the graph thresholds TL and TP
+// using the OP_ATTRIB_TL and OP_ATTRIB_TP attributes.
+func (g *OGraph) branchTargets(b *Branch) (TL, TP float64) {
+	TL, TP = g.TL, g.TP
+	for _, n := range b.Nodes {
+		proc := g.Get(n)
+		if proc.TL > 0 {
+			TL = math.Min(TL, proc.TL)
+		}
+		if proc.TP > 0 {
+			TP = math.Min(TP, proc.TP)
+		}
+	}
+	return TL, TP
+}
 
-				for j := s; j <= e; j++ {
-					ng.Nodes[j-s] = b.Nodes[j]
-				}
-				g.mergeForward(b, s, e)
-				b.Groups[i] = ng
-			}
+// applySchedule merges the nodes of branch `b` into the groups
+// given by the partition starts `S`.
+func (g *OGraph) applySchedule(b *Branch, S []int) {
+	nth := groupsCount(S)
+	// buid groups
+	if !b.Wait() {
+		return
+	}
+	b.Groups = make([]*NodesGroup, nth)
+	for i := 0; i < nth; i++ {
+		s, e := S[i], 0
+		if i < nth-1 {
+			e = S[i+1] - 1
+		} else {
+			e = len(b.Nodes) - 1
 		}
+
+		if e == s {
+			// just resume
+			pm := g.Get(b.Nodes[s])
+			pm.Resume(ST_RUN)
+			continue
+		}
+		ng := &NodesGroup{Start: b.Nodes[s],
+			End:   b.Nodes[e],
+			Nodes: make([]string, e-s+1),
+			Head:  b.Nodes[e]}
+
+		for j := s; j <= e; j++ {
+			ng.Nodes[j-s] = b.Nodes[j]
+		}
+		g.mergeForward(b, s, e)
+		b.Groups[i] = ng
 	}
 }
 
-func (g *OGraph) scheduleGraph() {
+// groupsCount returns the number of groups in the partition starts `S`.
+func groupsCount(S []int) int {
+	nth := 0
+	for i, s := range S {
+		if i > 0 && s == 0 {
+			break
+		}
+		nth++
+	}
+	return nth
+}
 
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
 }
 
 func (g *OGraph) mergeForward(b *Branch, s int, e int) {
@@ -585,13 +741,49 @@ func (g *OGraph) mergeForward(b *Branch, s int, e int) {
 	for i := e - 1; i >= s; i-- {
 		pm := g.Get(b.Nodes[i])
 		eproc.InStack.Push(pm.ProcessorInfo)
+		pm.IsMerged = true
 		pm.Resume(ST_EXIT)
+		// its deferred calls still use the outputs
+		pm.running.Wait()
 	}
 	eproc.Resume(ST_RUN)
 }
 
+// mergeBackward is the inverse of mergeForward. It pops the processors
+// of the group `ng` off the InStack of its head, restarts their goroutines
+// on fresh channels and rewires the head to read from the last of them.
+// The branch must be in the wait state.
 func (g *OGraph) mergeBackward(b *Branch, ng *NodesGroup, e int, s int) {
+	eproc := g.Get(b.Nodes[e])
+	inputs := eproc.Inputs
+	for i := s; i < e; i++ {
+		pm := g.Get(b.Nodes[i])
+		if pi := eproc.InStack.Pop(); pi != pm.ProcessorInfo {
+			panic(fmt.Sprintf("Processor %s is not merged into group %s", pm.Name, ng.Head))
+		}
+		pm.Outputs[0] = make(chan T)
+		pm.ERStatus, pm.WRStatus = ST_RUN, ST_RESUME
+		// F clears IsMerged, a Reduce reads it to resume its state
+		inputs = pm.F(inputs...)[:1]
+	}
+	eproc.Inputs = inputs
+	eproc.Resume(ST_RUN)
+}
 
+// splitBranch undoes all groups of the branch `b` so that every
+// node runs again in its own goroutine.
+func (g *OGraph) splitBranch(b *Branch) {
+	if !b.Wait() {
+		return
+	}
+	for _, ng := range b.Groups {
+		if ng == nil {
+			continue
+		}
+		g.mergeBackward(b, ng, b.IndexOf(ng.End), b.IndexOf(ng.Start))
+	}
+	b.Groups = nil
+	b.Resume(ST_RUN)
 }
 
 //#################################################################
@@ -608,17 +800,27 @@ type Branch struct {
 	Start, End       string
 	Br_start, Br_end string
 	Nodes            []string
+	Ids              []uint64 // processor ids of the nodes
 	Gnd              string
 	Stats            []*SStats
 	Groups           []*NodesGroup
 	P, L             float64
 	G                *OGraph
+	mutex            *sync.Mutex // guards Stats, P and L
 }
 
-func (b *Branch) Wait() {
+// Wait pauses all nodes of the branch. It returns false when the
+// stream already ended and the start of the branch stopped reading.
+func (b *Branch) Wait() (ok bool) {
+	defer func() {
+		// the upstream closed the input of the start
+		if recover() != nil {
+			ok = false
+		}
+	}()
 	// Send Wait signals
-	d, _ := time.ParseDuration("0.0001ms")
-	ticks := time.Tick(d)
+	ticks := time.NewTicker(100 * time.Nanosecond)
+	defer ticks.Stop()
 	proc := b.G.Get(b.Start)
 	proc.Inputs[0] <- &cM{start: b.Start, end: b.End,
 		ERStatus: ST_RUN,
@@ -626,24 +828,58 @@ func (b *Branch) Wait() {
 	// wait until all nodes enter the wait state
 	for _, n := range b.Nodes {
 		proc = b.G.Get(n)
-		for proc.WRStatus != ST_WAIT {
-			<-ticks
+		if proc.IsMerged {
+			continue
+		}
+		for proc.status() != ST_WAIT {
+			<-ticks.C
 		}
 	}
+	return true
 }
 
 func (b *Branch) Resume(newERState int) {
 	// Send Wait signals
 	// wait until all nodes enter the wait state
-	d, _ := time.ParseDuration("0.0001ms")
-	ticks := time.Tick(d)
+	ticks := time.NewTicker(100 * time.Nanosecond)
+	defer ticks.Stop()
 	for _, n := range b.Nodes {
 		proc := b.G.Get(n)
+		if proc.IsMerged {
+			continue
+		}
 		proc.Resume(newERState)
-		for proc.WRStatus != ST_RESUME {
-			<-ticks
+		for proc.status() != ST_RESUME {
+			<-ticks.C
+		}
+	}
+}
+
+// times returns the mean processing time of every node in the
+// branch, the branch latency `L` and period `P`, which it updates.
+func (b *Branch) times() (T []float64, L, P float64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	T = make([]float64, len(b.Stats))
+	for i, s := range b.Stats {
+		m := s.Mean()
+		L += m[0] + m[1]
+		P = math.Max(P, m[0])
+		T[i] = m[0]
+	}
+	b.L, b.P = L, P
+	return T, L, P
+}
+
+// IndexOf returns the position of the node `name` in the
+// branch or -1 if the node is not part of the branch.
+func (b *Branch) IndexOf(name string) int {
+	for i, n := range b.Nodes {
+		if n == name {
+			return i
 		}
 	}
+	return -1
 }
 
 func (b *Branch) Close() {
@@ -672,11 +908,14 @@ func AccumulateStats(brs []*Branch, alpha, dt float64, x T) {
 		return
 	}
 	for _, b := range brs {
-		for i, op := range b.Nodes {
+		b.mutex.Lock()
+		for i, id := range b.Ids {
 			var s1, s2 float64 = 0, 0
-			s1 = xc.TmInfo[op].OutTime.Sub(xc.TmInfo[op].InTime).Seconds() * 1000
-			if i < len(b.Nodes)-1 {
-				s2 = xc.TmInfo[b.Nodes[i+1]].InTime.Sub(xc.TmInfo[op].OutTime).Seconds() * 1000
+			op, _ := xc.TmInfo.Get(id)
+			s1 = op.OutTime.Sub(op.InTime).Seconds() * 1000
+			if i < len(b.Ids)-1 {
+				next, _ := xc.TmInfo.Get(b.Ids[i+1])
+				s2 = next.InTime.Sub(op.OutTime).Seconds() * 1000
 			}
 			if dt > 0 {
 				b.Stats[i].Decay(alpha, dt)
@@ -684,5 +923,6 @@ func AccumulateStats(brs []*Branch, alpha, dt float64, x T) {
 
 			b.Stats[i].AddVal(gem.Point{s1, s2}, gem.Point{s1 * s1, s2 * s2}, 1)
 		}
+		b.mutex.Unlock()
 	}
 }
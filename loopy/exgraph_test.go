@@ -0,0 +1,130 @@
+package loopy
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type chanSpout chan T
+
+func (s chanSpout) Read() T {
+	return <-s
+}
+
+func TestMergeSplit(t *testing.T) {
+	g := NewOGraph()
+	in := make(chanSpout)
+	double := &Function{FuncName: "double", Mapper: func(x T, params Params) T {
+		m := x.(*M)
+		m.Value = MessageV(x).(testValue) * 2
+		return m
+	}}
+	count := &Function{FuncName: "count", Reducer: func(u T, x T, params Params) (T, T) {
+		return u.(int) + 1, x
+	}}
+	var seen []testValue
+	collect := &Function{FuncName: "collect", Mapper: func(x T, params Params) T {
+		seen = append(seen, MessageV(x).(testValue))
+		return x
+	}}
+	g.Source(in, "src").Map(Functions{double}, "double").Reduce(0, Functions{count}, "count").
+		Map(Functions{collect}, "collect").Ground("gnd")
+	g.scan()
+	if len(g.Branches) != 1 {
+		t.Fatalf("expected 1 branch, got %d", len(g.Branches))
+	}
+	b := g.Branches[0]
+	if nodes := []string{"double", "count", "collect", "gnd"}; !reflect.DeepEqual(b.Nodes, nodes) {
+		t.Fatalf("expected branch %v, got %v", nodes, b.Nodes)
+	}
+	g.Execute()
+
+	n := 0
+	send := func(k int) {
+		for i := 0; i < k; i++ {
+			n++
+			in <- NewMessage(testValue(n))
+		}
+	}
+	for _, S := range [][]int{{0}, {0, 2}, {0, 1, 3}} {
+		send(50)
+		g.applySchedule(b, S)
+		if len(b.Groups) != groupsCount(S) {
+			t.Fatalf("schedule %v: expected %d groups, got %d", S, groupsCount(S), len(b.Groups))
+		}
+		for _, ng := range b.Groups {
+			if ng == nil {
+				continue
+			}
+			for _, name := range ng.Nodes {
+				if merged := g.Get(name).IsMerged; merged != (name != ng.Head) {
+					t.Errorf("schedule %v: %s merged %v", S, name, merged)
+				}
+			}
+		}
+		send(50)
+		g.splitBranch(b)
+		for _, name := range b.Nodes {
+			if g.Get(name).IsMerged {
+				t.Errorf("schedule %v: %s still merged after the split", S, name)
+			}
+		}
+	}
+	send(50)
+	close(in)
+	g.Wait()
+
+	if len(seen) != n {
+		t.Fatalf("expected %d messages, got %d", n, len(seen))
+	}
+	for i, v := range seen {
+		if v != testValue(2*(i+1)) {
+			t.Fatalf("message %d is %d, expected %d", i, v, 2*(i+1))
+		}
+	}
+	if u := g.Get("count").Funcs[0].State; u != n {
+		t.Errorf("expected the reducer to count %d messages, got %v", n, u)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	g := NewOGraph()
+	g.Schedule, g.SchInt = true, 10
+	// the latency target forces a merge, the period target allows it
+	g.TL, g.TP = 1e-6, 1000
+	in := make(chanSpout)
+	pass := &Function{FuncName: "pass", Mapper: func(x T, params Params) T {
+		return x
+	}}
+	var seen []testValue
+	collect := &Function{FuncName: "collect", Mapper: func(x T, params Params) T {
+		seen = append(seen, MessageV(x).(testValue))
+		return x
+	}}
+	g.Source(in, "src").Map(Functions{pass}, "pass").Map(Functions{collect}, "collect").Ground("gnd")
+	g.Execute()
+	go func() {
+		defer close(in)
+		for i := 1; i <= 200; i++ {
+			in <- NewMessage(testValue(i))
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	g.Wait()
+
+	if len(seen) != 200 {
+		t.Fatalf("expected 200 messages, got %d", len(seen))
+	}
+	for i, v := range seen {
+		if v != testValue(i+1) {
+			t.Fatalf("message %d is %d, expected %d", i, v, i+1)
+		}
+	}
+	if len(g.Branches) != 1 || len(g.Branches[0].Groups) != 1 {
+		t.Fatalf("expected the branch to be merged into one group")
+	}
+	if !g.Get("pass").IsMerged || !g.Get("collect").IsMerged {
+		t.Errorf("expected the maps to be merged into the ground")
+	}
+}
@@ -0,0 +1,269 @@
+package loopy
+
+import (
+	"gem"
+	"math"
+	"sort"
+	"sync"
+)
+
+//#################################################################
+//                   Regions
+//#################################################################
+
+// Region is a named planar area, the polygon (X; Y) when X is set and
+// the rectangle Rect otherwise. A Region with neither removes the
+// region of the same Id when sent on the region stream of a
+// SpatialJoin or Geofence processor.
+type Region struct {
+	Id   string
+	Rect *gem.Rect
+	X, Y []float64
+	bb   *gem.Rect
+}
+
+func NewRectRegion(id string, r *gem.Rect) *Region {
+	return &Region{Id: id, Rect: r, bb: r}
+}
+
+func NewPolyRegion(id string, x, y []float64) *Region {
+	r := &Region{Id: id, X: x, Y: y}
+	r.bb = polyBounds(x, y)
+	return r
+}
+
+func polyBounds(x, y []float64) *gem.Rect {
+	lo, hi := gem.Point{x[0], y[0]}, gem.Point{x[0], y[0]}
+	for i := range x {
+		lo[0], hi[0] = math.Min(lo[0], x[i]), math.Max(hi[0], x[i])
+		lo[1], hi[1] = math.Min(lo[1], y[i]), math.Max(hi[1], y[i])
+	}
+	return &gem.Rect{C: gem.Point{(lo[0] + hi[0]) / 2, (lo[1] + hi[1]) / 2},
+		P: gem.Point{(hi[0] - lo[0]) / 2, (hi[1] - lo[1]) / 2}}
+}
+
+func (r *Region) Bounds() *gem.Rect {
+	if r.bb == nil {
+		if r.X != nil {
+			r.bb = polyBounds(r.X, r.Y)
+		} else {
+			r.bb = r.Rect
+		}
+	}
+	return r.bb
+}
+
+func (r *Region) empty() bool {
+	return r.X == nil && r.Rect == nil
+}
+
+// ContainsPoint tests whether `p` lies in the region, on the boundary
+// for rectangles.
+func (r *Region) ContainsPoint(p gem.Point) bool {
+	if r.X == nil {
+		return r.Rect.ContainsPoint(p)
+	}
+	return r.Bounds().ContainsPoint(p) && gem.IsPointInPoly(r.X, r.Y, p[0], p[1])
+}
+
+// IntersectsRect tests whether the region and `q` overlap.
+func (r *Region) IntersectsRect(q *gem.Rect) bool {
+	if gem.Intersect(r.Bounds(), q) == nil {
+		return false
+	}
+	if r.X == nil {
+		return true
+	}
+	// a vertex of one inside the other or crossing edges
+	if r.ContainsPoint(q.C) {
+		return true
+	}
+	for i := range r.X {
+		if q.ContainsPoint(gem.Point{r.X[i], r.Y[i]}) {
+			return true
+		}
+	}
+	x0, y0, x1, y1 := q.C[0]-q.P[0], q.C[1]-q.P[1], q.C[0]+q.P[0], q.C[1]+q.P[1]
+	return gem.IsLineIntersectingPoly(r.X, r.Y, x0, y0, x1, y0) ||
+		gem.IsLineIntersectingPoly(r.X, r.Y, x1, y0, x1, y1) ||
+		gem.IsLineIntersectingPoly(r.X, r.Y, x1, y1, x0, y1) ||
+		gem.IsLineIntersectingPoly(r.X, r.Y, x0, y1, x0, y0)
+}
+
+func (r *Region) Dispose() {}
+
+// RegionSet is a set of regions indexed by an R-tree. It is safe for
+// concurrent use, so it can also be updated from outside the graph.
+type RegionSet struct {
+	regions map[string]*Region
+	tree    *gem.Rtree
+	mutex   *sync.RWMutex
+}
+
+func NewRegionSet(regions ...*Region) *RegionSet {
+	s := &RegionSet{mutex: &sync.RWMutex{}}
+	s.Replace(regions)
+	return s
+}
+
+// Set adds `r` or replaces the region with the same Id, a region
+// without a shape removes it.
+func (s *RegionSet) Set(r *Region) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if old, ok := s.regions[r.Id]; ok {
+		s.tree.Delete(old)
+		delete(s.regions, r.Id)
+	}
+	if !r.empty() {
+		s.regions[r.Id] = r
+		s.tree.Insert(r)
+	}
+}
+
+// Remove deletes the region `id` and reports whether it existed.
+func (s *RegionSet) Remove(id string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	r, ok := s.regions[id]
+	if ok {
+		s.tree.Delete(r)
+		delete(s.regions, id)
+	}
+	return ok
+}
+
+// Replace swaps the whole set for `regions`.
+func (s *RegionSet) Replace(regions []*Region) {
+	m := make(map[string]*Region, len(regions))
+	objs := make([]gem.Spatial, 0, len(regions))
+	for _, r := range regions {
+		if _, ok := m[r.Id]; !ok && !r.empty() {
+			m[r.Id] = r
+			objs = append(objs, r)
+		}
+	}
+	tree := gem.NewRtree(2, 0, 16, objs...)
+	s.mutex.Lock()
+	s.regions, s.tree = m, tree
+	s.mutex.Unlock()
+}
+
+func (s *RegionSet) Len() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.regions)
+}
+
+// Match returns the regions containing the gem.Point or gem.Point2D
+// `shape`, or intersecting the *gem.Rect `shape`, ordered by Id.
+func (s *RegionSet) Match(shape T) []*Region {
+	var (
+		found []gem.Spatial
+		test  func(r *Region) bool
+	)
+	s.mutex.RLock()
+	switch v := shape.(type) {
+	case gem.Point2D:
+		p := gem.Point{float64(v.X()), float64(v.Y())}
+		found, test = s.tree.SearchPoint(p), func(r *Region) bool { return r.ContainsPoint(p) }
+	case gem.Point:
+		found, test = s.tree.SearchPoint(v), func(r *Region) bool { return r.ContainsPoint(v) }
+	case *gem.Rect:
+		found, test = s.tree.SearchIntersect(v), func(r *Region) bool { return r.IntersectsRect(v) }
+	}
+	s.mutex.RUnlock()
+	ret := make([]*Region, 0, len(found))
+	for _, o := range found {
+		if r := o.(*Region); test(r) {
+			ret = append(ret, r)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Id < ret[j].Id })
+	return ret
+}
+
+//#################################################################
+//                   Geofencing
+//#################################################################
+
+// Kinds of geofence events.
+const (
+	GEO_ENTER int = iota
+	GEO_EXIT
+)
+
+// GeoEvent reports that the tracked Key entered or left Region at
+// Shape.
+type GeoEvent struct {
+	Kind   int
+	Key    string
+	Region *Region
+	Shape  T
+}
+
+func (e *GeoEvent) Dispose() {}
+
+// GeoSpec configures the SpatialJoin and Geofence processors. Shape
+// extracts the gem.Point, gem.Point2D or *gem.Rect of a message, nil
+// uses the message value. Key extracts the tracked key of a Geofence
+// message. A nil Regions is replaced by an empty set. Keep makes a
+// SpatialJoin write also the messages that match no region.
+type GeoSpec struct {
+	Shape   func(x T) T
+	Key     func(x T) string
+	Regions *RegionSet
+	Keep    bool
+}
+
+func (spec *GeoSpec) shape(x T) T {
+	if spec.Shape == nil {
+		return MessageV(x)
+	}
+	return spec.Shape(x)
+}
+
+// fence holds the regions every tracked key is in.
+type fence map[string]map[string]*Region
+
+// update moves `key` to `regions` and returns the exit events followed
+// by the enter events.
+func (f fence) update(key string, regions []*Region, shape T) []*GeoEvent {
+	var exits, enters []*GeoEvent
+	prev := f[key]
+	cur := make(map[string]*Region, len(regions))
+	for _, r := range regions {
+		cur[r.Id] = r
+		if _, ok := prev[r.Id]; !ok {
+			enters = append(enters, &GeoEvent{GEO_ENTER, key, r, shape})
+		}
+	}
+	for id, r := range prev {
+		if _, ok := cur[id]; !ok {
+			exits = append(exits, &GeoEvent{GEO_EXIT, key, r, shape})
+		}
+	}
+	sort.Slice(exits, func(i, j int) bool { return exits[i].Region.Id < exits[j].Region.Id })
+	if len(cur) == 0 {
+		delete(f, key)
+	} else {
+		f[key] = cur
+	}
+	return append(exits, enters...)
+}
+
+// readRegions applies the regions read from `in` to `set` until the
+// channel is closed.
+func (g *OGraph) readRegions(proc *Processor, in chan T, set *RegionSet) {
+	g.group.Add(1)
+	go func() {
+		defer g.group.Done()
+		for y := range in {
+			if _, ok := y.(*cM); ok || y == nil {
+				continue
+			}
+			proc.AddTimeInfo(PROC_BOTH_TIME, y)
+			set.Set(MessageV(y).(*Region))
+		}
+	}()
+}
@@ -0,0 +1,132 @@
+package loopy
+
+import (
+	"fmt"
+	"gem"
+	"strings"
+	"testing"
+	"time"
+)
+
+type sliceSpout struct {
+	values []T
+	n      int
+}
+
+func (s *sliceSpout) Read() T {
+	if s.n >= len(s.values) {
+		return nil
+	}
+	s.n++
+	return NewMessage(s.values[s.n-1])
+}
+
+type position struct {
+	key string
+	p   gem.Point
+}
+
+func (p *position) Dispose() {}
+
+func testRegions() *RegionSet {
+	return NewRegionSet(
+		NewRectRegion("box", &gem.Rect{C: gem.Point{5, 5}, P: gem.Point{5, 5}}),
+		NewPolyRegion("tri", []float64{8, 20, 20}, []float64{8, 8, 20}))
+}
+
+func ids(regions []*Region) string {
+	s := make([]string, len(regions))
+	for i, r := range regions {
+		s[i] = r.Id
+	}
+	return strings.Join(s, ",")
+}
+
+func TestRegionSetMatch(t *testing.T) {
+	s := testRegions()
+	tests := []struct {
+		shape T
+		ids   string
+	}{
+		{gem.Point{1, 1}, "box"},
+		{gem.Point{10, 10}, "box"}, // boundary of the box, outside the triangle
+		{gem.Point{9, 8.5}, "box,tri"},
+		{gem.Point{10, 15}, ""},
+		{gem.Point2D{15, 10}, "tri"},
+		{&gem.Rect{C: gem.Point{15, 2}, P: gem.Point{1, 1}}, ""},
+		{&gem.Rect{C: gem.Point{19, 9}, P: gem.Point{2, 2}}, "tri"},
+		{&gem.Rect{C: gem.Point{9, 7}, P: gem.Point{2, 2}}, "box,tri"},
+	}
+	for _, tt := range tests {
+		if got := ids(s.Match(tt.shape)); got != tt.ids {
+			t.Errorf("%v matches %q, expected %q", tt.shape, got, tt.ids)
+		}
+	}
+	s.Set(NewRectRegion("box", &gem.Rect{C: gem.Point{30, 30}, P: gem.Point{1, 1}}))
+	if got := ids(s.Match(gem.Point{1, 1})); got != "" || s.Len() != 2 {
+		t.Errorf("replaced region still matches: %q", got)
+	}
+	if !s.Remove("tri") || s.Remove("tri") || s.Len() != 1 {
+		t.Errorf("remove failed, %d regions", s.Len())
+	}
+}
+
+func TestGeofence(t *testing.T) {
+	path := []gem.Point{{1, 1}, {2, 2}, {9, 8.5}, {15, 10}, {30, 30}}
+	var values []T
+	for _, p := range path {
+		values = append(values, &position{"a", p}, &position{"b", gem.Point{1, 1}})
+	}
+	spec := GeoSpec{Shape: func(x T) T { return MessageV(x).(*position).p },
+		Key: func(x T) string { return MessageV(x).(*position).key }, Regions: testRegions()}
+	var events []string
+	collect := &Function{FuncName: "collect", Reducer: func(u, x T, params Params) (T, T) {
+		e := MessageV(x).(*GeoEvent)
+		events = append(events, fmt.Sprintf("%s%d%s", e.Key, e.Kind, e.Region.Id))
+		return u, x
+	}}
+	g := NewOGraph()
+	g.Source(&sliceSpout{values: values}).Geofence(spec).Reduce(nil, Functions{collect}).Ground()
+	g.Execute()
+	g.Wait()
+	expected := "a0box b0box a0tri a1box a1tri"
+	if got := strings.Join(events, " "); got != expected {
+		t.Errorf("events %q, expected %q", got, expected)
+	}
+}
+
+func TestSpatialJoinRegionStream(t *testing.T) {
+	points := make([]T, 20)
+	for i := range points {
+		points[i] = gem.Point{float64(i), 0}
+	}
+	regions := NewRegionSet()
+	spec := GeoSpec{Shape: func(x T) T { return points[int(MessageV(x).(testValue))-1] }, Regions: regions}
+	g := NewOGraph()
+	side := g.Source(&sliceSpout{values: []T{
+		NewRectRegion("a", &gem.Rect{C: gem.Point{0, 0}, P: gem.Point{5, 5}}),
+		NewRectRegion("b", &gem.Rect{C: gem.Point{10, 0}, P: gem.Point{1, 1}}),
+		&Region{Id: "b"},
+		NewRectRegion("c", &gem.Rect{C: gem.Point{50, 50}, P: gem.Point{1, 1}})}})
+	// let the region stream end before the main stream starts
+	done := make(chan bool)
+	m := g.Source(&gatedSpout{testSpout{max: 20}, done})
+	j := g.SpatialJoin(spec)
+	g.LinkIn(j.Proc.Name, m.Proc.Name, side.Proc.Name)
+	var matched []string
+	collect := &Function{FuncName: "collect", Reducer: func(u, x T, params Params) (T, T) {
+		v := x.([]T)
+		matched = append(matched, fmt.Sprintf("%v:%s", MessageV(v[0]), ids(v[1].([]*Region))))
+		return u, x
+	}}
+	j.Reduce(nil, Functions{collect}).Ground()
+	g.Execute()
+	for len(regions.Match(gem.Point{50, 50})) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(done)
+	g.Wait()
+	if got := strings.Join(matched, " "); got != "1:a 2:a 3:a 4:a 5:a 6:a" {
+		t.Errorf("matched %q", got)
+	}
+}
@@ -0,0 +1,139 @@
+package loopy
+
+import (
+	"container/heap"
+	"time"
+)
+
+//#################################################################
+//                   Interval Join
+//#################################################################
+
+const (
+	JOIN_INNER int = iota
+	JOIN_LEFT_OUTER
+	JOIN_FULL_OUTER
+)
+
+// JoinSpec configures an interval join. A left element x and a right
+// element y match when their keys are equal and their times differ by
+// at most Bound. Key extracts a comparable key from an element of the
+// given side (0 left, 1 right). Time returns the event time of an
+// element; when nil the arrival time at the operator is used.
+type JoinSpec struct {
+	Mode  int
+	Bound time.Duration
+	Key   func(x T, side int) T
+	Time  func(x T) time.Time
+}
+
+type joinEntry struct {
+	x       T
+	key     T
+	t       time.Time
+	side    int
+	matched bool
+}
+
+// joinQueue is a min heap of the buffered entries ordered by time.
+type joinQueue []*joinEntry
+
+func (q joinQueue) Len() int            { return len(q) }
+func (q joinQueue) Less(i, j int) bool  { return q[i].t.Before(q[j].t) }
+func (q joinQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *joinQueue) Push(x interface{}) { *q = append(*q, x.(*joinEntry)) }
+func (q *joinQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// joinState buffers both sides of an interval join. Every emitted pair
+// holds clones of the buffered elements, an element is handed over
+// (or disposed) only when it leaves the buffer.
+type joinState struct {
+	spec  JoinSpec
+	bufs  [2]map[T][]*joinEntry
+	queue joinQueue
+	wm    time.Time // watermark, the latest time seen
+	emit  func(v []T)
+}
+
+func newJoinState(spec JoinSpec, emit func(v []T)) *joinState {
+	return &joinState{spec: spec,
+		bufs: [2]map[T][]*joinEntry{make(map[T][]*joinEntry), make(map[T][]*joinEntry)},
+		emit: emit}
+}
+
+func (s *joinState) time(x T) time.Time {
+	if s.spec.Time != nil {
+		return s.spec.Time(x)
+	}
+	return time.Now()
+}
+
+// add matches `x` against the buffer of the other side, buffers it
+// and evicts the entries older than the watermark minus Bound.
+func (s *joinState) add(side int, x T) {
+	e := &joinEntry{x: x, key: s.spec.Key(x, side), t: s.time(x), side: side}
+	for _, o := range s.bufs[1-side][e.key] {
+		d := e.t.Sub(o.t)
+		if d < 0 {
+			d = -d
+		}
+		if d > s.spec.Bound {
+			continue
+		}
+		e.matched, o.matched = true, true
+		v := make([]T, 2)
+		v[side], v[1-side] = DeepClone(x), DeepClone(o.x)
+		s.emit(v)
+	}
+	s.bufs[side][e.key] = append(s.bufs[side][e.key], e)
+	heap.Push(&s.queue, e)
+	if e.t.After(s.wm) {
+		s.wm = e.t
+	}
+	s.expire(s.wm)
+}
+
+// expire evicts the entries that can no longer match an element
+// at or after `wm`.
+func (s *joinState) expire(wm time.Time) {
+	limit := wm.Add(-s.spec.Bound)
+	for len(s.queue) > 0 && s.queue[0].t.Before(limit) {
+		s.evict(heap.Pop(&s.queue).(*joinEntry))
+	}
+}
+
+// flush evicts all entries.
+func (s *joinState) flush() {
+	for len(s.queue) > 0 {
+		s.evict(heap.Pop(&s.queue).(*joinEntry))
+	}
+}
+
+func (s *joinState) evict(e *joinEntry) {
+	es := s.bufs[e.side][e.key]
+	for i, o := range es {
+		if o == e {
+			es = append(es[:i], es[i+1:]...)
+			break
+		}
+	}
+	if len(es) == 0 {
+		delete(s.bufs[e.side], e.key)
+	} else {
+		s.bufs[e.side][e.key] = es
+	}
+	outer := (e.side == 0 && s.spec.Mode != JOIN_INNER) ||
+		(e.side == 1 && s.spec.Mode == JOIN_FULL_OUTER)
+	if !e.matched && outer {
+		v := make([]T, 2)
+		v[e.side] = e.x
+		s.emit(v)
+	} else {
+		DeepDispose(e.x)
+	}
+}
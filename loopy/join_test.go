@@ -0,0 +1,92 @@
+package loopy
+
+import (
+	"testing"
+	"time"
+)
+
+type joinValue struct {
+	key int
+	t   int // seconds
+}
+
+func (v joinValue) Dispose() {}
+
+func TestJoinModes(t *testing.T) {
+	t0 := time.Now()
+	spec := JoinSpec{Bound: 5 * time.Second,
+		Key:  func(x T, side int) T { return MessageV(x).(joinValue).key },
+		Time: func(x T) time.Time { return t0.Add(time.Duration(MessageV(x).(joinValue).t) * time.Second) }}
+	input := []struct {
+		side int
+		v    joinValue
+	}{
+		{0, joinValue{1, 0}},
+		{1, joinValue{1, 3}},
+		{0, joinValue{2, 10}},
+		{1, joinValue{1, 12}},
+		{1, joinValue{3, 50}},
+	}
+	tests := []struct {
+		mode  int
+		pairs [][2]int // keys of the emitted pairs, -1 for nil
+	}{
+		{JOIN_INNER, [][2]int{{1, 1}}},
+		{JOIN_LEFT_OUTER, [][2]int{{1, 1}, {2, -1}}},
+		{JOIN_FULL_OUTER, [][2]int{{1, 1}, {2, -1}, {-1, 1}, {-1, 3}}},
+	}
+	key := func(x T) int {
+		if x == nil {
+			return -1
+		}
+		return MessageV(x).(joinValue).key
+	}
+	for _, tt := range tests {
+		spec.Mode = tt.mode
+		var pairs [][2]int
+		s := newJoinState(spec, func(v []T) {
+			pairs = append(pairs, [2]int{key(v[0]), key(v[1])})
+		})
+		for _, in := range input {
+			s.add(in.side, NewMessage(in.v))
+		}
+		s.flush()
+		if len(pairs) != len(tt.pairs) {
+			t.Errorf("mode %d: got pairs %v, expected %v", tt.mode, pairs, tt.pairs)
+			continue
+		}
+		for i := range pairs {
+			if pairs[i] != tt.pairs[i] {
+				t.Errorf("mode %d: got pairs %v, expected %v", tt.mode, pairs, tt.pairs)
+				break
+			}
+		}
+		if len(s.queue) != 0 || len(s.bufs[0]) != 0 || len(s.bufs[1]) != 0 {
+			t.Errorf("mode %d: state not cleaned up", tt.mode)
+		}
+	}
+}
+
+func TestJoinGraph(t *testing.T) {
+	g := NewOGraph()
+	spec := JoinSpec{Mode: JOIN_INNER, Bound: time.Minute,
+		Key: func(x T, side int) T { return int(MessageV(x).(testValue)) % 5 }}
+	n := 0
+	count := &Function{FuncName: "count", Reducer: func(u T, x T, params Params) (T, T) {
+		v := x.([]T)
+		if int(MessageV(v[0]).(testValue))%5 != int(MessageV(v[1]).(testValue))%5 {
+			t.Errorf("pair %v has different keys", v)
+		}
+		n++
+		return u, x
+	}}
+	left, right := g.Source(&testSpout{max: 10}), g.Source(&testSpout{max: 5})
+	j := g.Join(spec)
+	g.LinkIn(j.Proc.Name, left.Proc.Name, right.Proc.Name)
+	j.Reduce(nil, Functions{count}).Ground()
+	g.Execute()
+	g.Wait()
+	if n != 10 {
+		t.Errorf("expected 10 pairs, got %d", n)
+	}
+}
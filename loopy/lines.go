@@ -0,0 +1,67 @@
+package loopy
+
+import (
+	"gem"
+	"math"
+)
+
+//#################################################################
+//                   Line Detection
+//#################################################################
+
+// LineResult holds the lines detected among Points, the inliers of
+// the fits indexing Points.
+type LineResult struct {
+	Points []gem.Point
+	Lines  []*gem.LineFit
+}
+
+func (r *LineResult) Dispose() {}
+
+func lineMessage(x T, r *LineResult) T {
+	y := NewMessage(r)
+	InheritHeader(x, []T{y})
+	return y
+}
+
+// RansacFunction returns a mapper that detects up to "lines" lines
+// among the points extracted by `points` with sequential RANSAC and
+// writes a *LineResult. The parameters "threshold", "iterations" and
+// "inliers", the minimum number of inliers of a line, can be tuned at
+// runtime.
+func RansacFunction(points func(T) []gem.Point, lines int, threshold float64, iterations, inliers int) *Function {
+	return &Function{FuncName: "ransac",
+		FuncParams: Params{"lines": Parameter{float64(lines), 1, math.MaxInt32},
+			"threshold":  Parameter{threshold, 0, math.MaxFloat64},
+			"iterations": Parameter{float64(iterations), 1, math.MaxInt32},
+			"inliers":    Parameter{float64(inliers), 2, math.MaxInt32}},
+		Mapper: func(x T, params Params) T {
+			ps := points(x)
+			fits := gem.RansacLines(ps, int(params["lines"].Value), params["threshold"].Value,
+				int(params["iterations"].Value), int(params["inliers"].Value), nil)
+			return lineMessage(x, &LineResult{ps, fits})
+		}}
+}
+
+// HoughFunction returns a mapper that accumulates the points extracted
+// by `points` in a Hough space of the given extent and resolution, and
+// writes a *LineResult with up to "lines" peaks of at least "votes"
+// votes. The inliers of a peak are the points within one rho cell,
+// to which the line is refitted by least squares.
+func HoughFunction(points func(T) []gem.Point, maxRho, rhoRes, thetaRes float64, lines int, votes float64) *Function {
+	return &Function{FuncName: "hough",
+		FuncParams: Params{"lines": Parameter{float64(lines), 1, math.MaxInt32},
+			"votes": Parameter{votes, 0, math.MaxFloat64}},
+		Mapper: func(x T, params Params) T {
+			ps := points(x)
+			h := gem.NewHough(maxRho, rhoRes, thetaRes)
+			h.AddPoints(ps)
+			r := &LineResult{Points: ps}
+			for _, p := range h.Peaks(int(params["lines"].Value), params["votes"].Value, 2) {
+				l := gem.NewLineFit(p.Rho, p.Theta, ps, rhoRes)
+				l.Refine(ps)
+				r.Lines = append(r.Lines, l)
+			}
+			return lineMessage(x, r)
+		}}
+}
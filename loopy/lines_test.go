@@ -0,0 +1,41 @@
+package loopy
+
+import (
+	"gem"
+	"testing"
+)
+
+func TestLineFunctions(t *testing.T) {
+	// two lanes, x = 10 and x = 30, plus clutter
+	var frame []gem.Point
+	for y := 0; y < 20; y++ {
+		frame = append(frame, gem.Point{10, float64(y)}, gem.Point{30, float64(y)})
+	}
+	frame = append(frame, gem.Point{15, 3}, gem.Point{22, 17}, gem.Point{5, 11})
+	points := func(x T) []gem.Point { return MessageV(x).([]gem.Point) }
+	for _, f := range []*Function{RansacFunction(points, 4, 0.5, 100, 10),
+		HoughFunction(points, 50, 0.5, 0.01, 4, 15)} {
+		var out []*LineResult
+		collect := &Function{FuncName: "collect", Reducer: func(u, x T, params Params) (T, T) {
+			out = append(out, MessageV(x).(*LineResult))
+			return u, x
+		}}
+		g := NewOGraph()
+		g.Source(&sliceSpout{values: []T{frame, frame}}).Map(Functions{f}).Reduce(nil, Functions{collect}).Ground()
+		g.Execute()
+		g.Wait()
+		if len(out) != 2 {
+			t.Fatalf("%s: %d results", f.FuncName, len(out))
+		}
+		for _, r := range out {
+			if len(r.Lines) != 2 {
+				t.Fatalf("%s: %d lines", f.FuncName, len(r.Lines))
+			}
+			for _, l := range r.Lines {
+				if len(l.Inliers) != 20 || l.Dist(gem.Point{10, 50}) > 0.1 && l.Dist(gem.Point{30, 50}) > 0.1 {
+					t.Errorf("%s: line %+v", f.FuncName, l)
+				}
+			}
+		}
+	}
+}
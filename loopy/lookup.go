@@ -0,0 +1,90 @@
+package loopy
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//#################################################################
+//                   Lookup Table
+//#################################################################
+
+// Policies for a main stream message whose key is not in the table.
+const (
+	MISS_DROP    int = iota // drop the message
+	MISS_PASS               // write (x; nil)
+	MISS_DEFAULT            // write (x; Default)
+)
+
+// LookupTable is the keyed side table of a LookupJoin. It is safe
+// for concurrent use, so it can also be updated from outside the
+// graph.
+type LookupTable struct {
+	Hits, Misses uint64
+	rows         map[T]T
+	mutex        *sync.RWMutex
+}
+
+func NewLookupTable() *LookupTable {
+	return &LookupTable{rows: make(map[T]T), mutex: &sync.RWMutex{}}
+}
+
+// Get returns a clone of the row stored under `key`.
+func (t *LookupTable) Get(key T) (T, bool) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	row, ok := t.rows[key]
+	if !ok {
+		atomic.AddUint64(&t.Misses, 1)
+		return nil, false
+	}
+	atomic.AddUint64(&t.Hits, 1)
+	return DeepClone(row), true
+}
+
+// Set stores `row` under `key`, a nil row deletes the key.
+func (t *LookupTable) Set(key T, row T) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if old, ok := t.rows[key]; ok {
+		DeepDispose(old)
+	}
+	if row == nil {
+		delete(t.rows, key)
+	} else {
+		t.rows[key] = row
+	}
+}
+
+// Replace swaps the whole table for `rows`.
+func (t *LookupTable) Replace(rows map[T]T) {
+	t.mutex.Lock()
+	old := t.rows
+	t.rows = rows
+	t.mutex.Unlock()
+	for _, row := range old {
+		DeepDispose(row)
+	}
+}
+
+func (t *LookupTable) Len() int {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return len(t.rows)
+}
+
+// LookupSpec configures a LookupJoin. Key extracts the key of a main
+// stream message. The table is fed by the side stream, where RowKey
+// extracts the key of a row, and/or by Loader, which is called before
+// the first message and then every Refresh (zero loads only once).
+// A nil Table is replaced by a new one.
+type LookupSpec struct {
+	Key     func(x T) T
+	RowKey  func(y T) T
+	Loader  func() (map[T]T, error)
+	Refresh time.Duration
+	Miss    int
+	Default T
+	Table   *LookupTable
+}
@@ -0,0 +1,88 @@
+package loopy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLookupJoinMiss(t *testing.T) {
+	loader := func() (map[T]T, error) {
+		rows := make(map[T]T)
+		for i := 1; i <= 10; i += 2 {
+			rows[i] = testValue(100 + i)
+		}
+		return rows, nil
+	}
+	tests := []struct {
+		miss    int
+		n, nnil int // pairs written and pairs without a row
+	}{
+		{MISS_DROP, 5, 0},
+		{MISS_PASS, 10, 5},
+		{MISS_DEFAULT, 10, 0},
+	}
+	for _, tt := range tests {
+		g := NewOGraph()
+		spec := LookupSpec{Key: func(x T) T { return int(MessageV(x).(testValue)) },
+			Loader: loader, Miss: tt.miss, Default: testValue(-1)}
+		n, nnil := 0, 0
+		count := &Function{FuncName: "count", Reducer: func(u T, x T, params Params) (T, T) {
+			v := x.([]T)
+			if v[1] == nil {
+				nnil++
+			} else if k := MessageV(v[0]).(testValue); k%2 == 1 && v[1] != 100+k {
+				t.Errorf("message %d joined with row %v", k, v[1])
+			}
+			n++
+			return u, x
+		}}
+		g.Source(&testSpout{max: 10}).LookupJoin(spec).Reduce(nil, Functions{count}).Ground()
+		g.Execute()
+		g.Wait()
+		if n != tt.n || nnil != tt.nnil {
+			t.Errorf("miss policy %d: got %d pairs (%d without row), expected %d (%d)",
+				tt.miss, n, nnil, tt.n, tt.nnil)
+		}
+	}
+}
+
+func TestLookupJoinSideStream(t *testing.T) {
+	g := NewOGraph()
+	table := NewLookupTable()
+	spec := LookupSpec{Key: func(x T) T { return int(MessageV(x).(testValue)) },
+		RowKey: func(y T) T { return int(MessageV(y).(testValue)) }, Miss: MISS_PASS, Table: table}
+	side := g.Source(&testSpout{max: 10})
+	// let the side stream fill the table before the main stream starts
+	done := make(chan bool)
+	m := g.Source(&gatedSpout{testSpout{max: 10}, done})
+	j := g.LookupJoin(spec)
+	g.LinkIn(j.Proc.Name, m.Proc.Name, side.Proc.Name)
+	n := 0
+	count := &Function{FuncName: "count", Reducer: func(u T, x T, params Params) (T, T) {
+		if v := x.([]T); MessageV(v[0]) != MessageV(v[1]) {
+			t.Errorf("pair %v has different keys", v)
+		}
+		n++
+		return u, x
+	}}
+	j.Reduce(nil, Functions{count}).Ground()
+	g.Execute()
+	for table.Len() < 10 {
+		time.Sleep(time.Millisecond)
+	}
+	close(done)
+	g.Wait()
+	if n != 10 || table.Hits != 10 || table.Misses != 0 {
+		t.Errorf("got %d pairs, %d hits and %d misses", n, table.Hits, table.Misses)
+	}
+}
+
+type gatedSpout struct {
+	testSpout
+	gate chan bool
+}
+
+func (s *gatedSpout) Read() T {
+	<-s.gate
+	return s.testSpout.Read()
+}
@@ -1,6 +1,7 @@
 package loopy
 
 import (
+	"sync"
 	"time"
 )
 
@@ -14,10 +15,43 @@ type TimeInfo struct {
 	OutTime time.Time
 }
 
+// TimeEntry is the TimeInfo recorded by the processor with the given Id.
+type TimeEntry struct {
+	Id uint64
+	TimeInfo
+}
+
+// TimeInfos keeps the TimeInfo of a message keyed by processor Id.
+// A message passes a handful of processors, so a linear scan over a
+// compact slice is cheaper than a map.
+type TimeInfos []TimeEntry
+
+func (t TimeInfos) Get(id uint64) (TimeInfo, bool) {
+	for i := range t {
+		if t[i].Id == id {
+			return t[i].TimeInfo, true
+		}
+	}
+	return TimeInfo{}, false
+}
+
+func (t *TimeInfos) Set(id uint64, tinfo TimeInfo) {
+	for i := range *t {
+		if (*t)[i].Id == id {
+			(*t)[i].TimeInfo = tinfo
+			return
+		}
+	}
+	*t = append(*t, TimeEntry{id, tinfo})
+}
+
+// MHeader is the header of a message. FuncInfo and Attribs are
+// allocated on first use, so use SetFuncInfo and SetAttrib to write.
 type MHeader struct {
 	FuncInfo map[string]FuncInfo
-	TmInfo   map[string]TimeInfo
+	TmInfo   TimeInfos
 	Attribs  map[string]T
+	Trace    *TraceContext // nil unless the message is sampled for tracing
 }
 
 type M struct {
@@ -32,17 +66,34 @@ type cM struct {
 	value      T
 }
 
+var messages = sync.Pool{New: func() interface{} {
+	return &M{&MHeader{TmInfo: make(TimeInfos, 0, 8)}, nil}
+}}
+
 func (m *M) Clone() T {
 	// copy time info and share OpInfo
 	if m == nil {
 		return nil
 	}
-	tinfo := map[string]TimeInfo{}
-	for k, v := range m.TmInfo {
-		tinfo[k] = v
-	}
+	c := messages.Get().(*M)
+	c.FuncInfo = m.FuncInfo
+	c.TmInfo = append(c.TmInfo, m.TmInfo...)
+	c.Trace = m.Trace.Clone()
+	c.Value = DeepClone(m.Value)
+	return c
+}
 
-	return &M{&MHeader{FuncInfo: m.FuncInfo, TmInfo: tinfo, Attribs: map[string]T{}}, DeepClone(m.Value)}
+// Release returns the message to the pool used by NewMessage and
+// Clone. Neither the message nor its header may be used afterwards.
+func (m *M) Release() {
+	if m == nil {
+		return
+	}
+	h := m.MHeader
+	h.FuncInfo, h.Attribs, h.Trace = nil, nil, nil
+	h.TmInfo = h.TmInfo[:0]
+	m.Value = nil
+	messages.Put(m)
 }
 
 func DeepClone(m T) T {
@@ -71,8 +122,8 @@ func DeepDispose(m T) {
 }
 
 func (m *M) Dispose() {
-	if m != nil && m.Value != nil {
-		(m.Value.(Disposable)).Dispose()
+	if m != nil {
+		DeepDispose(m.Value)
 	}
 }
 
@@ -86,20 +137,50 @@ func (m *M) Exists() bool {
 	return true
 }
 
-func (m *MHeader) AddTimeInfo(tinfo map[string]TimeInfo) {
-	if tinfo == nil {
+func (m *MHeader) AddTimeInfo(tinfo TimeInfos) {
+	for _, e := range tinfo {
+		if _, ok := m.TmInfo.Get(e.Id); !ok {
+			m.TmInfo = append(m.TmInfo, e)
+		}
+	}
+}
+
+func (m *MHeader) SetFuncInfo(name string, f FuncInfo) {
+	if m.FuncInfo == nil {
+		m.FuncInfo = map[string]FuncInfo{}
+	}
+	m.FuncInfo[name] = f
+}
+
+func (m *MHeader) SetAttrib(name string, v T) {
+	if m.Attribs == nil {
+		m.Attribs = map[string]T{}
+	}
+	m.Attribs[name] = v
+}
+
+// InheritHeader copies the time info and the trace of message `x`
+// into the messages `v` derived from it, so that the history of `x`
+// is not lost when an operator emits new messages.
+func InheritHeader(x T, v []T) {
+	h := MessageH(x)
+	if h == nil {
 		return
 	}
-	for k, v := range tinfo {
-		if _, ok := m.TmInfo[k]; !ok {
-			m.TmInfo[k] = v
+	for _, y := range v {
+		if hy := MessageH(y); hy != nil && hy != h {
+			hy.AddTimeInfo(h.TmInfo)
+			if hy.Trace == nil {
+				hy.Trace = h.Trace.Clone()
+			}
 		}
 	}
 }
 
 func NewMessage(v T) *M {
-	return &M{&MHeader{FuncInfo: map[string]FuncInfo{},
-		TmInfo: map[string]TimeInfo{}, Attribs: map[string]T{}}, v}
+	m := messages.Get().(*M)
+	m.Value = v
+	return m
 }
 
 func MessageV(x T) T {
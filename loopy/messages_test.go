@@ -0,0 +1,111 @@
+package loopy
+
+import (
+	"encoding/json"
+	"gem"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// BenchmarkMessageHops measures the header cost of a message that
+// passes the processors of the word-count topology: a source, a
+// scatter that emits new messages, an add, a reducer and a ground.
+func BenchmarkMessageHops(b *testing.B) {
+	g := NewOGraph()
+	procs := make([]*ProcessorInfo, 5)
+	for i := range procs {
+		procs[i] = g.NewProcessor(nil, nil, OP_MAP).ProcessorInfo
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		x := NewMessage(testValue(i))
+		procs[0].AddTimeInfo(PROC_BOTH_TIME, x)
+		procs[1].AddTimeInfo(PROC_ENTER_TIME, x)
+		y := x.Clone()
+		procs[1].AddTimeInfo(PROC_LEAVE_TIME, y)
+		for _, p := range procs[2:] {
+			p.AddTimeInfo(PROC_ENTER_TIME, y)
+			p.AddTimeInfo(PROC_LEAVE_TIME, y)
+		}
+	}
+}
+
+type sentSpout struct {
+	n   int64
+	max int64
+}
+
+func (s *sentSpout) Read() T {
+	if atomic.AddInt64(&s.n, 1) > s.max {
+		return nil
+	}
+	return NewMessage(testValue(0))
+}
+
+// BenchmarkWordCount runs the word-count topology of the samples
+// with every sentence producing eight words.
+func BenchmarkWordCount(b *testing.B) {
+	words := strings.Fields("we will continue to focus on the long-term")
+	counter := &Function{FuncName: "counter", Reducer: func(u, x T, params Params) (T, T) {
+		u.(map[int]int)[int(MessageV(x).(testValue))]++
+		return u, x
+	}}
+	f := func(x T) []T {
+		ret := make([]T, len(words))
+		for i := range words {
+			ret[i] = NewMessage(testValue(i))
+		}
+		return ret
+	}
+	p := func(x T, i int, n int) int {
+		return int(MessageV(x).(testValue)) % n
+	}
+	spout := &sentSpout{max: int64(b.N)}
+	h1 := func(g *OGraph, i int) (*Processor, *Processor) {
+		a := g.Source(spout)
+		return a.Proc, a.Proc
+	}
+	h2 := func(g *OGraph, i int) (*Processor, *Processor) {
+		s := g.Reduce(map[int]int{}, Functions{counter})
+		e := s.Ground()
+		return s.Proc, e.Proc
+	}
+	g := NewOGraph()
+	g.Recycle = true
+	g.List(2, h1).Group(2, 3, f, p).List(3, h2)
+	b.ReportAllocs()
+	b.ResetTimer()
+	g.Execute()
+	g.Wait()
+}
+
+// TestMessageGeometryJSON encodes a message holding geometries, the
+// outline wrapped to be written as GeoJSON, and decodes its value back.
+func TestMessageGeometryJSON(t *testing.T) {
+	type detection struct {
+		Box     gem.Rect
+		Outline gem.GeoJSON
+	}
+	v := &detection{gem.Rect{C: gem.Point{2, 2}, P: gem.Point{1, 2}}, gem.GeoJSON{Geometry: gem.Polygon{{1, 0}, {3, 0}, {2, 4}}}}
+	x := NewMessage(v)
+	x.SetAttrib("camera", "north")
+	data, err := json.Marshal(x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"Box":{"C":[2,2],"P":[1,2]},"Outline":{"type":"Polygon","coordinates":[[[1,0],[3,0],[2,4],[1,0]]]}`) {
+		t.Errorf("encoded to %s", data)
+	}
+	var outline gem.Polygon
+	y := struct {
+		Attribs map[string]string
+		Value   *detection
+	}{Value: &detection{Outline: gem.GeoJSON{Geometry: &outline}}}
+	if err := json.Unmarshal(data, &y); err != nil || !reflect.DeepEqual(y.Value.Box, v.Box) ||
+		!reflect.DeepEqual(outline, v.Outline.Geometry) || y.Attribs["camera"] != "north" {
+		t.Errorf("decoded to %+v %v", y, err)
+	}
+}
@@ -0,0 +1,273 @@
+package loopy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//#################################################################
+//                   Histogram
+//#################################################################
+
+// DefBuckets are the default upper bounds (in seconds) used for the
+// processing time and inter-node delay histograms.
+var DefBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// Histogram counts observations into cumulative buckets with the
+// given upper bounds.
+type Histogram struct {
+	Bounds []float64
+	counts []uint64
+	sum    float64
+	count  uint64
+	mutex  *sync.Mutex
+}
+
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{Bounds: bounds, counts: make([]uint64, len(bounds)),
+		mutex: &sync.Mutex{}}
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for i, b := range h.Bounds {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// Snapshot returns the cumulative bucket counts, the sum and the
+// count of all observations.
+func (h *Histogram) Snapshot() ([]uint64, float64, uint64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	counts := make([]uint64, len(h.counts))
+	copy(counts, h.counts)
+	return counts, h.sum, h.count
+}
+
+//#################################################################
+//                   Processor Metrics
+//#################################################################
+
+// ProcMetrics holds the counters and timing histograms of one
+// processor. Counters are updated from the message headers, so
+// only messages of type *M are counted.
+type ProcMetrics struct {
+	In, Out  uint64 // messages entering and leaving the processor
+	Filtered uint64 // messages sent to the second output of a Filter or dropped
+	Errors   uint64 // messages leaving with an error value
+	ProcTime *Histogram
+	Delay    *Histogram // delay since the upstream processor released the message
+	upstream []uint64
+}
+
+func NewProcMetrics(upstream []uint64) *ProcMetrics {
+	return &ProcMetrics{ProcTime: NewHistogram(DefBuckets),
+		Delay: NewHistogram(DefBuckets), upstream: upstream}
+}
+
+func (m *ProcMetrics) observe(t int, c *M, tinfo TimeInfo) {
+	if t == PROC_ENTER_TIME || t == PROC_BOTH_TIME {
+		atomic.AddUint64(&m.In, 1)
+		d := math.Inf(1)
+		for _, u := range m.upstream {
+			if ui, ok := c.TmInfo.Get(u); ok && !ui.OutTime.IsZero() && !tinfo.InTime.Before(ui.OutTime) {
+				d = math.Min(d, tinfo.InTime.Sub(ui.OutTime).Seconds())
+			}
+		}
+		if !math.IsInf(d, 1) {
+			m.Delay.Observe(d)
+		}
+	}
+	if t == PROC_LEAVE_TIME || t == PROC_BOTH_TIME {
+		atomic.AddUint64(&m.Out, 1)
+		if _, ok := c.Value.(error); ok {
+			atomic.AddUint64(&m.Errors, 1)
+		}
+		if !tinfo.InTime.IsZero() {
+			m.ProcTime.Observe(tinfo.OutTime.Sub(tinfo.InTime).Seconds())
+		}
+	}
+}
+
+func (m *ProcMetrics) filtered() {
+	if m != nil {
+		atomic.AddUint64(&m.Filtered, 1)
+	}
+}
+
+//#################################################################
+//                   OpenMetrics Exporter
+//#################################################################
+
+var opNames = map[int]string{OP_SOURCE: "source", OP_GROUND: "ground", OP_MAP: "map",
+	OP_REDUCE: "reduce", OP_FILTER: "filter", OP_COPY: "copy", OP_COPYN: "copy",
+	OP_LATCH: "latch", OP_CUT: "cut", OP_LEFT_MULTIPLY: "left_multiply",
+	OP_MULTIPLY: "multiply", OP_ADD: "add", OP_SCATTER: "scatter", OP_MERGE: "merge",
+	OP_SPLIT: "split", OP_MISC: "misc", OP_COMPOSITE: "composite", OP_PARALLEL: "parallel",
+	OP_JOIN: "join", OP_LOOKUP_JOIN: "lookup_join", OP_DEDUP: "dedup",
+	OP_SPATIAL_JOIN: "spatial_join", OP_GEOFENCE: "geofence"}
+
+// EnableMetrics attaches a ProcMetrics to every processor of the
+// graph. It must be called after the graph is built and before
+// Execute.
+func (g *OGraph) EnableMetrics() {
+	for name, n := range g.Nodes_map {
+		proc := (*n.Value).(*Processor)
+		if proc.IsComposite {
+			continue
+		}
+		proc.Metrics = NewProcMetrics(g.upstreamIds(name))
+	}
+}
+
+// MetricsHandler returns an http.Handler that writes the processor
+// and branch metrics of the graph in the OpenMetrics text format.
+// The branch metrics need the Schedule option of the graph.
+func (g *OGraph) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+		g.WriteMetrics(w)
+	})
+}
+
+// WriteMetrics writes the graph metrics in the OpenMetrics text format.
+func (g *OGraph) WriteMetrics(out io.Writer) {
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+	names := make([]string, 0, len(g.Nodes_map))
+	for name, n := range g.Nodes_map {
+		if proc := (*n.Value).(*Processor); proc.Metrics != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	procs := make([]*Processor, len(names))
+	for i, name := range names {
+		procs[i] = g.Get(name)
+	}
+	labels := func(p *Processor) string {
+		return fmt.Sprintf("processor=%q,type=%q", p.Name, opNames[p._type])
+	}
+
+	counters := []struct {
+		name, help string
+		value      func(m *ProcMetrics) uint64
+	}{
+		{"loopy_processor_messages_in", "Messages entering the processor.",
+			func(m *ProcMetrics) uint64 { return atomic.LoadUint64(&m.In) }},
+		{"loopy_processor_messages_out", "Messages leaving the processor.",
+			func(m *ProcMetrics) uint64 { return atomic.LoadUint64(&m.Out) }},
+		{"loopy_processor_messages_filtered", "Messages rejected by a filter.",
+			func(m *ProcMetrics) uint64 { return atomic.LoadUint64(&m.Filtered) }},
+		{"loopy_processor_errors", "Messages leaving the processor with an error value.",
+			func(m *ProcMetrics) uint64 { return atomic.LoadUint64(&m.Errors) }},
+	}
+	for _, c := range counters {
+		fmt.Fprintf(w, "# TYPE %s counter\n# HELP %s %s\n", c.name, c.name, c.help)
+		for _, p := range procs {
+			fmt.Fprintf(w, "%s_total{%s} %d\n", c.name, labels(p), c.value(p.Metrics))
+		}
+	}
+
+	histograms := []struct {
+		name, help string
+		value      func(m *ProcMetrics) *Histogram
+	}{
+		{"loopy_processor_time_seconds", "Time spent processing a message.",
+			func(m *ProcMetrics) *Histogram { return m.ProcTime }},
+		{"loopy_processor_delay_seconds", "Delay between the upstream processor and this processor.",
+			func(m *ProcMetrics) *Histogram { return m.Delay }},
+	}
+	for _, h := range histograms {
+		fmt.Fprintf(w, "# TYPE %s histogram\n# HELP %s %s\n# UNIT %s seconds\n", h.name, h.name, h.help, h.name)
+		for _, p := range procs {
+			hist := h.value(p.Metrics)
+			counts, sum, count := hist.Snapshot()
+			for i, b := range hist.Bounds {
+				fmt.Fprintf(w, "%s_bucket{%s,le=%q} %d\n", h.name, labels(p),
+					strconv.FormatFloat(b, 'g', -1, 64), counts[i])
+			}
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", h.name, labels(p), count)
+			fmt.Fprintf(w, "%s_sum{%s} %g\n%s_count{%s} %d\n", h.name, labels(p), sum, h.name, labels(p), count)
+		}
+	}
+
+	fmt.Fprintf(w, "# TYPE loopy_channel_occupancy gauge\n# HELP loopy_channel_occupancy Messages released by the upstream processors that did not enter the processor yet.\n")
+	for _, p := range procs {
+		if occ, ok := g.occupancy(p); ok {
+			fmt.Fprintf(w, "loopy_channel_occupancy{%s} %d\n", labels(p), occ)
+		}
+	}
+
+	// the branches are only found when the graph is scheduled
+	if len(g.Branches) > 0 {
+		L, P := make([]float64, len(g.Branches)), make([]float64, len(g.Branches))
+		for i, b := range g.Branches {
+			_, L[i], P[i] = b.times()
+		}
+		gauges := []struct {
+			name, help string
+			value      []float64
+		}{
+			{"loopy_branch_latency_seconds", "Mean end-to-end latency L of the branch.", L},
+			{"loopy_branch_period_seconds", "Mean period P of the branch bottleneck.", P},
+		}
+		for _, gg := range gauges {
+			fmt.Fprintf(w, "# TYPE %s gauge\n# HELP %s %s\n# UNIT %s seconds\n", gg.name, gg.name, gg.help, gg.name)
+			for i, b := range g.Branches {
+				fmt.Fprintf(w, "%s{start=%q,end=%q} %g\n", gg.name, b.Start, b.End, gg.value[i]/1000)
+			}
+		}
+	}
+	fmt.Fprintf(w, "# EOF\n")
+}
+
+// occupancy returns the messages in front of the processor `p`, those
+// released by its upstream processors that did not enter `p` yet. The
+// edges are unbuffered, so these are the messages of blocked senders
+// and of pending batches, and for a Parallel the queued messages. The
+// count is only known when every upstream has a single output and `p`
+// counts each message it reads once.
+func (g *OGraph) occupancy(p *Processor) (int64, bool) {
+	switch p._type {
+	case OP_SOURCE, OP_LATCH, OP_CUT, OP_LEFT_MULTIPLY:
+		return 0, false
+	}
+	// a message leaves the upstream before it enters `p`, so reading
+	// the counters in this order never gives a negative count
+	occ := -int64(atomic.LoadUint64(&p.Metrics.In))
+	for u, _ := range g.Edges_info[p.Name].Chans {
+		up := g.Get(u)
+		if up.Metrics == nil || g.Edges_info[u].NOutchans != 1 {
+			return 0, false
+		}
+		occ += int64(atomic.LoadUint64(&up.Metrics.Out))
+	}
+	if p.Replicas != nil {
+		occ += int64(p.Replicas.Queue())
+	}
+	return occ, true
+}
+
+// ServeMetrics serves the graph metrics on `addr` under `/metrics`.
+// It blocks until the server fails.
+func (g *OGraph) ServeMetrics(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", g.MetricsHandler())
+	server := &http.Server{Addr: addr, Handler: mux, ReadTimeout: 10 * time.Second}
+	return server.ListenAndServe()
+}
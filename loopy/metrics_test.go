@@ -0,0 +1,155 @@
+package loopy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testValue int
+
+func (v testValue) Dispose() {}
+
+type testSpout struct {
+	n, max int
+}
+
+func (s *testSpout) Read() T {
+	if s.n >= s.max {
+		return nil
+	}
+	s.n++
+	return NewMessage(testValue(s.n))
+}
+
+func TestMetricsHandler(t *testing.T) {
+	g := NewOGraph()
+	even := &Function{FuncName: "even", Mapper: func(x T, params Params) T {
+		return MessageV(x).(testValue)%2 == 0
+	}}
+	g.Source(&testSpout{max: 10}, "src").Filter(Functions{even}, "even").Add("add").Ground("gnd")
+	g.EnableMetrics()
+	g.Execute()
+	g.Wait()
+
+	server := httptest.NewServer(g.MetricsHandler())
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	text := string(body)
+
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "application/openmetrics-text") {
+		t.Errorf("unexpected content type %s", ct)
+	}
+	for _, line := range []string{
+		`loopy_processor_messages_out_total{processor="src",type="source"} 10`,
+		`loopy_processor_messages_in_total{processor="even",type="filter"} 10`,
+		`loopy_processor_messages_filtered_total{processor="even",type="filter"} 5`,
+		`loopy_processor_messages_in_total{processor="gnd",type="ground"} 10`,
+		`loopy_processor_time_seconds_count{processor="even",type="filter"} 10`,
+		`loopy_processor_delay_seconds_count{processor="add",type="add"} 10`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("missing metric %s", line)
+		}
+	}
+	if !strings.HasSuffix(text, "# EOF\n") {
+		t.Errorf("exposition should end with # EOF")
+	}
+	if strings.Contains(text, "loopy_branch_") {
+		t.Errorf("branch metrics need the Schedule option")
+	}
+}
+
+func TestBranchMetrics(t *testing.T) {
+	g := NewOGraph()
+	g.Schedule = true
+	slow := &Function{FuncName: "slow", Mapper: func(x T, params Params) T {
+		time.Sleep(200 * time.Microsecond)
+		return x
+	}}
+	pass := &Function{FuncName: "pass", Mapper: func(x T, params Params) T {
+		return x
+	}}
+	g.Source(&testSpout{max: 50}, "src").Map(Functions{slow}, "slow").Map(Functions{pass}, "pass").Ground("gnd")
+	g.EnableMetrics()
+	g.Execute()
+	g.Wait()
+
+	var buf bytes.Buffer
+	g.WriteMetrics(&buf)
+	for _, name := range []string{"loopy_branch_latency_seconds", "loopy_branch_period_seconds"} {
+		var v float64
+		prefix := name + `{start="slow",end="gnd"} `
+		for _, line := range strings.Split(buf.String(), "\n") {
+			if strings.HasPrefix(line, prefix) {
+				v, _ = strconv.ParseFloat(strings.TrimPrefix(line, prefix), 64)
+			}
+		}
+		// every message spends 200us in the slow map
+		if v < 200e-6 {
+			t.Errorf("%s is %g, expected at least 200us", name, v)
+		}
+	}
+}
+
+func TestChannelOccupancy(t *testing.T) {
+	g := NewOGraph()
+	release := make(chan bool)
+	pass := &Function{FuncName: "pass", Mapper: func(x T, params Params) T {
+		return x
+	}}
+	block := &Function{FuncName: "block", Mapper: func(x T, params Params) T {
+		<-release
+		return x
+	}}
+	g.Source(&testSpout{max: 10}, "src").Map(Functions{pass}, "pass").Map(Functions{block}, "block").Ground("gnd")
+	g.EnableMetrics()
+	g.Execute()
+
+	// the first message blocks, the next two wait on the edges in front of it
+	want := []string{
+		`loopy_channel_occupancy{processor="pass",type="map"} 1`,
+		`loopy_channel_occupancy{processor="block",type="map"} 1`,
+		`loopy_channel_occupancy{processor="gnd",type="ground"} 0`,
+	}
+	var buf bytes.Buffer
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		buf.Reset()
+		g.WriteMetrics(&buf)
+		missing := 0
+		for _, line := range want {
+			if !strings.Contains(buf.String(), line+"\n") {
+				missing++
+			}
+		}
+		if missing == 0 {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatalf("expected %v in\n%s", want, buf.String())
+		}
+	}
+	if strings.Contains(buf.String(), `loopy_channel_occupancy{processor="src"`) {
+		t.Errorf("a source has no input edges")
+	}
+	close(release)
+	g.Wait()
+
+	buf.Reset()
+	g.WriteMetrics(&buf)
+	for _, name := range []string{"pass", "block"} {
+		if line := `loopy_channel_occupancy{processor="` + name + `",type="map"} 0`; !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("expected %s after the stream ended", line)
+		}
+	}
+}
@@ -25,6 +25,12 @@ type Disposable interface {
 	Dispose()
 }
 
+// Flusher is a reducer state with a final output at the end of
+// the stream.
+type Flusher interface {
+	Flush() T
+}
+
 //#################################################################
 //                   Types for Parameters and Functions
 //#################################################################
@@ -55,7 +61,9 @@ type ProcessorInfo struct {
 	Id      uint64
 	_type   int
 	Funcs   Functions
-	FuncIdx int //Current active Function
+	FuncIdx int          //Current active Function
+	Metrics *ProcMetrics // nil unless the graph metrics are enabled
+	Tracer  *ProcTracer  // nil unless the graph tracing is enabled
 }
 
 func (p *ProcessorInfo) AddTimeInfo(t int, x T) {
@@ -64,13 +72,14 @@ func (p *ProcessorInfo) AddTimeInfo(t int, x T) {
 	switch y := x.(type) {
 	case []T:
 		p.AddTimeInfo1(t, ct, y)
+	case Batch:
+		p.AddTimeInfo1(t, ct, y...)
 	case T:
 		p.AddTimeInfo1(t, ct, y)
 	}
 }
 
 func (proc *ProcessorInfo) AddTimeInfo1(t int, ct time.Time, Z ...T) {
-	name := proc.Name
 	// record time
 	for _, x := range Z {
 		if x == nil {
@@ -78,23 +87,23 @@ func (proc *ProcessorInfo) AddTimeInfo1(t int, ct time.Time, Z ...T) {
 		}
 		switch c := x.(type) {
 		case *M:
-			var (
-				tinfo TimeInfo
-				ok    bool
-			)
 			if c == nil {
 				continue
 			}
-			if tinfo, ok = c.TmInfo[name]; !ok {
-				tinfo = TimeInfo{}
-			}
+			tinfo, _ := c.TmInfo.Get(proc.Id)
 			if t == PROC_ENTER_TIME || t == PROC_BOTH_TIME {
 				tinfo.InTime = ct
 			}
 			if t == PROC_LEAVE_TIME || t == PROC_BOTH_TIME {
 				tinfo.OutTime = ct
 			}
-			c.TmInfo[name] = tinfo
+			c.TmInfo.Set(proc.Id, tinfo)
+			if proc.Metrics != nil {
+				proc.Metrics.observe(t, c, tinfo)
+			}
+			if proc.Tracer != nil {
+				proc.Tracer.observe(t, c, tinfo)
+			}
 		}
 	}
 }
@@ -165,7 +174,7 @@ func (s *ProcessorStack) ExecStack(x T) T {
 			y = e.value.Funcs[e.value.FuncIdx].Mapper(y,
 				e.value.Funcs[e.value.FuncIdx].FuncParams)
 		}
-		pi.AddTimeInfo(PROC_ENTER_TIME, y)
+		pi.AddTimeInfo(PROC_LEAVE_TIME, y)
 	}
 	return y
 }
@@ -191,8 +200,14 @@ type Processor struct {
 	G              *OGraph
 	C              *sync.Cond
 	Composite      *Composite
+	Replicas       *Replicas
 	IsComposite    bool
 	IsGraphRemoved bool
+	IsMerged       bool       // true while the processor runs inside another's InStack
+	TL, TP         float64    // latency and period targets, zero uses the graph thresholds
+	Batch          *BatchSpec // batching of the outgoing edges, nil uses the graph setting
+	batchers       []*batcher
+	running        *sync.WaitGroup // goroutines of the processor, waited for when it is merged
 }
 
 func NewProcessor(g *OGraph, inchans []chan T, outchans []chan T, _type int) *Processor {
@@ -201,21 +216,24 @@ func NewProcessor(g *OGraph, inchans []chan T, outchans []chan T, _type int) *Pr
 		InStack:        &ProcessorStack{size: 0, mutex: &sync.Mutex{}},
 		OutStack:       &ProcessorStack{size: 0, mutex: &sync.Mutex{}},
 		C:              sync.NewCond(&sync.Mutex{}),
+		running:        &sync.WaitGroup{},
 		ERStatus:       ST_RUN,
 		G:              g,
 		WRStatus:       ST_RESUME,
 		Composite:      nil,
+		Replicas:       nil,
 		IsComposite:    false,
-		IsGraphRemoved: false}
+		IsGraphRemoved: false,
+		IsMerged:       false}
 }
 
 func (p *Processor) Wait() bool {
+	p.C.L.Lock()
+	defer p.C.L.Unlock()
 	if p.WRStatus == ST_REQWAIT {
-		p.C.L.Lock()
 		p.WRStatus = ST_WAIT
 		p.C.Wait()
 		p.WRStatus = ST_RESUME
-		p.C.L.Unlock()
 		if p.ERStatus == ST_EXIT {
 			return false
 		}
@@ -224,27 +242,39 @@ func (p *Processor) Wait() bool {
 }
 
 func (p *Processor) Resume(newERState int) {
+	p.C.L.Lock()
+	defer p.C.L.Unlock()
 	if p.WRStatus == ST_WAIT {
-		p.C.L.Lock()
 		p.ERStatus = newERState
 		p.C.Broadcast()
-		p.C.L.Unlock()
 	}
 }
 
+// status returns the wait status of the processor, it is
+// polled by the scheduler while the processor goroutine runs.
+func (p *Processor) status() int {
+	p.C.L.Lock()
+	defer p.C.L.Unlock()
+	return p.WRStatus
+}
+
 func (p *Processor) WaitMessage(x T, chans ...chan T) (bool, bool) {
 	if x == nil {
 		return false, true
 	}
 	switch t := x.(type) {
 	case *cM:
+		// pending batches precede the control message
+		p.Flush()
 		if t.end == "" || t.end != p.Name {
 			for _, c := range chans {
 				c <- x
 			}
 		}
+		p.C.L.Lock()
 		p.ERStatus = t.ERStatus
 		p.WRStatus = t.WRStatus
+		p.C.L.Unlock()
 		return true, p.Wait()
 	default:
 	}
@@ -275,6 +305,12 @@ func (p *Processor) ParseAttrib(attribs []T) *Processor {
 			pproc = attribs[i+1].(*Processor)
 		case OP_ATTRIB_GRAPH_REMOVED:
 			p.IsGraphRemoved = attribs[i+1].(bool)
+		case OP_ATTRIB_TL:
+			p.TL = attribs[i+1].(float64)
+		case OP_ATTRIB_TP:
+			p.TP = attribs[i+1].(float64)
+		case OP_ATTRIB_BATCH:
+			p.Batch = attribs[i+1].(*BatchSpec)
 		}
 	}
 	return pproc
@@ -7,6 +7,7 @@ package loopy
 
 import (
 	// "fmt"
+	"math"
 	"sort"
 )
 
@@ -25,22 +26,18 @@ func calcBottleNeck(W []float64, K int) float64 {
 	for i, w := range W {
 		B[0][i] = w
 	}
+	// B[k][i] splits W[0..i] in k+1 parts, the last one W[j+1..i].
+	// The best j is where the last part stops exceeding B[k-1][j],
+	// which only moves forward with i.
 	for k := 1; k < K; k++ {
 		j := k - 1
-		for i := k - 1; i < (N - K + k + 1); i++ {
-			if (W[i] - W[j]) > B[k-1][j] {
+		for i := k; i < (N - K + k + 1); i++ {
+			for j < i-1 && (W[i]-W[j]) > B[k-1][j] {
 				j += 1
-				for (W[i] - W[j]) > B[k-1][j] {
-					j += 1
-				}
-				if (W[i] - W[j-1]) < B[k-1][j] {
-					j -= 1
-					B[k][i] = W[i] - W[j]
-				} else {
-					B[k][i] = B[k-1][j]
-				}
-			} else {
-				B[k][i] = B[k-1][j]
+			}
+			B[k][i] = math.Max(B[k-1][j], W[i]-W[j])
+			if j > k-1 {
+				B[k][i] = math.Min(B[k][i], math.Max(B[k-1][j-1], W[i]-W[j-1]))
 			}
 		}
 	}
@@ -67,15 +64,20 @@ func probe(W []float64, B float64, K int) ([]int, bool) {
 		Ws   []float64
 		N    int = len(W)
 	)
+	// compare the prefix sums up to their rounding, relative to the total
+	eps := 1e-9 * Wt
 	f := func(x int) bool {
-		if Ws[x] > Bsum {
+		if Ws[x] > Bsum+eps {
 			return true
 		}
 		return false
 	}
+	covers := func() bool {
+		return Bsum >= Wt-eps
+	}
 	S[0] = 0
 	for k := 1; k < K; k++ {
-		if Bsum >= Wt {
+		if covers() {
 			return S, true
 		}
 		Ws = W[S[k-1]:N]
@@ -85,7 +87,8 @@ func probe(W []float64, B float64, K int) ([]int, bool) {
 		}
 		Bsum = W[S[k]-1] + B
 	}
-	return S, false
+	// the last part must also fit within the bottleneck
+	return S, covers()
 }
 
 func CCPSolveDB(T []float64, K int) ([]int, float64, bool) {
@@ -0,0 +1,30 @@
+package loopy
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestCCPSolveDB(t *testing.T) {
+	tests := []struct {
+		T []float64
+		K int
+		S []int
+		B float64
+	}{
+		{[]float64{1, 2, 3, 4}, 1, []int{0}, 10},
+		{[]float64{1, 2, 3, 4}, 2, []int{0, 3}, 6},
+		{[]float64{1, 2, 3, 4}, 4, []int{0, 2, 3, 0}, 4},
+		{[]float64{5, 1, 1, 1, 5}, 3, []int{0, 1, 4}, 5},
+		// the prefix sums of these times are off by a rounding error
+		{[]float64{0.1, 0.3, 0.3, 0.6}, 3, []int{0, 2, 3}, 0.6},
+		{[]float64{0.4, 0.7, 0.3, 0.8}, 3, []int{0, 1, 3}, 1},
+	}
+	for _, tt := range tests {
+		S, B, ok := CCPSolveDB(tt.T, tt.K)
+		if !ok || !reflect.DeepEqual(S, tt.S) || math.Abs(B-tt.B) > 1e-9 {
+			t.Errorf("%v in %d parts: %v of bottleneck %v %v", tt.T, tt.K, S, B, ok)
+		}
+	}
+}
@@ -0,0 +1,546 @@
+package loopy
+
+import (
+	"container/heap"
+	"errors"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+//#################################################################
+//                   Mergeable Sketches
+//#################################################################
+
+var ErrSketchMismatch = errors.New("sketches have different kinds or dimensions")
+
+// Sketch is a mergeable summary of a stream. Merge adds the stream
+// summarized by `o` and Reset empties the sketch.
+type Sketch interface {
+	Cloneable
+	Disposable
+	Merge(o Sketch) error
+	Reset()
+}
+
+// hash64 is FNV-1a followed by the splitmix64 finalizer, which
+// spreads the entropy of short keys over all bits.
+func hash64(key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
+
+//#################################################################
+//                   Top-K (Space-Saving)
+//#################################################################
+
+type TopKItem struct {
+	Key   string
+	Count uint64
+	Error uint64 // upper bound of the overestimation of Count
+}
+
+// TopK tracks the heavy hitters of a stream with the space-saving
+// algorithm in K counters.
+type TopK struct {
+	K     int
+	items map[string]*topKEntry
+	heap  topKHeap
+}
+
+type topKEntry struct {
+	TopKItem
+	index int
+}
+
+type topKHeap []*topKEntry
+
+func (h topKHeap) Len() int           { return len(h) }
+func (h topKHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+func (h topKHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+func (h *topKHeap) Push(x interface{}) {
+	e := x.(*topKEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+func (h *topKHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+func NewTopK(k int) *TopK {
+	return &TopK{K: k, items: make(map[string]*topKEntry, k), heap: make(topKHeap, 0, k)}
+}
+
+func (t *TopK) Add(key string, w uint64) {
+	if e, ok := t.items[key]; ok {
+		e.Count += w
+		heap.Fix(&t.heap, e.index)
+		return
+	}
+	if len(t.heap) < t.K {
+		e := &topKEntry{TopKItem: TopKItem{Key: key, Count: w}}
+		t.items[key] = e
+		heap.Push(&t.heap, e)
+		return
+	}
+	// replace the minimum, which bounds the error of the new key
+	e := t.heap[0]
+	delete(t.items, e.Key)
+	e.Key, e.Error, e.Count = key, e.Count, e.Count+w
+	t.items[key] = e
+	heap.Fix(&t.heap, 0)
+}
+
+// min returns the count a key outside of the summary may have.
+func (t *TopK) min() uint64 {
+	if len(t.heap) < t.K {
+		return 0
+	}
+	return t.heap[0].Count
+}
+
+// Top returns the items by decreasing count.
+func (t *TopK) Top() []TopKItem {
+	top := make([]TopKItem, len(t.heap))
+	for i, e := range t.heap {
+		top[i] = e.TopKItem
+	}
+	sort.Slice(top, func(i, j int) bool { return top[i].Count > top[j].Count })
+	return top
+}
+
+func (t *TopK) Merge(o Sketch) error {
+	s, ok := o.(*TopK)
+	if !ok || s.K != t.K {
+		return ErrSketchMismatch
+	}
+	m1, m2 := t.min(), s.min()
+	merged := make(map[string]TopKItem, len(t.items)+len(s.items))
+	for k, e := range t.items {
+		merged[k] = TopKItem{k, e.Count + m2, e.Error + m2}
+	}
+	for k, e := range s.items {
+		if a, ok := merged[k]; ok {
+			merged[k] = TopKItem{k, a.Count - m2 + e.Count, a.Error - m2 + e.Error}
+		} else {
+			merged[k] = TopKItem{k, e.Count + m1, e.Error + m1}
+		}
+	}
+	all := make([]TopKItem, 0, len(merged))
+	for _, e := range merged {
+		all = append(all, e)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Count > all[j].Count })
+	if len(all) > t.K {
+		all = all[:t.K]
+	}
+	t.Reset()
+	for _, item := range all {
+		e := &topKEntry{TopKItem: item}
+		t.items[item.Key] = e
+		heap.Push(&t.heap, e)
+	}
+	return nil
+}
+
+func (t *TopK) Reset() {
+	t.items = make(map[string]*topKEntry, t.K)
+	t.heap = t.heap[:0]
+}
+
+func (t *TopK) Clone() T {
+	c := NewTopK(t.K)
+	for _, e := range t.heap {
+		n := &topKEntry{TopKItem: e.TopKItem, index: e.index}
+		c.items[n.Key] = n
+		c.heap = append(c.heap, n)
+	}
+	return c
+}
+
+func (t *TopK) Dispose() {}
+
+//#################################################################
+//                   Count-Min Sketch
+//#################################################################
+
+// CountMin estimates the frequency of keys with Depth rows of Width
+// counters. Estimates never undercount and overcount by at most
+// eps*N with probability 1-delta.
+type CountMin struct {
+	Width, Depth int
+	N            uint64 // total count
+	rows         [][]uint64
+}
+
+func NewCountMin(width, depth int) *CountMin {
+	rows := make([][]uint64, depth)
+	for i := range rows {
+		rows[i] = make([]uint64, width)
+	}
+	return &CountMin{Width: width, Depth: depth, rows: rows}
+}
+
+// NewCountMinEps sizes the sketch for the error `eps` and failure
+// probability `delta`.
+func NewCountMinEps(eps, delta float64) *CountMin {
+	return NewCountMin(int(math.Ceil(math.E/eps)), int(math.Ceil(math.Log(1/delta))))
+}
+
+// index returns the counter of `h` in row `i` by double hashing.
+func (c *CountMin) index(h uint64, i int) int {
+	h1, h2 := h&0xffffffff, h>>32
+	return int((h1 + uint64(i)*h2) % uint64(c.Width))
+}
+
+func (c *CountMin) Add(key string, w uint64) {
+	h := hash64(key)
+	for i, row := range c.rows {
+		row[c.index(h, i)] += w
+	}
+	c.N += w
+}
+
+func (c *CountMin) Count(key string) uint64 {
+	h := hash64(key)
+	n := uint64(math.MaxUint64)
+	for i, row := range c.rows {
+		if v := row[c.index(h, i)]; v < n {
+			n = v
+		}
+	}
+	return n
+}
+
+func (c *CountMin) Merge(o Sketch) error {
+	s, ok := o.(*CountMin)
+	if !ok || s.Width != c.Width || s.Depth != c.Depth {
+		return ErrSketchMismatch
+	}
+	for i, row := range s.rows {
+		for j, v := range row {
+			c.rows[i][j] += v
+		}
+	}
+	c.N += s.N
+	return nil
+}
+
+func (c *CountMin) Reset() {
+	for _, row := range c.rows {
+		for j := range row {
+			row[j] = 0
+		}
+	}
+	c.N = 0
+}
+
+func (c *CountMin) Clone() T {
+	n := NewCountMin(c.Width, c.Depth)
+	for i, row := range c.rows {
+		copy(n.rows[i], row)
+	}
+	n.N = c.N
+	return n
+}
+
+func (c *CountMin) Dispose() {}
+
+//#################################################################
+//                   HyperLogLog
+//#################################################################
+
+// HyperLogLog estimates the number of distinct keys with 2^P
+// registers. The relative error is about 1.04/sqrt(2^P).
+type HyperLogLog struct {
+	P    uint8
+	regs []uint8
+}
+
+// NewHyperLogLog returns a sketch with the precision `p`, which is
+// clamped to [4, 16].
+func NewHyperLogLog(p uint8) *HyperLogLog {
+	if p < 4 {
+		p = 4
+	} else if p > 16 {
+		p = 16
+	}
+	return &HyperLogLog{P: p, regs: make([]uint8, 1<<p)}
+}
+
+func (h *HyperLogLog) Add(key string) {
+	x := hash64(key)
+	i := x >> (64 - h.P)
+	r := uint8(bits.LeadingZeros64(x<<h.P|1<<(h.P-1))) + 1
+	if r > h.regs[i] {
+		h.regs[i] = r
+	}
+}
+
+func (h *HyperLogLog) Count() uint64 {
+	m := float64(len(h.regs))
+	sum, zeros := 0.0, 0
+	for _, r := range h.regs {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	var alpha float64
+	switch len(h.regs) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/m)
+	}
+	e := alpha * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		// linear counting for small cardinalities
+		e = m * math.Log(m/float64(zeros))
+	}
+	return uint64(e + 0.5)
+}
+
+func (h *HyperLogLog) Merge(o Sketch) error {
+	s, ok := o.(*HyperLogLog)
+	if !ok || s.P != h.P {
+		return ErrSketchMismatch
+	}
+	for i, r := range s.regs {
+		if r > h.regs[i] {
+			h.regs[i] = r
+		}
+	}
+	return nil
+}
+
+func (h *HyperLogLog) Reset() {
+	for i := range h.regs {
+		h.regs[i] = 0
+	}
+}
+
+func (h *HyperLogLog) Clone() T {
+	c := NewHyperLogLog(h.P)
+	copy(c.regs, h.regs)
+	return c
+}
+
+func (h *HyperLogLog) Dispose() {}
+
+//#################################################################
+//                   t-digest
+//#################################################################
+
+type centroid struct {
+	Mean, Weight float64
+}
+
+// TDigest estimates quantiles with a merging t-digest. Compression
+// bounds the number of centroids, higher values are more accurate.
+type TDigest struct {
+	Compression float64
+	centroids   []centroid
+	buf         []centroid
+	total       float64
+	min, max    float64
+}
+
+func NewTDigest(compression float64) *TDigest {
+	return &TDigest{Compression: compression, min: math.Inf(1), max: math.Inf(-1)}
+}
+
+func (t *TDigest) Add(x, w float64) {
+	t.buf = append(t.buf, centroid{x, w})
+	t.total += w
+	t.min, t.max = math.Min(t.min, x), math.Max(t.max, x)
+	if len(t.buf) >= int(5*t.Compression) {
+		t.compress()
+	}
+}
+
+// Count returns the total weight added.
+func (t *TDigest) Count() float64 {
+	return t.total
+}
+
+func (t *TDigest) compress() {
+	if len(t.buf) == 0 {
+		return
+	}
+	all := append(t.centroids, t.buf...)
+	sort.Slice(all, func(i, j int) bool { return all[i].Mean < all[j].Mean })
+	merged := make([]centroid, 0, int(2*t.Compression))
+	cur, before := all[0], 0.0
+	for _, c := range all[1:] {
+		w := cur.Weight + c.Weight
+		q := (before + w/2) / t.total
+		if w <= 4*t.total*q*(1-q)/t.Compression {
+			cur.Mean += (c.Mean - cur.Mean) * c.Weight / w
+			cur.Weight = w
+			continue
+		}
+		merged = append(merged, cur)
+		before += cur.Weight
+		cur = c
+	}
+	t.centroids = append(merged, cur)
+	t.buf = t.buf[:0]
+}
+
+// Quantile returns the estimated value at the quantile `q` in [0, 1].
+func (t *TDigest) Quantile(q float64) float64 {
+	t.compress()
+	cs := t.centroids
+	if len(cs) == 0 {
+		return math.NaN()
+	}
+	if len(cs) == 1 {
+		return cs[0].Mean
+	}
+	target := q * t.total
+	if target <= cs[0].Weight/2 {
+		return t.min + (cs[0].Mean-t.min)*target/(cs[0].Weight/2)
+	}
+	cum := cs[0].Weight / 2
+	for i := 1; i < len(cs); i++ {
+		step := (cs[i-1].Weight + cs[i].Weight) / 2
+		if target <= cum+step {
+			return cs[i-1].Mean + (cs[i].Mean-cs[i-1].Mean)*(target-cum)/step
+		}
+		cum += step
+	}
+	last := cs[len(cs)-1]
+	if last.Weight == 0 {
+		return t.max
+	}
+	return last.Mean + (t.max-last.Mean)*math.Min(1, (target-cum)/(last.Weight/2))
+}
+
+func (t *TDigest) Merge(o Sketch) error {
+	s, ok := o.(*TDigest)
+	if !ok {
+		return ErrSketchMismatch
+	}
+	t.buf = append(t.buf, s.centroids...)
+	t.buf = append(t.buf, s.buf...)
+	t.total += s.total
+	t.min, t.max = math.Min(t.min, s.min), math.Max(t.max, s.max)
+	t.compress()
+	return nil
+}
+
+func (t *TDigest) Reset() {
+	t.centroids, t.buf = t.centroids[:0], t.buf[:0]
+	t.total, t.min, t.max = 0, math.Inf(1), math.Inf(-1)
+}
+
+func (t *TDigest) Clone() T {
+	c := *t
+	c.centroids = append([]centroid(nil), t.centroids...)
+	c.buf = append([]centroid(nil), t.buf...)
+	return &c
+}
+
+func (t *TDigest) Dispose() {}
+
+//#################################################################
+//                   Sketch Reducers
+//#################################################################
+
+// SketchState is the reducer state of the sketch functions. It counts
+// the updates since the sketch was last written downstream.
+type SketchState struct {
+	Sketch
+	n int
+}
+
+// Flush writes the updates since the last output as a message and
+// resets the sketch, so that downstream merges add up the partials.
+func (s *SketchState) Flush() T {
+	if s.n == 0 {
+		return nil
+	}
+	y := NewMessage(s.Sketch.Clone())
+	s.Sketch.Reset()
+	s.n = 0
+	return y
+}
+
+// SketchFunction returns a reducer function over a sketch created by
+// `create`. Incoming messages that carry a sketch are merged, all
+// other messages are added by `update`. Every `every` updates (the
+// "every" parameter) the partial sketch is written downstream and nil
+// otherwise. A zero `every` writes the sketch only at the end of the
+// stream, in both cases the remaining updates are flushed at the end.
+// Downstream of a Group, the same function merges the partials of
+// the partitions. The merging stage must use a zero `every`, else it
+// resets its sketch and writes fragments of the merged sketch. A
+// partial that does not merge, e.g. a CountMin of other dimensions,
+// is dropped and its error is written downstream as the message.
+func SketchFunction(name string, create func() Sketch, update func(s Sketch, x T), every int) *Function {
+	return &Function{FuncName: name,
+		FuncParams: Params{"every": Parameter{Value: float64(every), Low: 0, High: math.MaxInt32}},
+		Reducer: func(u, x T, params Params) (T, T) {
+			s, ok := u.(*SketchState)
+			if !ok {
+				s = &SketchState{Sketch: create()}
+			}
+			if o, ok := MessageV(x).(Sketch); ok {
+				if err := s.Merge(o); err != nil {
+					return s, NewMessage(err)
+				}
+			} else {
+				update(s.Sketch, x)
+			}
+			s.n++
+			if every := int(params["every"].Value); every > 0 && s.n >= every {
+				return s, s.Flush()
+			}
+			return s, nil
+		}}
+}
+
+// TopKFunction counts the keys of the messages in a TopK of size `k`.
+func TopKFunction(k int, key func(T) string, every int) *Function {
+	return SketchFunction("topk", func() Sketch { return NewTopK(k) },
+		func(s Sketch, x T) { s.(*TopK).Add(key(x), 1) }, every)
+}
+
+// CountMinFunction counts the keys of the messages in a CountMin
+// sized for the error `eps` and failure probability `delta`.
+func CountMinFunction(eps, delta float64, key func(T) string, every int) *Function {
+	return SketchFunction("countmin", func() Sketch { return NewCountMinEps(eps, delta) },
+		func(s Sketch, x T) { s.(*CountMin).Add(key(x), 1) }, every)
+}
+
+// HyperLogLogFunction counts the distinct keys of the messages.
+func HyperLogLogFunction(p uint8, key func(T) string, every int) *Function {
+	return SketchFunction("hyperloglog", func() Sketch { return NewHyperLogLog(p) },
+		func(s Sketch, x T) { s.(*HyperLogLog).Add(key(x)) }, every)
+}
+
+// TDigestFunction summarizes the values of the messages in a TDigest.
+func TDigestFunction(compression float64, value func(T) float64, every int) *Function {
+	return SketchFunction("tdigest", func() Sketch { return NewTDigest(compression) },
+		func(s Sketch, x T) { s.(*TDigest).Add(value(x), 1) }, every)
+}
@@ -0,0 +1,175 @@
+package loopy
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestTopK(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	z := rand.NewZipf(r, 1.5, 1, 999)
+	a, b := NewTopK(20), NewTopK(20)
+	exact := make(map[string]uint64)
+	for i := 0; i < 20000; i++ {
+		k := fmt.Sprint(z.Uint64())
+		exact[k]++
+		if i%2 == 0 {
+			a.Add(k, 1)
+		} else {
+			b.Add(k, 1)
+		}
+	}
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	top := a.Top()
+	for i := 0; i < 5; i++ {
+		k := fmt.Sprint(i)
+		if top[i].Key != k {
+			t.Errorf("rank %d is %s, expected %s", i, top[i].Key, k)
+		}
+		if c := exact[k]; top[i].Count < c || top[i].Count-top[i].Error > c {
+			t.Errorf("count of %s is %d±%d, exact %d", k, top[i].Count, top[i].Error, c)
+		}
+	}
+}
+
+func TestCountMin(t *testing.T) {
+	a, b := NewCountMinEps(0.001, 0.01), NewCountMinEps(0.001, 0.01)
+	exact := make(map[string]uint64)
+	for i := 0; i < 10000; i++ {
+		k := fmt.Sprint(i % 3000)
+		exact[k]++
+		if i < 5000 {
+			a.Add(k, 1)
+		} else {
+			b.Add(k, 1)
+		}
+	}
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Merge(NewCountMin(10, 2)); err != ErrSketchMismatch {
+		t.Errorf("merging different dimensions should fail")
+	}
+	bound := uint64(0.001 * float64(a.N))
+	for k, c := range exact {
+		if e := a.Count(k); e < c || e > c+bound {
+			t.Errorf("count of %s is %d, exact %d", k, e, c)
+		}
+	}
+}
+
+func TestHyperLogLog(t *testing.T) {
+	tests := []struct {
+		p uint8
+		n int
+	}{{10, 100}, {12, 5000}, {14, 100000}}
+	for _, tt := range tests {
+		a, b := NewHyperLogLog(tt.p), NewHyperLogLog(tt.p)
+		for i := 0; i < tt.n; i++ {
+			a.Add(fmt.Sprint(i))
+			b.Add(fmt.Sprint(i + tt.n/2))
+		}
+		a.Merge(b)
+		want := float64(tt.n + tt.n/2)
+		tol := 3 * 1.04 / math.Sqrt(float64(uint(1)<<tt.p))
+		if e := float64(a.Count()); math.Abs(e-want)/want > tol {
+			t.Errorf("p %d: estimated %v distinct keys, expected %v", tt.p, e, want)
+		}
+	}
+}
+
+func TestTDigest(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	a, b := NewTDigest(100), NewTDigest(100)
+	for i := 0; i < 100000; i++ {
+		if i%3 == 0 {
+			a.Add(r.Float64(), 1)
+		} else {
+			b.Add(r.Float64(), 1)
+		}
+	}
+	a.Merge(b)
+	for _, q := range []float64{0.001, 0.01, 0.25, 0.5, 0.75, 0.99, 0.999} {
+		if v := a.Quantile(q); math.Abs(v-q) > 0.01 {
+			t.Errorf("quantile %v is %v", q, v)
+		}
+	}
+	if a.Count() != 100000 {
+		t.Errorf("count is %v", a.Count())
+	}
+}
+
+func TestSketchFunctionGroup(t *testing.T) {
+	g := NewOGraph()
+	key := func(x T) string { return fmt.Sprint(MessageV(x).(testValue) % 10) }
+	p := func(x T, i int, n int) int { return int(MessageV(x).(testValue)) % n }
+	f := func(x T) []T { return []T{x} }
+	h1 := func(g *OGraph, i int) (*Processor, *Processor) {
+		a := g.Source(&testSpout{max: 100})
+		return a.Proc, a.Proc
+	}
+	h2 := func(g *OGraph, i int) (*Processor, *Processor) {
+		a := g.Reduce(nil, Functions{TopKFunction(10, key, 15)})
+		return a.Proc, a.Proc
+	}
+	merge := g.List(2, h1).Group(2, 3, f, p).List(3, h2).Add().
+		Reduce(nil, Functions{TopKFunction(10, nil, 0)})
+	var top []TopKItem
+	collect := &Function{FuncName: "collect", Reducer: func(u T, x T, params Params) (T, T) {
+		top = MessageV(x).(*TopK).Top()
+		return u, x
+	}}
+	merge.Reduce(nil, Functions{collect}).Ground()
+	g.Execute()
+	g.Wait()
+	if len(top) != 10 {
+		t.Fatalf("merged top has %d items", len(top))
+	}
+	for _, item := range top {
+		if item.Count != 20 || item.Error != 0 {
+			t.Errorf("key %s counted %d±%d, expected 20", item.Key, item.Count, item.Error)
+		}
+	}
+}
+
+func TestSketchFunctionMismatch(t *testing.T) {
+	g := NewOGraph()
+	in := make(chanSpout)
+	var errs []error
+	var merged *CountMin
+	collect := &Function{FuncName: "collect", Reducer: func(u T, x T, params Params) (T, T) {
+		switch v := MessageV(x).(type) {
+		case error:
+			errs = append(errs, v)
+		case *CountMin:
+			merged = v
+		}
+		return u, x
+	}}
+	g.Source(in).Reduce(nil, Functions{CountMinFunction(0.01, 0.01, nil, 0)}, "merge").
+		Reduce(nil, Functions{collect}).Ground()
+	g.EnableMetrics()
+	g.Execute()
+	a, b := NewCountMinEps(0.01, 0.01), NewCountMinEps(0.01, 0.01)
+	a.Add("x", 2)
+	b.Add("x", 3)
+	in <- NewMessage(a)
+	in <- NewMessage(NewCountMin(10, 2))
+	in <- NewMessage(b)
+	close(in)
+	g.Wait()
+
+	if len(errs) != 1 || errs[0] != ErrSketchMismatch {
+		t.Fatalf("expected one mismatch error, got %v", errs)
+	}
+	if merged == nil || merged.Count("x") != 5 {
+		t.Errorf("expected the matching partials to be merged")
+	}
+	if n := g.Get("merge").Metrics.Errors; n != 1 {
+		t.Errorf("expected 1 error in the metrics, got %d", n)
+	}
+}
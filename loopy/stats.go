@@ -0,0 +1,134 @@
+package loopy
+
+import (
+	"gem"
+	"math"
+	"time"
+)
+
+//#################################################################
+//                   Streaming Statistics
+//#################################################################
+
+// StatsResult is written by the statistics functions for every
+// incoming message. Mean and Var include Value, Z holds the z-scores
+// of Value against the statistics before it.
+type StatsResult struct {
+	Key     string
+	Value   gem.Point
+	Mean    gem.Point
+	Var     gem.Point
+	N       float64
+	Z       gem.Point
+	Anomaly bool
+}
+
+func (r *StatsResult) Dispose() {}
+
+// KeyedStats is the reducer state of the statistics functions. It
+// keeps one SStats per key together with the time of its last update.
+type KeyedStats struct {
+	Stats map[string]*SStats
+	last  map[string]time.Time
+}
+
+func NewKeyedStats() *KeyedStats {
+	return &KeyedStats{Stats: make(map[string]*SStats), last: make(map[string]time.Time)}
+}
+
+// get returns the statistics of `key` decayed to `now`, `alpha` is
+// the decay rate per second as in SStats.Decay.
+func (s *KeyedStats) get(key string, d int, alpha float64, now time.Time) *SStats {
+	st, ok := s.Stats[key]
+	if !ok {
+		st = NewSStats(d)
+		s.Stats[key] = st
+	} else if alpha > 0 {
+		st.Decay(alpha, now.Sub(s.last[key]).Seconds())
+	}
+	s.last[key] = now
+	return st
+}
+
+func (s *KeyedStats) Dispose() {}
+
+// zscore returns the z-scores of `x` against `st`.
+func zscore(st *SStats, x gem.Point) gem.Point {
+	mean, vari := st.MV()
+	z := make(gem.Point, len(x))
+	for i := range x {
+		z[i] = (x[i] - mean[i]) / math.Sqrt(vari[i])
+	}
+	return z
+}
+
+// statsReducer updates the statistics of the key of every message and
+// writes a *StatsResult. The parameters "alpha" (decay rate per
+// second, zero keeps running statistics), "threshold" (z-score above
+// which a value is an anomaly, zero disables the flag) and "warmup"
+// (observations before a key is scored) can be tuned at runtime.
+func statsReducer(key func(T) string, value func(T) gem.Point) func(u, x T, params Params) (T, T) {
+	return func(u, x T, params Params) (T, T) {
+		s, ok := u.(*KeyedStats)
+		if !ok {
+			s = NewKeyedStats()
+		}
+		k, v := key(x), value(x)
+		st := s.get(k, len(v), params["alpha"].Value, time.Now())
+		r := &StatsResult{Key: k, Value: v}
+		if st.N >= params["warmup"].Value && st.N > 0 {
+			r.Z = zscore(st, v)
+			if th := params["threshold"].Value; th > 0 && r.Z.AbsMax() > th {
+				r.Anomaly = true
+			}
+		}
+		st.AddPoint(v, 1)
+		r.Mean, r.Var = st.MV()
+		r.N = st.N
+		y := NewMessage(r)
+		InheritHeader(x, []T{y})
+		return s, y
+	}
+}
+
+// MeanVarFunction keeps the running (alpha = 0) or exponentially
+// decayed (alpha > 0) mean and variance of `value` per key.
+func MeanVarFunction(key func(T) string, value func(T) gem.Point, alpha float64) *Function {
+	return &Function{FuncName: "meanvar",
+		FuncParams: Params{"alpha": Parameter{alpha, 0, math.MaxFloat64},
+			"threshold": Parameter{0, 0, math.MaxFloat64},
+			"warmup":    Parameter{1, 0, math.MaxFloat64}},
+		Reducer: statsReducer(key, value)}
+}
+
+// ZScoreFunction is MeanVarFunction that also flags the values whose
+// z-score exceeds `threshold` once a key has `warmup` observations.
+func ZScoreFunction(key func(T) string, value func(T) gem.Point, alpha, threshold float64, warmup int) *Function {
+	return &Function{FuncName: "zscore",
+		FuncParams: Params{"alpha": Parameter{alpha, 0, math.MaxFloat64},
+			"threshold": Parameter{threshold, 0, math.MaxFloat64},
+			"warmup":    Parameter{float64(warmup), 0, math.MaxFloat64}},
+		Reducer: statsReducer(key, value)}
+}
+
+// EWMAFunction smooths `value` per key with the weight `a` in (0, 1]
+// of the newest observation. It writes the smoothed gem.Point, which
+// is the mean of an SStats decayed by 1-a at every observation, so it
+// is unbiased from the first observation on.
+func EWMAFunction(key func(T) string, value func(T) gem.Point, a float64) *Function {
+	return &Function{FuncName: "ewma",
+		FuncParams: Params{"a": Parameter{a, 0, 1}},
+		Reducer: func(u, x T, params Params) (T, T) {
+			s, ok := u.(*KeyedStats)
+			if !ok {
+				s = NewKeyedStats()
+			}
+			k, v := key(x), value(x)
+			st := s.get(k, len(v), 0, time.Time{})
+			st.Decay(-math.Log2(1-params["a"].Value), 1)
+			st.AddPoint(v, 1)
+			y := NewMessage(st.Mean())
+			InheritHeader(x, []T{y})
+			return s, y
+		}}
+}
@@ -0,0 +1,82 @@
+package loopy
+
+import (
+	"fmt"
+	"gem"
+	"math"
+	"testing"
+)
+
+type statsSpout struct {
+	values []float64
+	n      int
+}
+
+func (s *statsSpout) Read() T {
+	if s.n >= len(s.values) {
+		return nil
+	}
+	s.n++
+	return NewMessage(testValue(s.n - 1))
+}
+
+func runStats(values []float64, f *Function) []T {
+	g := NewOGraph()
+	var out []T
+	collect := &Function{FuncName: "collect", Reducer: func(u, x T, params Params) (T, T) {
+		out = append(out, MessageV(x))
+		return u, x
+	}}
+	g.Source(&statsSpout{values: values}).Reduce(nil, Functions{f}).Reduce(nil, Functions{collect}).Ground()
+	g.Execute()
+	g.Wait()
+	return out
+}
+
+func TestMeanVar(t *testing.T) {
+	values := []float64{1, 10, 3, 20, 5, 30}
+	value := func(x T) gem.Point { return gem.Point{values[int(MessageV(x).(testValue))]} }
+	key := func(x T) string { return fmt.Sprint(int(MessageV(x).(testValue)) % 2) }
+	out := runStats(values, MeanVarFunction(key, value, 0))
+	last := map[string]*StatsResult{}
+	for _, y := range out {
+		r := y.(*StatsResult)
+		last[r.Key] = r
+	}
+	if r := last["0"]; r.N != 3 || math.Abs(r.Mean[0]-3) > 1e-9 || math.Abs(r.Var[0]-8.0/3) > 1e-9 {
+		t.Errorf("key 0: n %v mean %v var %v", r.N, r.Mean, r.Var)
+	}
+	if r := last["1"]; r.N != 3 || math.Abs(r.Mean[0]-20) > 1e-9 || math.Abs(r.Var[0]-200.0/3) > 1e-9 {
+		t.Errorf("key 1: n %v mean %v var %v", r.N, r.Mean, r.Var)
+	}
+}
+
+func TestZScore(t *testing.T) {
+	values := make([]float64, 40)
+	for i := range values {
+		values[i] = float64(i % 4)
+	}
+	values[36] = 100
+	value := func(x T) gem.Point { return gem.Point{values[int(MessageV(x).(testValue))]} }
+	key := func(x T) string { return "k" }
+	out := runStats(values, ZScoreFunction(key, value, 0, 4, 10))
+	for i, y := range out {
+		if r := y.(*StatsResult); r.Anomaly != (i == 36) {
+			t.Errorf("value %d (%v) flagged %v, z %v", i, r.Value, r.Anomaly, r.Z)
+		}
+	}
+}
+
+func TestEWMA(t *testing.T) {
+	values := []float64{2, 2, 2, 10, 10}
+	value := func(x T) gem.Point { return gem.Point{values[int(MessageV(x).(testValue))]} }
+	key := func(x T) string { return "k" }
+	out := runStats(values, EWMAFunction(key, value, 0.5))
+	// weights of the two newest values are 1 and 1/2 over a total of 1+1/2+1/4+1/8+1/16
+	want := []float64{2, 2, 2, 2 + 8*8.0/15, 2 + 8*24.0/31}
+	for i, y := range out {
+		if p := y.(gem.Point); math.Abs(p[0]-want[i]) > 1e-9 {
+			t.Errorf("ewma %d is %v, expected %v", i, p[0], want[i])
+		}
+	}
+}
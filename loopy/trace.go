@@ -0,0 +1,226 @@
+package loopy
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//#################################################################
+//                   Trace Context
+//#################################################################
+
+// TraceContext follows a sampled message through the graph. It maps
+// the Id of every processor the message passed to the id of its span.
+type TraceContext struct {
+	TraceId [16]byte
+	Spans   map[uint64][8]byte
+}
+
+func (c *TraceContext) Clone() *TraceContext {
+	if c == nil {
+		return nil
+	}
+	spans := make(map[uint64][8]byte, len(c.Spans))
+	for k, v := range c.Spans {
+		spans[k] = v
+	}
+	return &TraceContext{c.TraceId, spans}
+}
+
+// Sampler decides at the source whether a message is traced.
+type Sampler func(x *M) bool
+
+func AlwaysSample(x *M) bool {
+	return true
+}
+
+// RatioSampler traces the given ratio of the source messages.
+func RatioSampler(ratio float64) Sampler {
+	return func(x *M) bool {
+		return rand.Float64() < ratio
+	}
+}
+
+//#################################################################
+//                   Spans
+//#################################################################
+
+// Span covers the time a message spent inside one processor. The
+// json encoding follows the OpenTelemetry (OTLP/JSON) span format.
+type Span struct {
+	TraceId      string      `json:"traceId"`
+	SpanId       string      `json:"spanId"`
+	ParentSpanId string      `json:"parentSpanId,omitempty"`
+	Name         string      `json:"name"`
+	Kind         int         `json:"kind"`
+	Start        string      `json:"startTimeUnixNano"`
+	End          string      `json:"endTimeUnixNano"`
+	Attributes   []Attribute `json:"attributes,omitempty"`
+}
+
+type Attribute struct {
+	Key   string         `json:"key"`
+	Value AttributeValue `json:"value"`
+}
+
+type AttributeValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// SpanExporter receives the finished spans in batches.
+type SpanExporter interface {
+	ExportSpans(spans []*Span) error
+}
+
+// JSONExporter writes every batch of spans as one OTLP/JSON
+// ExportTraceServiceRequest per line.
+type JSONExporter struct {
+	W       io.Writer
+	Service string
+	mutex   *sync.Mutex
+}
+
+func NewJSONExporter(w io.Writer, service string) *JSONExporter {
+	return &JSONExporter{W: w, Service: service, mutex: &sync.Mutex{}}
+}
+
+func (e *JSONExporter) ExportSpans(spans []*Span) error {
+	type scope struct {
+		Name string `json:"name"`
+	}
+	type scopeSpans struct {
+		Scope scope   `json:"scope"`
+		Spans []*Span `json:"spans"`
+	}
+	type resource struct {
+		Attributes []Attribute `json:"attributes"`
+	}
+	type resourceSpans struct {
+		Resource   resource     `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+	req := struct {
+		ResourceSpans []resourceSpans `json:"resourceSpans"`
+	}{[]resourceSpans{{
+		resource{[]Attribute{{"service.name", AttributeValue{e.Service}}}},
+		[]scopeSpans{{scope{"loopy"}, spans}}}}}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	_, err = e.W.Write(append(data, '\n'))
+	return err
+}
+
+//#################################################################
+//                   Tracer
+//#################################################################
+
+// Tracer collects the spans of sampled messages and hands them to
+// the exporter once BatchSize spans are buffered or on Flush.
+type Tracer struct {
+	Exporter  SpanExporter
+	Sample    Sampler
+	BatchSize int
+	spans     []*Span
+	mutex     *sync.Mutex
+}
+
+func NewTracer(exporter SpanExporter, sample Sampler) *Tracer {
+	return &Tracer{Exporter: exporter, Sample: sample, BatchSize: 128,
+		spans: make([]*Span, 0, 128), mutex: &sync.Mutex{}}
+}
+
+func (t *Tracer) record(s *Span) {
+	t.mutex.Lock()
+	t.spans = append(t.spans, s)
+	full := len(t.spans) >= t.BatchSize
+	t.mutex.Unlock()
+	if full {
+		t.Flush()
+	}
+}
+
+// Flush exports all buffered spans.
+func (t *Tracer) Flush() error {
+	t.mutex.Lock()
+	spans := t.spans
+	t.spans = make([]*Span, 0, t.BatchSize)
+	t.mutex.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+	return t.Exporter.ExportSpans(spans)
+}
+
+// ProcTracer creates the spans of one processor. The parent of a
+// span is the span of the upstream processor (a graph edge into this
+// processor) that released the message last.
+type ProcTracer struct {
+	*Tracer
+	name     string
+	id       uint64
+	source   bool
+	upstream []uint64
+	attribs  []Attribute
+}
+
+// EnableTracing attaches the tracer `t` to every processor of the
+// graph. Sources start a trace for the messages accepted by the
+// sampler. It must be called after the graph is built and before
+// Execute.
+func (g *OGraph) EnableTracing(t *Tracer) {
+	for name, n := range g.Nodes_map {
+		proc := (*n.Value).(*Processor)
+		if proc.IsComposite {
+			continue
+		}
+		proc.Tracer = &ProcTracer{Tracer: t, name: name, id: proc.Id,
+			source: proc._type == OP_SOURCE, upstream: g.upstreamIds(name),
+			attribs: []Attribute{
+				{"loopy.processor.type", AttributeValue{opNames[proc._type]}},
+				{"loopy.processor.id", AttributeValue{strconv.FormatUint(proc.Id, 10)}}}}
+	}
+}
+
+func (p *ProcTracer) observe(t int, c *M, tinfo TimeInfo) {
+	if (t == PROC_ENTER_TIME || t == PROC_BOTH_TIME) && c.Trace == nil && p.source && p.Sample(c) {
+		c.Trace = &TraceContext{Spans: map[uint64][8]byte{}}
+		randBytes(c.Trace.TraceId[:])
+	}
+	if c.Trace == nil || (t != PROC_LEAVE_TIME && t != PROC_BOTH_TIME) {
+		return
+	}
+	var (
+		latest time.Time
+		id     [8]byte
+	)
+	s := &Span{TraceId: hex.EncodeToString(c.Trace.TraceId[:]), Name: p.name, Kind: 1,
+		Start:      strconv.FormatInt(tinfo.InTime.UnixNano(), 10),
+		End:        strconv.FormatInt(tinfo.OutTime.UnixNano(), 10),
+		Attributes: p.attribs}
+	for _, u := range p.upstream {
+		ui, _ := c.TmInfo.Get(u)
+		if pid, ok := c.Trace.Spans[u]; ok && ui.OutTime.After(latest) {
+			latest = ui.OutTime
+			s.ParentSpanId = hex.EncodeToString(pid[:])
+		}
+	}
+	randBytes(id[:])
+	s.SpanId = hex.EncodeToString(id[:])
+	c.Trace.Spans[p.id] = id
+	p.record(s)
+}
+
+func randBytes(b []byte) {
+	for i := range b {
+		b[i] = byte(rand.Intn(256))
+	}
+}
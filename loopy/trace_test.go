@@ -0,0 +1,128 @@
+package loopy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+type testExporter struct {
+	spans []*Span
+}
+
+func (e *testExporter) ExportSpans(spans []*Span) error {
+	e.spans = append(e.spans, spans...)
+	return nil
+}
+
+func TestTracingSpans(t *testing.T) {
+	g := NewOGraph()
+	id := &Function{FuncName: "id", Mapper: func(x T, params Params) T { return x }}
+	g.Source(&testSpout{max: 4}, "src").Map(Functions{id}, "map").Ground("gnd")
+	exp := &testExporter{}
+	tr := NewTracer(exp, AlwaysSample)
+	g.EnableTracing(tr)
+	g.Execute()
+	g.Wait()
+	tr.Flush()
+
+	if len(exp.spans) != 12 {
+		t.Fatalf("expected 12 spans, got %d", len(exp.spans))
+	}
+	ids := map[string]*Span{}
+	for _, s := range exp.spans {
+		ids[s.SpanId] = s
+	}
+	parents := map[string]string{"src": "", "map": "src", "gnd": "map"}
+	for _, s := range exp.spans {
+		if s.ParentSpanId == "" {
+			if parents[s.Name] != "" {
+				t.Errorf("span %s has no parent", s.Name)
+			}
+			continue
+		}
+		p, ok := ids[s.ParentSpanId]
+		if !ok || p.Name != parents[s.Name] || p.TraceId != s.TraceId {
+			t.Errorf("span %s has a wrong parent", s.Name)
+		}
+	}
+}
+
+func TestTracingScatter(t *testing.T) {
+	g := NewOGraph()
+	// every sentence is split into three words
+	words := func(x T) []T {
+		v := make([]T, 3)
+		for i := range v {
+			v[i] = NewMessage(fmt.Sprintf("%d-%d", MessageV(x).(testValue), i))
+		}
+		return v
+	}
+	p := func(x T, i int, n int) int { return i % n }
+	count := &Function{FuncName: "count", Reducer: func(u T, x T, params Params) (T, T) {
+		return u.(int) + 1, x
+	}}
+	g.Source(&testSpout{max: 2}, "src").Scatter(2, words, p, "scatter").Add("add").
+		Reduce(0, Functions{count}, "reduce").Ground("gnd")
+	exp := &testExporter{}
+	tr := NewTracer(exp, AlwaysSample)
+	g.EnableTracing(tr)
+	g.Execute()
+	g.Wait()
+	tr.Flush()
+
+	// 2 source spans and 6 words through scatter, add, reduce and gnd
+	if len(exp.spans) != 26 {
+		t.Fatalf("expected 26 spans, got %d", len(exp.spans))
+	}
+	ids := map[string]*Span{}
+	for _, s := range exp.spans {
+		ids[s.SpanId] = s
+	}
+	chain := []string{"gnd", "reduce", "add", "scatter", "src"}
+	sentences := map[string]int{}
+	for _, s := range exp.spans {
+		if s.Name != "gnd" {
+			continue
+		}
+		c := s
+		for i, name := range chain {
+			if c.Name != name || c.TraceId != s.TraceId {
+				t.Fatalf("span %d of the chain is %s in trace %s, expected %s in trace %s",
+					i, c.Name, c.TraceId, name, s.TraceId)
+			}
+			if i == len(chain)-1 {
+				break
+			}
+			if c = ids[c.ParentSpanId]; c == nil {
+				t.Fatalf("span %s has no parent", name)
+			}
+		}
+		if c.ParentSpanId != "" {
+			t.Errorf("source span has a parent")
+		}
+		sentences[c.SpanId]++
+	}
+	if len(sentences) != 2 {
+		t.Errorf("expected the words of 2 sentences, got %d", len(sentences))
+	}
+	for id, n := range sentences {
+		if n != 3 {
+			t.Errorf("sentence %s has %d words", id, n)
+		}
+	}
+}
+
+func TestJSONExporter(t *testing.T) {
+	var buf bytes.Buffer
+	e := NewJSONExporter(&buf, "test")
+	e.ExportSpans([]*Span{{TraceId: "01", SpanId: "02", Name: "map", Kind: 1, Start: "1", End: "2"}})
+	var req map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &req); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := req["resourceSpans"]; !ok {
+		t.Errorf("expected resourceSpans in %s", buf.String())
+	}
+}
@@ -0,0 +1,429 @@
+package loopy
+
+import (
+	"bytes"
+	"encoding/gob"
+	"gem"
+	"math"
+	"sync"
+)
+
+//#################################################################
+//                   Kalman Filter
+//#################################################################
+
+// KalmanCV is a constant-velocity Kalman filter of the vector X with
+// velocity V. The coordinates are filtered independently, PXX, PXV and
+// PVV holding the variances of X, the covariances of X and V, and the
+// variances of V.
+type KalmanCV struct {
+	X, V          gem.Point
+	PXX, PXV, PVV gem.Point
+}
+
+// NewKalmanCV starts a filter at `x` at rest, with the variance `xv`
+// on the position and `vv` on the velocity.
+func NewKalmanCV(x gem.Point, xv, vv float64) *KalmanCV {
+	n := len(x)
+	return &KalmanCV{X: x.Clone(), V: gem.NewPoint(n, 0),
+		PXX: gem.NewPoint(n, xv), PXV: gem.NewPoint(n, 0), PVV: gem.NewPoint(n, vv)}
+}
+
+// Predict moves the state `dt` ahead, `q` being the variance of the
+// white noise acceleration.
+func (k *KalmanCV) Predict(dt, q float64) {
+	for i := range k.X {
+		k.X[i] += k.V[i] * dt
+		k.PXX[i] += 2*dt*k.PXV[i] + dt*dt*k.PVV[i] + q*dt*dt*dt/3
+		k.PXV[i] += dt*k.PVV[i] + q*dt*dt/2
+		k.PVV[i] += q * dt
+	}
+}
+
+// Update corrects the state with the measure `z` of X of variance `r`.
+func (k *KalmanCV) Update(z gem.Point, r float64) {
+	if len(z) != len(k.X) {
+		panic(gem.DimError{Expected: len(k.X), Actual: len(z)})
+	}
+	for i := range k.X {
+		s := k.PXX[i] + r
+		kx, kv := k.PXX[i]/s, k.PXV[i]/s
+		e := z[i] - k.X[i]
+		k.X[i] += kx * e
+		k.V[i] += kv * e
+		k.PVV[i] -= kv * k.PXV[i]
+		k.PXX[i] *= 1 - kx
+		k.PXV[i] *= 1 - kx
+	}
+}
+
+func (k *KalmanCV) Clone() *KalmanCV {
+	return &KalmanCV{k.X.Clone(), k.V.Clone(), k.PXX.Clone(), k.PXV.Clone(), k.PVV.Clone()}
+}
+
+//#################################################################
+//                   Tracking
+//#################################################################
+
+// Association of the detections to the tracks
+const (
+	TRACK_IOU      = iota // by overlap, Gate is the least IoU
+	TRACK_DISTANCE        // by center distance, Gate is the largest one
+)
+
+// Detection is an object found in a frame with the detector confidence
+// Score.
+type Detection struct {
+	Box   *gem.Rect
+	Score float64
+}
+
+// IoU returns the intersection over union of two rectangles.
+func IoU(r1, r2 *gem.Rect) float64 {
+	i := gem.Intersect(r1, r2)
+	if i == nil {
+		return 0
+	}
+	u := r1.Size() + r2.Size() - i.Size()
+	if u <= 0 {
+		return 0
+	}
+	return i.Size() / u
+}
+
+// Track follows an object with a filter over the center and the half
+// lengths of its box. Hits counts the frames it was detected in,
+// Missed the frames since it was last detected.
+type Track struct {
+	Id        uint64
+	Filter    *KalmanCV
+	Score     float64
+	Age       int
+	Hits      int
+	Missed    int
+	Confirmed bool
+}
+
+// Box returns the estimated box of the track.
+func (t *Track) Box() *gem.Rect {
+	d := len(t.Filter.X) / 2
+	r := &gem.Rect{C: t.Filter.X[:d].Clone(), P: t.Filter.X[d:].Clone()}
+	for i := range r.P {
+		r.P[i] = math.Max(r.P[i], 0)
+	}
+	return r
+}
+
+func (t *Track) Clone() *Track {
+	c := *t
+	c.Filter = t.Filter.Clone()
+	return &c
+}
+
+// TrackState is a confirmed track in a frame. Detection indexes the
+// detection matched in the frame, -1 if the track coasted on its
+// prediction, and New is set on the frame the track is confirmed.
+type TrackState struct {
+	Id        uint64
+	Box       *gem.Rect
+	Velocity  gem.Point
+	Score     float64
+	Detection int
+	New       bool
+}
+
+// TrackResult lists the confirmed tracks after a frame and the ids of
+// the ones that Ended with it.
+type TrackResult struct {
+	Frame  uint64
+	Tracks []TrackState
+	Ended  []uint64
+}
+
+func (r *TrackResult) Dispose() {}
+
+// Tracker maintains tracks across frames of detections. Detections
+// scoring below MinScore are ignored, the others are assigned to the
+// predicted tracks optimally under the Match criterion and its Gate,
+// and the unassigned ones start new tracks. A track is confirmed after
+// MinHits detections and dropped after more than MaxMissed frames
+// without any, or after its first miss while still unconfirmed. Q and
+// R are the process and measurement noises of the filters, per frame
+// and in the unit of the boxes. Ids are never reused. A Tracker is the
+// reducer state of a TrackFunction, so it can be saved and restored
+// with MarshalBinary and UnmarshalBinary.
+type Tracker struct {
+	Match     int
+	Gate      float64
+	MinScore  float64
+	MinHits   int
+	MaxMissed int
+	Q, R      float64
+	Tracks    []*Track
+	NextId    uint64
+	Frame     uint64
+	mutex     *sync.Mutex
+}
+
+func NewTracker(match int, gate float64, minHits, maxMissed int) *Tracker {
+	return &Tracker{Match: match, Gate: gate, MinHits: gem.IntMax(minHits, 1), MaxMissed: maxMissed,
+		Q: 1, R: 1, mutex: &sync.Mutex{}}
+}
+
+// cost returns the cost of assigning the detection `d` to a track
+// predicted at `box`, and false if the pair is out of the gate.
+func (tr *Tracker) cost(box, d *gem.Rect) (float64, bool) {
+	if tr.Match == TRACK_DISTANCE {
+		dist := box.C.Dist(d.C)
+		return dist, dist <= tr.Gate
+	}
+	iou := IoU(box, d)
+	return 1 - iou, iou > 0 && iou >= tr.Gate
+}
+
+// Step advances the tracks by one frame of detections.
+func (tr *Tracker) Step(dets []*Detection) *TrackResult {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	tr.Frame++
+	r := &TrackResult{Frame: tr.Frame}
+	var kept []int
+	for i, d := range dets {
+		if d.Score >= tr.MinScore {
+			kept = append(kept, i)
+		}
+	}
+	boxes := make([]*gem.Rect, len(tr.Tracks))
+	for i, t := range tr.Tracks {
+		t.Filter.Predict(1, tr.Q)
+		t.Age++
+		boxes[i] = t.Box()
+	}
+	// gated out pairs cost more than any full assignment of valid ones
+	cost := make([][]float64, len(tr.Tracks))
+	valid, max := make([][]bool, len(tr.Tracks)), 0.0
+	for i := range tr.Tracks {
+		cost[i], valid[i] = make([]float64, len(kept)), make([]bool, len(kept))
+		for j, k := range kept {
+			cost[i][j], valid[i][j] = tr.cost(boxes[i], dets[k].Box)
+			if valid[i][j] {
+				max = math.Max(max, cost[i][j])
+			}
+		}
+	}
+	big := float64(len(tr.Tracks)+len(kept)+1) * (max + 1)
+	for i := range cost {
+		for j := range cost[i] {
+			if !valid[i][j] {
+				cost[i][j] = big
+			}
+		}
+	}
+	match := make([]int, len(tr.Tracks))
+	used := make([]bool, len(kept))
+	for i, j := range assign(cost) {
+		if match[i] = -1; j >= 0 && valid[i][j] {
+			match[i], used[j] = kept[j], true
+		}
+	}
+	var alive []*Track
+	for i, t := range tr.Tracks {
+		if k := match[i]; k >= 0 {
+			d := dets[k]
+			t.Filter.Update(append(d.Box.C.Clone(), d.Box.P...), tr.R)
+			t.Score, t.Missed = d.Score, 0
+			t.Hits++
+		} else {
+			t.Missed++
+		}
+		if t.Missed > tr.MaxMissed || !t.Confirmed && t.Missed > 0 {
+			if t.Confirmed {
+				r.Ended = append(r.Ended, t.Id)
+			}
+			continue
+		}
+		alive = append(alive, t)
+		r.report(t, match[i], tr.MinHits)
+	}
+	for j, k := range kept {
+		if used[j] {
+			continue
+		}
+		d := dets[k]
+		tr.NextId++
+		t := &Track{Id: tr.NextId, Score: d.Score, Age: 1, Hits: 1,
+			Filter: NewKalmanCV(append(d.Box.C.Clone(), d.Box.P...), tr.R, 10*tr.R)}
+		alive = append(alive, t)
+		r.report(t, k, tr.MinHits)
+	}
+	tr.Tracks = alive
+	return r
+}
+
+// report confirms the track `t` if it has enough hits and lists the
+// confirmed ones.
+func (r *TrackResult) report(t *Track, det, minHits int) {
+	isNew := !t.Confirmed && t.Hits >= minHits
+	t.Confirmed = t.Confirmed || isNew
+	if t.Confirmed {
+		d := len(t.Filter.V) / 2
+		r.Tracks = append(r.Tracks, TrackState{t.Id, t.Box(), t.Filter.V[:d].Clone(), t.Score, det, isNew})
+	}
+}
+
+// Len returns the number of tracks, tentative ones included.
+func (tr *Tracker) Len() int {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	return len(tr.Tracks)
+}
+
+func (tr *Tracker) Clone() T {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	c := *tr
+	c.mutex = &sync.Mutex{}
+	c.Tracks = make([]*Track, len(tr.Tracks))
+	for i, t := range tr.Tracks {
+		c.Tracks[i] = t.Clone()
+	}
+	return &c
+}
+
+func (tr *Tracker) Dispose() {}
+
+type trackerCheckpoint struct {
+	Match, MinHits, MaxMissed int
+	Gate, MinScore, Q, R      float64
+	Tracks                    []*Track
+	NextId, Frame             uint64
+}
+
+func (tr *Tracker) MarshalBinary() ([]byte, error) {
+	tr.mutex.Lock()
+	c := trackerCheckpoint{tr.Match, tr.MinHits, tr.MaxMissed, tr.Gate, tr.MinScore, tr.Q, tr.R,
+		tr.Tracks, tr.NextId, tr.Frame}
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(c)
+	tr.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (tr *Tracker) UnmarshalBinary(data []byte) error {
+	var c trackerCheckpoint
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&c); err != nil {
+		return err
+	}
+	r := NewTracker(c.Match, c.Gate, c.MinHits, c.MaxMissed)
+	r.MinScore, r.Q, r.R = c.MinScore, c.Q, c.R
+	r.Tracks, r.NextId, r.Frame = c.Tracks, c.NextId, c.Frame
+	*tr = *r
+	return nil
+}
+
+// assign solves the assignment problem of least total cost for the
+// matrix `cost` by the Hungarian method, and returns the column of
+// each row, -1 for the rows left over when there are fewer columns.
+func assign(cost [][]float64) []int {
+	n := len(cost)
+	if n == 0 {
+		return nil
+	}
+	m := len(cost[0])
+	if n > m {
+		// assign the columns to the rows instead
+		t := make([][]float64, m)
+		for j := range t {
+			t[j] = make([]float64, n)
+			for i := range cost {
+				t[j][i] = cost[i][j]
+			}
+		}
+		ret := make([]int, n)
+		for i := range ret {
+			ret[i] = -1
+		}
+		for j, i := range assign(t) {
+			ret[i] = j
+		}
+		return ret
+	}
+	// potentials u and v, p[j] the row assigned to column j, 1-based
+	// with column 0 as the row being inserted
+	u, v := make([]float64, n+1), make([]float64, m+1)
+	p, way := make([]int, m+1), make([]int, m+1)
+	minv, used := make([]float64, m+1), make([]bool, m+1)
+	for i := 1; i <= n; i++ {
+		p[0] = i
+		j0 := 0
+		for j := range minv {
+			minv[j], used[j] = math.Inf(1), false
+		}
+		for p[j0] != 0 {
+			used[j0] = true
+			i0, delta, j1 := p[j0], math.Inf(1), 0
+			for j := 1; j <= m; j++ {
+				if used[j] {
+					continue
+				}
+				if c := cost[i0-1][j-1] - u[i0] - v[j]; c < minv[j] {
+					minv[j], way[j] = c, j0
+				}
+				if minv[j] < delta {
+					delta, j1 = minv[j], j
+				}
+			}
+			for j := 0; j <= m; j++ {
+				if used[j] {
+					u[p[j]] += delta
+					v[j] -= delta
+				} else {
+					minv[j] -= delta
+				}
+			}
+			j0 = j1
+		}
+		for j0 != 0 {
+			j1 := way[j0]
+			p[j0] = p[j1]
+			j0 = j1
+		}
+	}
+	ret := make([]int, n)
+	for j := 1; j <= m; j++ {
+		if p[j] != 0 {
+			ret[p[j]-1] = j - 1
+		}
+	}
+	return ret
+}
+
+// TrackFunction returns a reducer over frames, the detections of which
+// are extracted by `detections`, that maintains a Tracker and writes a
+// *TrackResult for every frame. A *Tracker given as the initial state
+// of the Reduce, for instance restored from a checkpoint, is resumed.
+// The parameters "gate", "score", the least score of a detection,
+// "hits" and "missed" can be tuned at runtime.
+func TrackFunction(detections func(T) []*Detection, match int, gate float64, minHits, maxMissed int) *Function {
+	return &Function{FuncName: "track",
+		FuncParams: Params{"gate": Parameter{gate, 0, math.MaxFloat64},
+			"score":  Parameter{0, 0, math.MaxFloat64},
+			"hits":   Parameter{float64(minHits), 1, math.MaxInt32},
+			"missed": Parameter{float64(maxMissed), 0, math.MaxInt32}},
+		Reducer: func(u, x T, params Params) (T, T) {
+			tr, ok := u.(*Tracker)
+			if !ok {
+				tr = NewTracker(match, gate, minHits, maxMissed)
+			}
+			tr.mutex.Lock()
+			tr.Gate, tr.MinScore = params["gate"].Value, params["score"].Value
+			tr.MinHits, tr.MaxMissed = int(params["hits"].Value), int(params["missed"].Value)
+			tr.mutex.Unlock()
+			y := NewMessage(tr.Step(detections(x)))
+			InheritHeader(x, []T{y})
+			return tr, y
+		}}
+}
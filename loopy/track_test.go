@@ -0,0 +1,142 @@
+package loopy
+
+import (
+	"gem"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestAssign(t *testing.T) {
+	tests := []struct {
+		cost [][]float64
+		cols []int
+	}{
+		{nil, nil},
+		{[][]float64{{4, 1, 3}, {2, 0, 5}, {3, 2, 2}}, []int{1, 0, 2}},
+		{[][]float64{{1, 2, 9}, {1, 9, 9}}, []int{1, 0}},
+		{[][]float64{{5}, {1}, {3}}, []int{-1, 0, -1}},
+	}
+	for _, tt := range tests {
+		if got := assign(tt.cost); !reflect.DeepEqual(got, tt.cols) {
+			t.Errorf("%v assigned %v, expected %v", tt.cost, got, tt.cols)
+		}
+	}
+}
+
+func box(x, y float64) *gem.Rect {
+	return &gem.Rect{C: gem.Point{x, y}, P: gem.Point{5, 5}}
+}
+
+// trackFrames moves an object right from (0; 0) and another left from
+// (100; 50) by 2 per frame. The first one is missed on frame 5, the
+// second one leaves after frame 8, and a false detection shows on
+// frame 2.
+func trackFrames() [][]*Detection {
+	var frames [][]*Detection
+	for f := 1; f <= 12; f++ {
+		var dets []*Detection
+		if f != 5 {
+			dets = append(dets, &Detection{box(2*float64(f), 0), 0.9})
+		}
+		if f <= 8 {
+			dets = append(dets, &Detection{box(100-2*float64(f), 50), 0.8})
+		}
+		if f == 2 {
+			dets = append(dets, &Detection{box(50, 90), 0.4})
+		}
+		frames = append(frames, dets)
+	}
+	return frames
+}
+
+func TestTracker(t *testing.T) {
+	for _, match := range []int{TRACK_IOU, TRACK_DISTANCE} {
+		gate := 0.3
+		if match == TRACK_DISTANCE {
+			gate = 5
+		}
+		tr := NewTracker(match, gate, 3, 2)
+		for f, dets := range trackFrames() {
+			r := tr.Step(dets)
+			frame := f + 1
+			ids := make(map[uint64]TrackState)
+			for _, s := range r.Tracks {
+				ids[s.Id] = s
+			}
+			a, okA := ids[1]
+			b, okB := ids[2]
+			switch {
+			case frame < 3:
+				if len(r.Tracks) != 0 {
+					t.Errorf("%d: frame %d reports tentative tracks %v", match, frame, r.Tracks)
+				}
+			case frame <= 8:
+				if len(r.Tracks) != 2 || !okA || !okB || a.New != (frame == 3) {
+					t.Fatalf("%d: frame %d tracks %+v", match, frame, r.Tracks)
+				}
+				if frame == 5 && a.Detection != -1 || frame != 5 && a.Detection != 0 {
+					t.Errorf("%d: frame %d matched %d", match, frame, a.Detection)
+				}
+				if math.Abs(b.Box.C[0]-(100-2*float64(frame))) > 1 || math.Abs(a.Box.C[0]-2*float64(frame)) > 1.5 {
+					t.Errorf("%d: frame %d boxes %v %v", match, frame, a.Box, b.Box)
+				}
+			case frame == 11:
+				if len(r.Tracks) != 1 || !okA || !reflect.DeepEqual(r.Ended, []uint64{2}) {
+					t.Errorf("%d: frame %d tracks %+v ended %v", match, frame, r.Tracks, r.Ended)
+				}
+			}
+			if frame == 12 && (math.Abs(a.Velocity[0]-2) > 0.1 || math.Abs(a.Velocity[1]) > 0.1) {
+				t.Errorf("%d: velocity %v", match, a.Velocity)
+			}
+		}
+		if tr.NextId != 3 || tr.Len() != 1 {
+			t.Errorf("%d: %d ids, %d tracks", match, tr.NextId, tr.Len())
+		}
+	}
+}
+
+func TestTrackerCheckpoint(t *testing.T) {
+	frames := trackFrames()
+	tr := NewTracker(TRACK_IOU, 0.3, 3, 2)
+	for _, dets := range frames[:6] {
+		tr.Step(dets)
+	}
+	data, err := tr.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, clone := new(Tracker), tr.Clone().(*Tracker)
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	for _, dets := range frames[6:] {
+		r := tr.Step(dets)
+		if r2, r3 := restored.Step(dets), clone.Step(dets); !reflect.DeepEqual(r, r2) || !reflect.DeepEqual(r, r3) {
+			t.Fatalf("frame %d: %+v, restored %+v, cloned %+v", r.Frame, r, r2, r3)
+		}
+	}
+}
+
+func TestTrackFunction(t *testing.T) {
+	var values []T
+	for _, dets := range trackFrames() {
+		values = append(values, dets)
+	}
+	var out []*TrackResult
+	collect := &Function{FuncName: "collect", Reducer: func(u, x T, params Params) (T, T) {
+		out = append(out, MessageV(x).(*TrackResult))
+		return u, x
+	}}
+	f := TrackFunction(func(x T) []*Detection { return MessageV(x).([]*Detection) }, TRACK_IOU, 0.3, 3, 2)
+	g := NewOGraph()
+	g.Source(&sliceSpout{values: values}).Reduce(nil, Functions{f}).Reduce(nil, Functions{collect}).Ground()
+	g.Execute()
+	g.Wait()
+	if len(out) != len(values) {
+		t.Fatalf("%d results", len(out))
+	}
+	if r := out[7]; r.Frame != 8 || len(r.Tracks) != 2 || r.Tracks[0].Id != 1 || r.Tracks[1].Id != 2 {
+		t.Errorf("frame 8: %+v", r)
+	}
+}
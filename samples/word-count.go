@@ -3,10 +3,12 @@ package main
 import (
 	"bytes"
 	"encoding/gob"
+	"flag"
 	"fmt"
 	"hash/fnv"
 	"loopy"
 	"math/rand"
+	"os"
 	"strings"
 	"time"
 )
@@ -96,9 +98,25 @@ func NewRandSenSpout(sents []string) loopy.Spout {
 }
 
 func main() {
-	g := CreateGraph()
+	trace := flag.Float64("trace", 0, "ratio of sentences to trace, spans are written to stderr")
+	aggregate := flag.Bool("aggregate", false, "pre-aggregate the counts before the shuffle")
+	flag.Parse()
+	var g *loopy.OGraph
+	if *aggregate {
+		g = CreateAggregateGraph()
+	} else {
+		g = CreateGraph()
+	}
+	var tracer *loopy.Tracer
+	if *trace > 0 {
+		tracer = loopy.NewTracer(loopy.NewJSONExporter(os.Stderr, "word-count"), loopy.RatioSampler(*trace))
+		g.EnableTracing(tracer)
+	}
 	g.Execute()
 	g.Wait()
+	if tracer != nil {
+		tracer.Flush()
+	}
 }
 
 func CreateGraph() *loopy.OGraph {
@@ -152,6 +170,46 @@ func CreateGraph() *loopy.OGraph {
 	g := loopy.NewOGraph()
 
 	g.List(5, h1).Group(5, 7, f, p).List(7, h2)
+	return g
+}
+
+// CreateAggregateGraph counts the words with a two-phase Aggregate,
+// so that only partial counts cross the shuffle.
+func CreateAggregateGraph() *loopy.OGraph {
+
+	spec := loopy.AggregateSpec{Aggregator: loopy.Counter{},
+		Expand: func(x loopy.T) []loopy.T {
+			words := strings.Fields(loopy.MessageV(x).(Tuple)["sentence"].(string))
+			ret := make([]loopy.T, len(words))
+			for i, w := range words {
+				ret[i] = w
+			}
+			return ret
+		},
+		Key:   func(x loopy.T) string { return x.(string) },
+		Every: 100}
+
+	printer := &loopy.Function{FuncName: "printer", Mapper: func(x loopy.T, params loopy.Params) loopy.T {
+		res := loopy.MessageV(x).(*loopy.AggResult)
+		fmt.Println(res.Key, res.Value)
+		return x
+	}}
+
+	h1 := func(g *loopy.OGraph, i int) (*loopy.Processor, *loopy.Processor) {
+		a := g.Source(NewRandSenSpout(sents))
+		return a.Proc, a.Proc
+	}
+
+	h2 := func(g *loopy.OGraph, i int) (*loopy.Processor, *loopy.Processor) {
+		s := g.Map(loopy.Functions{printer})
+		e := s.Ground()
+		return s.Proc, e.Proc
+	}
+
+	g := loopy.NewOGraph()
+
+	g.List(5, h1).Aggregate(5, 7, spec).List(7, h2)
+	return g
 }
 
 func GetBytes(key interface{}) ([]byte, error) {